@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+type WeekdayLoad struct {
+	Weekday string `json:"weekday"`
+	Events  int    `json:"events"`
+	Minutes int    `json:"minutes"`
+}
+
+type TaskAnalytics struct {
+	Due            int     `json:"due"`
+	Completed      int     `json:"completed"`
+	CompletionRate float64 `json:"completionRate"`
+	Overdue        int     `json:"overdue"`
+}
+
+type EstimateAccuracy struct {
+	Tasks            int     `json:"tasks"`
+	EstimatedMinutes int     `json:"estimatedMinutes"`
+	ActualMinutes    int     `json:"actualMinutes"`
+	AverageError     float64 `json:"averageError"`
+}
+
+type Analytics struct {
+	From             string            `json:"from"`
+	To               string            `json:"to"`
+	Timezone         string            `json:"timezone"`
+	Calendars        []TimeReportEntry `json:"calendars"`
+	Labels           []TimeReportEntry `json:"labels"`
+	Weekdays         []WeekdayLoad     `json:"weekdays"`
+	Tasks            TaskAnalytics     `json:"tasks"`
+	EstimateAccuracy EstimateAccuracy  `json:"estimateAccuracy"`
+}
+
+// GET /analytics
+func getAnalytics(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+
+	userId := session.Identity.Id
+	from, to, ok := parseRange(r)
+	if !ok {
+		http.Error(w, `{"error": "Invalid date range"}`, http.StatusBadRequest)
+		return
+	}
+
+	timezone := r.URL.Query().Get("timezone")
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid timezone"}`, http.StatusBadRequest)
+		return
+	}
+
+	analytics, err := store.Reports().Analytics(r.Context(), userId, from, to, location)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+	if analytics.Tasks.Due > 0 {
+		analytics.Tasks.CompletionRate = float64(analytics.Tasks.Completed) / float64(analytics.Tasks.Due)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(analytics)
+}
+
+// One entry per ISO weekday, Monday through Sunday.
+func emptyWeekdays() []WeekdayLoad {
+	weekdays := make([]WeekdayLoad, 7)
+	for i := range weekdays {
+		weekdays[i].Weekday = time.Weekday((i + 1) % 7).String()
+	}
+	return weekdays
+}
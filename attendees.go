@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type Attendee struct {
+	Email  string `json:"email"`
+	Status string `json:"status"`
+	Token  string `json:"-"`
+}
+
+var publicUrl string
+
+// Attendees belong to the whole series of a recurring event, so they are keyed
+// by the recurrence id when there is one.
+func eventKey(event Event) string {
+	if event.RecurrenceId != "" {
+		return event.RecurrenceId
+	}
+	return event.Id
+}
+
+// Stores the invitees of an event, each with their own RSVP token.
+func addAttendees(ctx context.Context, tx Store, event Event, emails []string) ([]Attendee, error) {
+	attendees := []Attendee{}
+	seen := make(map[string]bool)
+	for _, email := range emails {
+		email = strings.ToLower(strings.TrimSpace(email))
+		if email == "" || seen[email] {
+			continue
+		}
+		seen[email] = true
+
+		attendee := Attendee{Email: email, Status: "needs-action", Token: uuid.New().String()}
+		attendees = append(attendees, attendee)
+	}
+
+	if err := tx.Attendees().Add(ctx, eventKey(event), attendees...); err != nil {
+		return nil, err
+	}
+	return attendees, nil
+}
+
+// Returns the email of every attendee, plus the given addresses if they aren't
+// attendees already.
+func attendeeEmails(attendees []Attendee, extra ...string) []string {
+	emails := []string{}
+	seen := make(map[string]bool)
+	for _, attendee := range attendees {
+		emails = append(emails, attendee.Email)
+		seen[attendee.Email] = true
+	}
+	for _, email := range extra {
+		if !seen[strings.ToLower(email)] {
+			emails = append(emails, email)
+			seen[strings.ToLower(email)] = true
+		}
+	}
+	return emails
+}
+
+func rsvpUrl(attendee Attendee, response string) string {
+	return fmt.Sprintf("%s/rsvp/%s?response=%s", publicUrl, attendee.Token, response)
+}
+
+var rsvpResponses = []string{"accepted", "tentative", "declined"}
+
+// The locale of the attendee with the RSVP token, if they have an account, or
+// the default.
+func attendeeLocale(ctx context.Context, token string) string {
+	attendee, err := store.Attendees().GetByToken(ctx, token)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			log.Println("Error looking up RSVP token:", err)
+		}
+		return defaultLocale
+	}
+	settings, err := recipientSettings(ctx, store, []string{attendee.Email})
+	if err != nil {
+		log.Println("Error looking up attendee settings:", err)
+		return defaultLocale
+	}
+	return settings[attendee.Email].Locale
+}
+
+// GET /rsvp/{token}?response=accepted|declined|tentative
+// POST /rsvp/{token}?response=accepted|declined|tentative
+// Linked from invitation emails, so it works without a session. GET only asks
+// to confirm the response, which the POST records.
+func respondToInvitation(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	token := vars["token"]
+	response := r.URL.Query().Get("response")
+
+	if !slices.Contains(rsvpResponses, response) {
+		http.Error(w, "Invalid response", http.StatusBadRequest)
+		return
+	}
+	locale := attendeeLocale(r.Context(), token)
+	if r.Method == http.MethodGet {
+		renderConfirmation(w, Confirmation{
+			Locale:  locale,
+			Title:   translate(locale, "page.rsvp.title."+response),
+			Message: translate(locale, "page.rsvp.confirm."+response),
+			Button:  translate(locale, "page.rsvp.button."+response),
+		})
+		return
+	}
+
+	err := store.Attendees().Respond(r.Context(), token, response)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, translate(locale, "page.rsvp.done", translate(locale, "status."+response)))
+}
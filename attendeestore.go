@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+type PostgresAttendeeStore struct {
+	db Querier
+}
+
+func (s *PostgresAttendeeStore) List(ctx context.Context, eventKey string) ([]Attendee, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	rows, err := s.db.Query(ctx,
+		`
+		SELECT email, status, token FROM event_attendees
+		WHERE event_key::text = $1
+		ORDER BY email ASC
+		`,
+		eventKey,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attendees := []Attendee{}
+	for rows.Next() {
+		var attendee Attendee
+		if err := rows.Scan(&attendee.Email, &attendee.Status, &attendee.Token); err != nil {
+			return nil, err
+		}
+		attendees = append(attendees, attendee)
+	}
+	return attendees, rows.Err()
+}
+
+func (s *PostgresAttendeeStore) Add(ctx context.Context, eventKey string, attendees ...Attendee) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	rows := make([][]any, 0, len(attendees))
+	for _, attendee := range attendees {
+		rows = append(rows, []any{eventKey, attendee.Email, attendee.Status, attendee.Token})
+	}
+
+	columns := []string{"event_key", "email", "status", "token"}
+	return withTransaction(ctx, s.db, func(q Querier) error {
+		return insertRows(ctx, q, "event_attendees", columns, rows, "ON CONFLICT DO NOTHING")
+	})
+}
+
+func (s *PostgresAttendeeStore) RemoveOrphaned(ctx context.Context, eventKey string) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	_, err := s.db.Exec(ctx,
+		`
+		DELETE FROM event_attendees
+		WHERE event_key = $1
+		AND NOT EXISTS (SELECT 1 FROM events WHERE id = $1 OR recurrence_id = $1)
+		`,
+		eventKey,
+	)
+	return err
+}
+
+func (s *PostgresAttendeeStore) GetByToken(ctx context.Context, token string) (Attendee, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	if _, err := uuid.Parse(token); err != nil {
+		return Attendee{}, ErrNotFound
+	}
+
+	var attendee Attendee
+	err := s.db.QueryRow(ctx,
+		"SELECT email, status, token FROM event_attendees WHERE token = $1",
+		token,
+	).Scan(&attendee.Email, &attendee.Status, &attendee.Token)
+	if err == pgx.ErrNoRows {
+		return Attendee{}, ErrNotFound
+	}
+	return attendee, err
+}
+
+func (s *PostgresAttendeeStore) Respond(ctx context.Context, token string, status string) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	if _, err := uuid.Parse(token); err != nil {
+		return ErrNotFound
+	}
+
+	return expectRows(s.db.Exec(ctx,
+		`
+		UPDATE event_attendees
+		SET status = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE token = $2
+		`,
+		status,
+		token,
+	))
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+type contextKey string
+
+const sessionKey contextKey = "session"
+
+// Requires an active session, from a cookie or a bearer token, for every route
+// it wraps, and stores the session in the request context for the handlers to
+// read with currentSession.
+func requireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := getSession(r)
+		if session == nil || !session.Active {
+			unauthorized(w)
+			return
+		}
+		if !session.Allows(r) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error": "Token scope does not allow this request"}` + "\n"))
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), sessionKey, session)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Returns the session requireSession stored for the request. Only call it from
+// handlers behind requireSession, where it is never nil.
+func currentSession(r *http.Request) *Session {
+	session, _ := r.Context().Value(sessionKey).(*Session)
+	return session
+}
+
+// http.Error would send the JSON body as text/plain.
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write([]byte(`{"error": "Unauthorized"}` + "\n"))
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 
 	"github.com/google/uuid"
@@ -9,56 +12,30 @@ import (
 )
 
 type Calendar struct {
-	Id        string   `json:"id" database:"id"`
-	Name      string   `json:"name" database:"name"`
-	Color     string   `json:"color" database:"color"`
-	IsDefault bool     `json:"isDefault" database:"is_default"`
-	Members   []string `json:"members" database:"members"`
+	Id        string   `json:"id"`
+	Name      string   `json:"name"`
+	Color     string   `json:"color"`
+	IsDefault bool     `json:"isDefault"`
+	Members   []string `json:"members"`
+}
+
+func isCalendarMember(ctx context.Context, userId string, calendarId string) bool {
+	member, err := store.Calendars().IsMember(ctx, calendarId, userId)
+	if err != nil {
+		log.Println("Error checking calendar membership:", err)
+	}
+	return err == nil && member
 }
 
 // GET /calendars
 func getCalendars(w http.ResponseWriter, r *http.Request) {
-	session := getSession(r)
-	if session == nil {
-		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
-		return
-	}
+	session := currentSession(r)
 
-	calendars := []Calendar{}
-	Query(&calendars,
-		`
-		SELECT calendars.id AS id, calendars.name AS name, calendars.color AS color, calendars.is_default AS isDefault
-		FROM calendar_members
-		JOIN calendars ON calendars.id = calendar_members.calendar_id
-		WHERE user_id = $1
-		`,
-		session.Identity.Id,
-	)
-
-	if len(calendars) == 0 {
-		calendars = []Calendar{}
-	} else {
-		for idx, calendar := range calendars {
-			members := []struct {
-				UserId string `json:"userId" database:"user_id"`
-			}{}
-
-			Query(&members,
-				`
-				SELECT user_id
-				FROM calendar_members
-				WHERE calendar_id = $1
-				`,
-				calendar.Id,
-			)
-			userIds := make([]string, len(members))
-
-			for i, member := range members {
-				userIds[i] = member.UserId
-			}
-
-			calendars[idx].Members = userIds
-		}
+	calendars, err := store.Calendars().ListForUser(r.Context(), session.Identity.Id)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -67,11 +44,7 @@ func getCalendars(w http.ResponseWriter, r *http.Request) {
 
 // POST /calendars
 func createCalendar(w http.ResponseWriter, r *http.Request) {
-	session := getSession(r)
-	if session == nil {
-		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
-		return
-	}
+	session := currentSession(r)
 
 	var calendar Calendar
 	err := json.NewDecoder(r.Body).Decode(&calendar)
@@ -82,33 +55,12 @@ func createCalendar(w http.ResponseWriter, r *http.Request) {
 
 	calendar.Id = uuid.New().String()
 	if calendar.IsDefault {
-		calendar.Color = "#93c4fd"
-	}
-
-	_, err = Execute(
-		`
-		INSERT INTO calendars (id, name, color, is_default)
-		VALUES ($1, $2, $3, $4)
-		`,
-		calendar.Id,
-		calendar.Name,
-		calendar.Color,
-		calendar.IsDefault,
-	)
-	if err != nil {
-		http.Error(w, `{"error": "Error creating calendar"}`, http.StatusInternalServerError)
-		return
+		calendar.Color = defaultCalendarColor
 	}
+	calendar.Members = []string{session.Identity.Id}
 
-	_, err = Execute(
-		`
-		INSERT INTO calendar_members (calendar_id, user_id)
-		VALUES ($1, $2)
-		`,
-		calendar.Id,
-		session.Identity.Id,
-	)
-	if err != nil {
+	if err := store.Calendars().Create(r.Context(), calendar, session.Identity.Id); err != nil {
+		log.Println(err)
 		http.Error(w, `{"error": "Error creating calendar"}`, http.StatusInternalServerError)
 		return
 	}
@@ -118,15 +70,10 @@ func createCalendar(w http.ResponseWriter, r *http.Request) {
 }
 
 // PUT /calendars/{id}
+// Only the owner of the calendar can change it.
 func updateCalendar(w http.ResponseWriter, r *http.Request) {
-	session := getSession(r)
-	if session == nil {
-		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
-		return
-	}
-
+	session := currentSession(r)
 	vars := mux.Vars(r)
-	calendarId := vars["id"]
 
 	var calendar Calendar
 	err := json.NewDecoder(r.Body).Decode(&calendar)
@@ -134,18 +81,15 @@ func updateCalendar(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
 		return
 	}
+	calendar.Id = vars["id"]
 
-	_, err = Execute(
-		`
-		UPDATE calendars
-		SET name = $1, color = $2
-		WHERE id = $3
-		`,
-		calendar.Name,
-		calendar.Color,
-		calendarId,
-	)
+	err = store.Calendars().Update(r.Context(), calendar, session.Identity.Id)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, `{"error": "Calendar not found"}`, http.StatusNotFound)
+		return
+	}
 	if err != nil {
+		log.Println(err)
 		http.Error(w, `{"error": "Error updating calendar"}`, http.StatusInternalServerError)
 		return
 	}
@@ -155,24 +99,19 @@ func updateCalendar(w http.ResponseWriter, r *http.Request) {
 }
 
 // DELETE /calendars/{id}
+// Only the owner of the calendar can delete it.
 func deleteCalendar(w http.ResponseWriter, r *http.Request) {
-	session := getSession(r)
-	if session == nil {
-		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
-		return
-	}
-
+	session := currentSession(r)
 	vars := mux.Vars(r)
 	calendarId := vars["id"]
 
-	_, err := Execute(
-		`
-		DELETE FROM calendars
-		WHERE id = $1
-		`,
-		calendarId,
-	)
+	err := store.Calendars().Delete(r.Context(), calendarId, session.Identity.Id)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, `{"error": "Calendar not found"}`, http.StatusNotFound)
+		return
+	}
 	if err != nil {
+		log.Println(err)
 		http.Error(w, `{"error": "Error deleting calendar"}`, http.StatusInternalServerError)
 		return
 	}
@@ -181,12 +120,9 @@ func deleteCalendar(w http.ResponseWriter, r *http.Request) {
 }
 
 // POST /calendars/{id}/members
+// Only the owner of the calendar can add members to it.
 func addCalendarMember(w http.ResponseWriter, r *http.Request) {
-	session := getSession(r)
-	if session == nil {
-		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
-		return
-	}
+	session := currentSession(r)
 
 	vars := mux.Vars(r)
 	calendarId := vars["id"]
@@ -200,15 +136,13 @@ func addCalendarMember(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = Execute(
-		`
-		INSERT INTO calendar_members (calendar_id, user_id)
-		VALUES ($1, $2)
-		`,
-		calendarId,
-		member.UserId,
-	)
+	err = store.Calendars().AddMember(r.Context(), calendarId, member.UserId, session.Identity.Id)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, `{"error": "Calendar not found"}`, http.StatusNotFound)
+		return
+	}
 	if err != nil {
+		log.Println(err)
 		http.Error(w, `{"error": "Error adding member to calendar"}`, http.StatusInternalServerError)
 		return
 	}
@@ -218,33 +152,19 @@ func addCalendarMember(w http.ResponseWriter, r *http.Request) {
 
 // DELETE /calendars/{id}/members/{userId}
 func removeCalendarMember(w http.ResponseWriter, r *http.Request) {
-	session := getSession(r)
-	if session == nil {
-		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
-		return
-	}
+	session := currentSession(r)
 
 	vars := mux.Vars(r)
 	calendarId := vars["id"]
 	userId := vars["userId"]
 
-	_, err := Execute(
-		`
-		DELETE FROM calendar_members
-		WHERE
-		calendar_id = $1
-		AND user_id = $2
-		AND calendar_id IN (
-			SELECT id
-			FROM calendars
-			WHERE user_id = $3
-		)
-		`,
-		calendarId,
-		userId,
-		session.Identity.Id,
-	)
+	err := store.Calendars().RemoveMember(r.Context(), calendarId, userId, session.Identity.Id)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, `{"error": "Member not found"}`, http.StatusNotFound)
+		return
+	}
 	if err != nil {
+		log.Println(err)
 		http.Error(w, `{"error": "Error removing member from calendar"}`, http.StatusInternalServerError)
 		return
 	}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+type PostgresCalendarStore struct {
+	db Querier
+}
+
+func (s *PostgresCalendarStore) ListForUser(ctx context.Context, userId string) ([]Calendar, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	rows, err := s.db.Query(ctx,
+		`
+		SELECT calendars.id, calendars.name, calendars.color, calendars.is_default
+		FROM calendar_members
+		JOIN calendars ON calendars.id = calendar_members.calendar_id
+		WHERE calendar_members.user_id = $1
+		ORDER BY calendars.is_default DESC, calendars.name ASC
+		`,
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	calendars := []Calendar{}
+	indexes := map[string]int{}
+	for rows.Next() {
+		calendar := Calendar{Members: []string{}}
+		if err := rows.Scan(&calendar.Id, &calendar.Name, &calendar.Color, &calendar.IsDefault); err != nil {
+			return nil, err
+		}
+		indexes[calendar.Id] = len(calendars)
+		calendars = append(calendars, calendar)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	members, err := s.db.Query(ctx,
+		`
+		SELECT calendar_id, user_id FROM calendar_members
+		WHERE calendar_id IN (SELECT calendar_id FROM calendar_members WHERE user_id = $1)
+		ORDER BY created_at ASC, user_id ASC
+		`,
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer members.Close()
+
+	for members.Next() {
+		var calendarId, memberId string
+		if err := members.Scan(&calendarId, &memberId); err != nil {
+			return nil, err
+		}
+		if idx, ok := indexes[calendarId]; ok {
+			calendars[idx].Members = append(calendars[idx].Members, memberId)
+		}
+	}
+	return calendars, members.Err()
+}
+
+func (s *PostgresCalendarStore) IsMember(ctx context.Context, calendarId string, userId string) (bool, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	if _, err := uuid.Parse(calendarId); err != nil {
+		return false, nil
+	}
+
+	var exists bool
+	err := s.db.QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM calendar_members WHERE calendar_id = $1 AND user_id = $2)",
+		calendarId,
+		userId,
+	).Scan(&exists)
+	return exists, err
+}
+
+func (s *PostgresCalendarStore) HasDefault(ctx context.Context, userId string) (bool, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	var exists bool
+	err := s.db.QueryRow(ctx,
+		`
+		SELECT EXISTS (
+			SELECT 1 FROM calendars
+			JOIN calendar_members ON calendar_members.calendar_id = calendars.id
+			WHERE calendar_members.user_id = $1 AND calendars.is_default
+		)
+		`,
+		userId,
+	).Scan(&exists)
+	return exists, err
+}
+
+func (s *PostgresCalendarStore) Create(ctx context.Context, calendar Calendar, ownerId string) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	return withTransaction(ctx, s.db, func(q Querier) error {
+		_, err := q.Exec(ctx,
+			`
+			INSERT INTO calendars (id, user_id, name, color, is_default)
+			VALUES ($1, $2, $3, $4, $5)
+			`,
+			calendar.Id,
+			ownerId,
+			calendar.Name,
+			calendar.Color,
+			calendar.IsDefault,
+		)
+		if err != nil {
+			return err
+		}
+
+		return (&PostgresCalendarStore{db: q}).AddMember(ctx, calendar.Id, ownerId, ownerId)
+	})
+}
+
+func (s *PostgresCalendarStore) Update(ctx context.Context, calendar Calendar, ownerId string) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	if _, err := uuid.Parse(calendar.Id); err != nil {
+		return ErrNotFound
+	}
+
+	return expectRows(s.db.Exec(ctx,
+		`
+		UPDATE calendars
+		SET name = $1, color = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3 AND user_id = $4
+		`,
+		calendar.Name,
+		calendar.Color,
+		calendar.Id,
+		ownerId,
+	))
+}
+
+func (s *PostgresCalendarStore) Delete(ctx context.Context, id string, ownerId string) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrNotFound
+	}
+
+	return expectRows(s.db.Exec(ctx, "DELETE FROM calendars WHERE id = $1 AND user_id = $2", id, ownerId))
+}
+
+func (s *PostgresCalendarStore) AddMember(ctx context.Context, calendarId string, userId string, ownerId string) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	if _, err := uuid.Parse(calendarId); err != nil {
+		return ErrNotFound
+	}
+
+	return expectRows(s.db.Exec(ctx,
+		`
+		INSERT INTO calendar_members (calendar_id, user_id)
+		SELECT id, $2 FROM calendars
+		WHERE id = $1 AND user_id = $3
+		`,
+		calendarId,
+		userId,
+		ownerId,
+	))
+}
+
+func (s *PostgresCalendarStore) RemoveMember(ctx context.Context, calendarId string, userId string, ownerId string) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	if _, err := uuid.Parse(calendarId); err != nil {
+		return ErrNotFound
+	}
+
+	return expectRows(s.db.Exec(ctx,
+		`
+		DELETE FROM calendar_members
+		WHERE calendar_id = $1
+		AND user_id = $2
+		AND calendar_id IN (SELECT id FROM calendars WHERE user_id = $3)
+		`,
+		calendarId,
+		userId,
+		ownerId,
+	))
+}
@@ -1,72 +1,125 @@
 package main
 
 import (
-	"database/sql"
-	"reflect"
+	"context"
+	"fmt"
 	"strings"
 
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-var db *sql.DB
+// Every store backed by Postgres, on pool or on a transaction from it.
+type PostgresStore struct {
+	db Querier
+}
 
-func Query[T any](arr *[]T, query_ string, args ...any) {
-	rows, err := db.Query(query_, args...)
-	if err != nil {
-		panic(err)
-	}
-	defer rows.Close()
+func (s *PostgresStore) Calendars() CalendarStore { return &PostgresCalendarStore{db: s.db} }
+func (s *PostgresStore) Events() EventStore       { return &PostgresEventStore{db: s.db} }
+func (s *PostgresStore) Tasks() TaskStore         { return &PostgresTaskStore{db: s.db} }
+func (s *PostgresStore) Labels() LabelStore       { return &PostgresLabelStore{db: s.db} }
+func (s *PostgresStore) Reminders() ReminderStore { return &PostgresReminderStore{db: s.db} }
+func (s *PostgresStore) Notifications() NotificationStore {
+	return &PostgresNotificationStore{db: s.db}
+}
+func (s *PostgresStore) Settings() SettingsStore  { return &PostgresSettingsStore{db: s.db} }
+func (s *PostgresStore) Digests() DigestStore     { return &PostgresDigestStore{db: s.db} }
+func (s *PostgresStore) Timers() TimerStore       { return &PostgresTimerStore{db: s.db} }
+func (s *PostgresStore) Reports() ReportStore     { return &PostgresReportStore{db: s.db} }
+func (s *PostgresStore) Attendees() AttendeeStore { return &PostgresAttendeeStore{db: s.db} }
+func (s *PostgresStore) Outbox() OutboxStore      { return &PostgresOutboxStore{db: s.db} }
+func (s *PostgresStore) Tokens() TokenStore       { return &PostgresTokenStore{db: s.db} }
+func (s *PostgresStore) Users() UserStore         { return &PostgresUserStore{db: s.db} }
 
-	structType := reflect.TypeOf(arr).Elem().Elem()
+// The transaction is also rolled back if ctx is cancelled before it commits.
+func (s *PostgresStore) Transaction(ctx context.Context, fn func(tx Store) error) error {
+	return withTransaction(ctx, s.db, func(q Querier) error {
+		return fn(&PostgresStore{db: q})
+	})
+}
 
-	var fields []string
-	cols, err := rows.Columns()
-	for _, col := range cols {
-		found := false
-		for i := 0; i < structType.NumField(); i++ {
-			if strings.EqualFold(structType.Field(i).Name, col) || strings.EqualFold(structType.Field(i).Tag.Get("database"), col) {
-				fields = append(fields, structType.Field(i).Name)
-				found = true
-			}
-		}
-		if !found {
-			fields = append(fields, "")
-		}
+// Runs fn in a transaction on q, or as part of the transaction q already is.
+// Lets a store method that writes several statements be atomic whether or not
+// its caller started a transaction.
+func withTransaction(ctx context.Context, q Querier, fn func(q Querier) error) error {
+	p, ok := q.(*pgxpool.Pool)
+	if !ok {
+		return fn(q)
 	}
 
-	for rows.Next() {
-		newStruct := reflect.New(structType).Elem()
-		var properties []interface{}
+	tx, err := p.Begin(ctx)
+	if err != nil {
+		return err
+	}
 
-		for _, field := range fields {
-			if field == "" {
-				var nothing interface{}
-				properties = append(properties, &nothing)
-			} else {
-				properties = append(properties, newStruct.FieldByName(field).Addr().Interface())
-			}
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			tx.Rollback(ctx)
+			panic(recovered)
 		}
+	}()
 
-		rows.Scan(properties...)
-		*arr = append(*arr, newStruct.Interface().(T))
+	if err := fn(tx); err != nil {
+		tx.Rollback(ctx)
+		return err
 	}
+	return tx.Commit(ctx)
 }
 
-func QueryValue[T any](value *T, query_ string, args ...any) error {
-	err := db.QueryRow(query_, args...).Scan(value)
-	return err
-}
+// Postgres allows at most this many parameters in one statement.
+var maxParameters = 65535
 
-func Execute(exec string, args ...any) (sql.Result, error) {
-	result, err := db.Exec(exec, args...)
-	return result, err
-}
+// Inserts rows into table with as few statements as the parameter limit
+// allows. suffix is appended to each statement, e.g. for ON CONFLICT. Callers
+// inserting more than one statement's worth should be in a transaction.
+func insertRows(ctx context.Context, q Querier, table string, columns []string, rows [][]any, suffix string) error {
+	batchSize := maxParameters / len(columns)
+	for start := 0; start < len(rows); start += batchSize {
+		batch := rows[start:min(start+batchSize, len(rows))]
+
+		var query strings.Builder
+		fmt.Fprintf(&query, "INSERT INTO %s (%s) VALUES ", table, strings.Join(columns, ", "))
+		args := make([]any, 0, len(batch)*len(columns))
+		for idx, row := range batch {
+			if len(row) != len(columns) {
+				return fmt.Errorf("inserting into %s: row has %d values for %d columns", table, len(row), len(columns))
+			}
+			if idx > 0 {
+				query.WriteString(", ")
+			}
+			query.WriteString("(")
+			for column := range row {
+				if column > 0 {
+					query.WriteString(", ")
+				}
+				fmt.Fprintf(&query, "$%d", len(args)+column+1)
+			}
+			query.WriteString(")")
+			args = append(args, row...)
+		}
+		if suffix != "" {
+			query.WriteString(" " + suffix)
+		}
 
-func InitDatabase(dbUrl string) {
-	var err error
-	db, err = sql.Open("pgx", dbUrl)
+		if _, err := q.Exec(ctx, query.String(), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// Opens the connection pool and waits for the database to answer, giving up
+// if ctx is cancelled first.
+func InitDatabase(ctx context.Context, config DatabaseConfig) error {
+	p, err := newPool(config)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if err := pingDatabase(ctx, p, config.ConnectTimeout); err != nil {
+		p.Close()
+		return err
 	}
+
+	pool = p
+	store = &PostgresStore{db: pool}
+	return nil
 }
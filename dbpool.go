@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Tunes the connection pool. Zero sizes keep pgx's defaults, which can also be
+// set with pool_max_conns and friends in the URL.
+type DatabaseConfig struct {
+	Url string
+
+	MaxConns          int32
+	MinConns          int32
+	MaxConnLifetime   time.Duration
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration
+
+	// Prepared statements kept per connection. Zero turns caching off, which is
+	// needed behind poolers like PgBouncer in transaction mode.
+	StatementCacheSize int
+
+	// How long to keep retrying the database on startup before giving up.
+	ConnectTimeout time.Duration
+}
+
+var pool *pgxpool.Pool
+
+// Backoff between startup pings, doubling from the first up to the last.
+var connectRetryMin = 500 * time.Millisecond
+var connectRetryMax = 10 * time.Second
+
+func init() {
+	expvar.Publish("database_pool", expvar.Func(func() any {
+		if pool == nil {
+			return nil
+		}
+		stat := pool.Stat()
+		return map[string]any{
+			"total_conns":          stat.TotalConns(),
+			"acquired_conns":       stat.AcquiredConns(),
+			"idle_conns":           stat.IdleConns(),
+			"constructing_conns":   stat.ConstructingConns(),
+			"max_conns":            stat.MaxConns(),
+			"acquire_count":        stat.AcquireCount(),
+			"acquire_duration_ms":  stat.AcquireDuration().Milliseconds(),
+			"empty_acquire_count":  stat.EmptyAcquireCount(),
+			"canceled_acquires":    stat.CanceledAcquireCount(),
+			"new_conns":            stat.NewConnsCount(),
+			"max_lifetime_destroy": stat.MaxLifetimeDestroyCount(),
+			"max_idle_destroy":     stat.MaxIdleDestroyCount(),
+		}
+	}))
+}
+
+// Reads the pool settings from DATABASE_* environment variables.
+func LoadDatabaseConfig() (DatabaseConfig, error) {
+	config := DatabaseConfig{Url: os.Getenv("DATABASE_URL")}
+	if config.Url == "" {
+		return config, fmt.Errorf("DATABASE_URL must be set")
+	}
+
+	sizes := map[string]*int32{
+		"DATABASE_MAX_CONNS": &config.MaxConns,
+		"DATABASE_MIN_CONNS": &config.MinConns,
+	}
+	for key, size := range sizes {
+		if value := os.Getenv(key); value != "" {
+			n, err := strconv.ParseInt(value, 10, 32)
+			if err != nil || n < 0 {
+				return config, fmt.Errorf("invalid %s", key)
+			}
+			*size = int32(n)
+		}
+	}
+
+	durations := map[string]*time.Duration{
+		"DATABASE_MAX_CONN_LIFETIME":   &config.MaxConnLifetime,
+		"DATABASE_MAX_CONN_IDLE_TIME":  &config.MaxConnIdleTime,
+		"DATABASE_HEALTH_CHECK_PERIOD": &config.HealthCheckPeriod,
+		"DATABASE_CONNECT_TIMEOUT":     &config.ConnectTimeout,
+	}
+	defaults := map[string]string{
+		"DATABASE_MAX_CONN_LIFETIME":   "1h",
+		"DATABASE_MAX_CONN_IDLE_TIME":  "30m",
+		"DATABASE_HEALTH_CHECK_PERIOD": "1m",
+		"DATABASE_CONNECT_TIMEOUT":     "1m",
+	}
+	for key, duration := range durations {
+		value, err := time.ParseDuration(getEnv(key, defaults[key]))
+		if err != nil || value <= 0 {
+			return config, fmt.Errorf("invalid %s", key)
+		}
+		*duration = value
+	}
+
+	cacheSize, err := strconv.Atoi(getEnv("DATABASE_STATEMENT_CACHE_SIZE", "512"))
+	if err != nil || cacheSize < 0 {
+		return config, fmt.Errorf("invalid DATABASE_STATEMENT_CACHE_SIZE")
+	}
+	config.StatementCacheSize = cacheSize
+
+	if config.MaxConns > 0 && config.MinConns > config.MaxConns {
+		return config, fmt.Errorf("DATABASE_MIN_CONNS can't be more than DATABASE_MAX_CONNS")
+	}
+	return config, nil
+}
+
+func newPool(config DatabaseConfig) (*pgxpool.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(config.Url)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.MaxConns > 0 {
+		poolConfig.MaxConns = config.MaxConns
+	}
+	if config.MinConns > 0 {
+		poolConfig.MinConns = config.MinConns
+	}
+	poolConfig.MaxConnLifetime = config.MaxConnLifetime
+	poolConfig.MaxConnIdleTime = config.MaxConnIdleTime
+	poolConfig.HealthCheckPeriod = config.HealthCheckPeriod
+
+	// Cached statements are prepared once per connection and reused by name.
+	// Without the cache each query is still sent as an unnamed statement, so
+	// parameters stay out of the SQL text.
+	poolConfig.ConnConfig.StatementCacheCapacity = config.StatementCacheSize
+	if config.StatementCacheSize == 0 {
+		poolConfig.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeExec
+	}
+
+	// The pool outlives startup, so it doesn't take the startup context
+	return pgxpool.NewWithConfig(context.Background(), poolConfig)
+}
+
+// The pool connects lazily, so ping until the database answers, backing off
+// between attempts, rather than failing on the first request. Gives up once the
+// connect timeout passes.
+func pingDatabase(ctx context.Context, p *pgxpool.Pool, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	delay := connectRetryMin
+	for attempt := 1; ; attempt++ {
+		err := p.Ping(ctx)
+		if err == nil {
+			return nil
+		}
+		log.Printf("Database not reachable (attempt %d): %v", attempt, err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return fmt.Errorf("database not reachable after %s: %w", timeout, err)
+		}
+		delay = min(delay*2, connectRetryMax)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Stands in for a real identity provider when ENVIRONMENT is development. Each
+// request acts as the user named by the X-Dev-User header or dev_user cookie
+// (an id, email or username), or as the first fixture user if neither is set.
+// The fixtures come from DEV_USERS_PATH, in the static provider's format, or
+// default to developmentUsers.
+type DevProvider struct {
+	StaticProvider
+}
+
+var devUserHeader = "X-Dev-User"
+var devUserCookie = "dev_user"
+
+// Stand-ins for real accounts when running locally
+var developmentUsers = []StaticUser{
+	{
+		User: User{
+			Id:        "b849d4e4-de61-4c27-b6c6-7f2566f7079f",
+			Email:     "prayuj@prayujt.com",
+			FirstName: "Prayuj",
+			LastName:  "Tuli",
+			Username:  "prayujt",
+			Avatar:    "https://static.prayujt.com/images/PRAYUJ.jpg",
+		},
+	},
+	{
+		User: User{
+			Id:        "075a7914-1b03-4e02-b883-894857198a25",
+			Email:     "test@email.com",
+			FirstName: "Test",
+			LastName:  "User",
+			Username:  "testuser",
+			Avatar:    "",
+		},
+	},
+}
+
+func NewDevProvider(path string) (*DevProvider, error) {
+	if path == "" {
+		return &DevProvider{StaticProvider{users: developmentUsers}}, nil
+	}
+	provider, err := NewStaticProvider(path)
+	if err != nil {
+		return nil, err
+	}
+	return &DevProvider{*provider}, nil
+}
+
+// Unknown users are rejected rather than falling back to the default, so a typo
+// doesn't silently act as someone else.
+func (d *DevProvider) Session(r *http.Request) (*Session, error) {
+	// Checked again here in case the provider is ever wired up by mistake
+	if environment != "development" || len(d.users) == 0 {
+		return nil, nil
+	}
+
+	name := r.Header.Get(devUserHeader)
+	if cookie, err := r.Cookie(devUserCookie); name == "" && err == nil {
+		name = cookie.Value
+	}
+	if name == "" {
+		return &Session{Id: d.users[0].Id, Active: true, Identity: d.users[0].User.Identity()}, nil
+	}
+
+	for _, user := range d.users {
+		if user.Id == name || strings.EqualFold(user.Email, name) || (user.Username != "" && user.Username == name) {
+			return &Session{Id: user.Id, Active: true, Identity: user.User.Identity()}, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Digests go out in the morning of the user's time zone. A digest missed while
+// the server was down is still sent later that morning, but not after.
+var digestHour = 7
+var digestWindow = 4
+
+type DigestEvent struct {
+	Time     string
+	Title    string
+	Location string
+}
+
+type DigestDay struct {
+	Date   string
+	Events []DigestEvent
+}
+
+type DigestTask struct {
+	Title   string
+	Due     string
+	Overdue bool
+}
+
+// Everything the digest templates can show, already formatted for the recipient.
+type DigestMail struct {
+	Locale      string
+	Heading     string
+	Days        []DigestDay
+	Tasks       []DigestTask
+	From        string
+	Unsubscribe string
+}
+
+// list is "daily" or "weekly" for the digests, or "reminders" for reminder emails.
+func unsubscribeUrl(settings Settings, list string) string {
+	return fmt.Sprintf("%s/unsubscribe/%s?list=%s", publicUrl, settings.UnsubscribeToken, list)
+}
+
+// Builds the digest covering the days from start, listing the user's events on
+// each day and their unfinished tasks due before the last day ends.
+func newDigestMail(ctx context.Context, kind string, settings Settings, start time.Time, days int) (DigestMail, error) {
+	location := settings.Location()
+	end := start.AddDate(0, 0, days)
+
+	mail := DigestMail{
+		Locale:      settings.Locale,
+		Heading:     translate(settings.Locale, "digest.heading."+kind, formatDate(settings.Locale, start)),
+		Days:        []DigestDay{},
+		Tasks:       []DigestTask{},
+		From:        fromName,
+		Unsubscribe: unsubscribeUrl(settings, kind),
+	}
+
+	events, err := store.Events().ListBetween(ctx, settings.UserId, start, end)
+	if err != nil {
+		return mail, err
+	}
+
+	for day := 0; day < days; day++ {
+		date := start.AddDate(0, 0, day)
+		mail.Days = append(mail.Days, DigestDay{Date: formatDate(settings.Locale, date), Events: []DigestEvent{}})
+	}
+	for _, event := range events {
+		date, err := time.Parse(time.RFC3339, event.Date)
+		if err != nil {
+			return mail, err
+		}
+		date = date.In(location)
+
+		// Days are counted by calendar date so daylight saving changes don't shift them
+		day := 0
+		for day < days-1 && !date.Before(start.AddDate(0, 0, day+1)) {
+			day++
+		}
+
+		digestEvent := DigestEvent{Time: formatClock(settings.Locale, date), Title: event.Title}
+		if event.Location != nil {
+			digestEvent.Location = *event.Location
+		}
+		mail.Days[day].Events = append(mail.Days[day].Events, digestEvent)
+	}
+
+	tasks, err := store.Tasks().ListDue(ctx, settings.UserId, end)
+	if err != nil {
+		return mail, err
+	}
+
+	for _, task := range tasks {
+		deadline, err := time.Parse(time.RFC3339, task.Deadline)
+		if err != nil {
+			return mail, err
+		}
+		deadline = deadline.In(location)
+
+		mail.Tasks = append(mail.Tasks, DigestTask{
+			Title:   task.Title,
+			Due:     formatTime(settings.Locale, deadline),
+			Overdue: deadline.Before(start),
+		})
+	}
+	return mail, nil
+}
+
+// Renders the subject, plain text and HTML bodies of a digest.
+func RenderDigestMail(kind string, mail DigestMail, start time.Time) (string, string, string, error) {
+	text, html, err := renderMail(digestTextTemplate, digestHtmlTemplate, "digest", mail.Locale, mail)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	subject := translate(mail.Locale, "subject.digest."+kind, formatDate(mail.Locale, start))
+	return subject, text, html, nil
+}
+
+// The digest is claimed before it is queued, so each user gets at most one of
+// each kind per day even with several replicas.
+func queueDigest(ctx context.Context, tx Store, kind string, email string, settings Settings, now time.Time) error {
+	// The daily digest covers today, the weekly one the seven days from tomorrow
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	days := 1
+	if kind == "weekly" {
+		start = start.AddDate(0, 0, 1)
+		days = 7
+	}
+
+	mail, err := newDigestMail(ctx, kind, settings, start, days)
+	if err != nil {
+		return err
+	}
+	subject, text, html, err := RenderDigestMail(kind, mail, start)
+	if err != nil {
+		return err
+	}
+
+	message, err := OutgoingMail{
+		To:          []string{email},
+		Subject:     subject,
+		Text:        text,
+		HTML:        html,
+		Unsubscribe: mail.Unsubscribe,
+	}.Bytes()
+	if err != nil {
+		return err
+	}
+	return EnqueueMessage(ctx, tx, []string{email}, message)
+}
+
+func DeliverDigests(ctx context.Context) {
+	subscribers, err := store.Digests().Subscribers(ctx)
+	if err != nil {
+		log.Println("Error finding digest subscribers:", err)
+		return
+	}
+	if len(subscribers) == 0 {
+		return
+	}
+
+	users, err := store.Users().List(ctx)
+	if err != nil {
+		log.Println("Error listing users for digests:", err)
+		return
+	}
+	emails := make(map[string]string)
+	for _, user := range users {
+		emails[user.Id] = user.Email
+	}
+
+	now := time.Now()
+	for _, settings := range subscribers {
+		local := now.In(settings.Location())
+		if local.Hour() < digestHour || local.Hour() >= digestHour+digestWindow {
+			continue
+		}
+		email := emails[settings.UserId]
+		if email == "" {
+			continue
+		}
+
+		kinds := []string{}
+		if settings.DailyDigest {
+			kinds = append(kinds, "daily")
+		}
+		if settings.WeeklyDigest && local.Weekday() == time.Sunday {
+			kinds = append(kinds, "weekly")
+		}
+
+		for _, kind := range kinds {
+			err := store.Transaction(ctx, func(tx Store) error {
+				claimed, err := tx.Digests().Claim(ctx, settings.UserId, kind, local.Format("2006-01-02"))
+				if err != nil || !claimed {
+					return err
+				}
+				return queueDigest(ctx, tx, kind, email, settings, local)
+			})
+			if err != nil {
+				log.Printf("Error delivering %s digest to user %s: %v", kind, settings.UserId, err)
+			}
+		}
+	}
+}
+
+// Runs until the process exits, checking for digests to send every interval.
+func RunDigestScheduler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		DeliverDigests(context.Background())
+		<-ticker.C
+	}
+}
+
+// The catalog key of each list, where "" is every list.
+var unsubscribeLists = map[string]string{
+	"daily":     "daily",
+	"weekly":    "weekly",
+	"reminders": "reminders",
+	"":          "all",
+}
+
+// The locale of whoever the unsubscribe token belongs to, or the default for
+// tokens nobody has.
+func unsubscribeLocale(ctx context.Context, token string) string {
+	settings, err := store.Settings().GetByUnsubscribeToken(ctx, token)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			log.Println("Error looking up unsubscribe token:", err)
+		}
+		return defaultLocale
+	}
+	return settings.Locale
+}
+
+// GET /unsubscribe/{token}?list=daily|weekly|reminders
+// POST /unsubscribe/{token}?list=daily|weekly|reminders
+// Linked from digest and reminder emails, so it works without a session. GET
+// only asks to confirm, and the POST unsubscribes, which is also what mail
+// clients send for one-click unsubscribes (RFC 8058). Without a list, every
+// email the user opted into is turned off.
+func unsubscribe(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	token := vars["token"]
+	list := r.URL.Query().Get("list")
+
+	key, ok := unsubscribeLists[list]
+	if !ok {
+		http.Error(w, "Invalid list", http.StatusBadRequest)
+		return
+	}
+	locale := unsubscribeLocale(r.Context(), token)
+	if r.Method == http.MethodGet {
+		renderConfirmation(w, Confirmation{
+			Locale:  locale,
+			Title:   translate(locale, "page.unsubscribe.title"),
+			Message: translate(locale, "page.unsubscribe.confirm."+key),
+			Button:  translate(locale, "page.unsubscribe.button"),
+		})
+		return
+	}
+
+	err := store.Settings().Unsubscribe(r.Context(), token, list)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "Subscription not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, translate(locale, "page.unsubscribe.done."+key))
+}
@@ -0,0 +1,54 @@
+package main
+
+import "context"
+
+type PostgresDigestStore struct {
+	db Querier
+}
+
+func (s *PostgresDigestStore) Subscribers(ctx context.Context) ([]Settings, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	rows, err := s.db.Query(ctx,
+		`
+		SELECT `+settingsColumns+` FROM user_settings
+		WHERE daily_digest OR weekly_digest
+		`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscribers := []Settings{}
+	for rows.Next() {
+		settings, err := scanSettings(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscribers = append(subscribers, settings)
+	}
+	return subscribers, rows.Err()
+}
+
+func (s *PostgresDigestStore) Claim(ctx context.Context, userId string, kind string, date string) (bool, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	result, err := s.db.Exec(ctx,
+		`
+		INSERT INTO digest_deliveries (user_id, kind, local_date)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+		`,
+		userId,
+		kind,
+		date,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return result.RowsAffected() == 1, nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Splits a message's header block into unfolded-as-received fields, in order.
+func splitHeaderFields(t *testing.T, header string) [][2]string {
+	t.Helper()
+
+	var fields [][2]string
+	for _, line := range strings.SplitAfter(header, "\r\n") {
+		if line == "" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(fields) == 0 {
+				t.Fatalf("continuation line %q before any field", line)
+			}
+			fields[len(fields)-1][1] += line
+			continue
+		}
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			t.Fatalf("malformed header line %q", line)
+		}
+		fields = append(fields, [2]string{name, value})
+	}
+	return fields
+}
+
+// Collapses runs of spaces and tabs into a single space.
+func collapseWSP(s string) string {
+	var out strings.Builder
+	space := false
+	for _, c := range s {
+		if c == ' ' || c == '\t' {
+			space = true
+			continue
+		}
+		if space {
+			out.WriteByte(' ')
+			space = false
+		}
+		out.WriteRune(c)
+	}
+	if space {
+		out.WriteByte(' ')
+	}
+	return out.String()
+}
+
+// RFC 6376 section 3.4.2, written out separately from the signer's version.
+func canonicalHeader(name string, value string) string {
+	value = strings.NewReplacer("\r\n", "").Replace(value)
+	value = strings.Trim(collapseWSP(value), " ")
+	return strings.ToLower(strings.TrimRight(name, " \t")) + ":" + value
+}
+
+// RFC 6376 section 3.4.4.
+func canonicalBody(body string) string {
+	lines := strings.Split(body, "\r\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(collapseWSP(lines[i]), " ")
+	}
+	end := len(lines)
+	for end > 0 && lines[end-1] == "" {
+		end--
+	}
+	if end == 0 {
+		return ""
+	}
+	return strings.Join(lines[:end], "\r\n") + "\r\n"
+}
+
+// Checks a message's DKIM signature the way a receiving server would, with
+// relaxed/relaxed canonicalization and rsa-sha256.
+func verifyDKIM(t *testing.T, message []byte, key *rsa.PublicKey) error {
+	t.Helper()
+
+	header, body, found := bytes.Cut(message, []byte("\r\n\r\n"))
+	if !found {
+		return fmt.Errorf("message has no body")
+	}
+	fields := splitHeaderFields(t, string(header)+"\r\n")
+	if len(fields) == 0 || !strings.EqualFold(fields[0][0], "DKIM-Signature") {
+		return fmt.Errorf("message doesn't start with a DKIM-Signature")
+	}
+	signatureField := fields[0]
+
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(signatureField[1], ";") {
+		name, value, found := strings.Cut(tag, "=")
+		if !found {
+			continue
+		}
+		value = strings.Join(strings.Fields(value), "")
+		tags[strings.TrimSpace(name)] = value
+	}
+	for name, want := range map[string]string{"v": "1", "a": "rsa-sha256", "c": "relaxed/relaxed"} {
+		if tags[name] != want {
+			return fmt.Errorf("got %s=%q, want %q", name, tags[name], want)
+		}
+	}
+
+	bodyHash := sha256.Sum256([]byte(canonicalBody(string(body))))
+	if got := base64.StdEncoding.EncodeToString(bodyHash[:]); got != tags["bh"] {
+		return fmt.Errorf("body hash %s doesn't match bh=%s", got, tags["bh"])
+	}
+
+	// Each signed name takes the last unused instance of that field
+	used := make(map[int]bool)
+	var hashed strings.Builder
+	for _, name := range strings.Split(tags["h"], ":") {
+		for i := len(fields) - 1; i > 0; i-- {
+			if !used[i] && strings.EqualFold(fields[i][0], name) {
+				used[i] = true
+				hashed.WriteString(canonicalHeader(fields[i][0], fields[i][1]) + "\r\n")
+				break
+			}
+		}
+	}
+
+	// The signature field is hashed with an empty b= and no trailing CRLF
+	tagList := strings.Split(signatureField[1], ";")
+	for i, tag := range tagList {
+		if name, _, _ := strings.Cut(tag, "="); strings.TrimSpace(name) == "b" {
+			tagList[i] = tag[:strings.Index(tag, "=")+1]
+		}
+	}
+	hashed.WriteString(canonicalHeader(signatureField[0], strings.Join(tagList, ";")))
+
+	signature, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256([]byte(hashed.String()))
+	return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature)
+}
+
+func TestDKIMSignatureVerifies(t *testing.T) {
+	oldFrom, oldName := fromEmail, fromName
+	t.Cleanup(func() { fromEmail, fromName = oldFrom, oldName })
+	fromEmail = "calendar@example.com"
+	fromName = "Example Calendar"
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &DKIMSigner{Domain: "example.com", Selector: "mail", Key: key}
+
+	message, err := OutgoingMail{
+		To:          []string{"guest@example.com", "other@example.com"},
+		Subject:     "Invitation: Planning   (Mon 9:00)",
+		Text:        "You're invited.  \r\nSee you there\t\r\n\r\n\r\n",
+		HTML:        "<p>You're invited.</p>",
+		Calendar:    "BEGIN:VCALENDAR\r\nMETHOD:REQUEST\r\nEND:VCALENDAR\r\n",
+		Method:      "REQUEST",
+		Thread:      "3f0c8b52-8f1e-4d2c-9a47-5b1c2d3e4f50",
+		Unsubscribe: "https://calendar.example.com/unsubscribe/token",
+	}.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := signer.Sign(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyDKIM(t, signed, &key.PublicKey); err != nil {
+		t.Fatalf("signature doesn't verify: %v", err)
+	}
+
+	t.Run("survives refolding and trailing whitespace", func(t *testing.T) {
+		relayed := bytes.Replace(signed, []byte("\r\nSubject: "), []byte("\r\nSubject:  \r\n\t"), 1)
+		relayed = bytes.Replace(relayed, []byte("\r\n\r\n"), []byte("  \r\n\r\n"), 1)
+		relayed = append(relayed, "\r\n\r\n"...)
+		if err := verifyDKIM(t, relayed, &key.PublicKey); err != nil {
+			t.Fatalf("relaxed canonicalization should tolerate the relay's changes: %v", err)
+		}
+	})
+
+	t.Run("fails when a signed header changes", func(t *testing.T) {
+		tampered := bytes.Replace(signed, []byte("guest@example.com"), []byte("guest@evil.example"), 1)
+		if verifyDKIM(t, tampered, &key.PublicKey) == nil {
+			t.Fatal("signature verified after changing To")
+		}
+	})
+
+	t.Run("fails when the body changes", func(t *testing.T) {
+		tampered := bytes.Replace(signed, []byte("See you there"), []byte("See you later"), 1)
+		if verifyDKIM(t, tampered, &key.PublicKey) == nil {
+			t.Fatal("signature verified after changing the body")
+		}
+	})
+
+	t.Run("fails with another key", func(t *testing.T) {
+		other, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if verifyDKIM(t, signed, &other.PublicKey) == nil {
+			t.Fatal("signature verified with the wrong key")
+		}
+	})
+}
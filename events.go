@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -15,13 +16,25 @@ import (
 )
 
 type Event struct {
-	Id           string  `json:"id" database:"id"`
-	CalendarId   string  `json:"calendarId" database:"calendar_id"`
-	Title        string  `json:"title" database:"title`
-	Description  *string `json:"description" database:"description"`
-	Duration     int     `json:"duration" database:"duration"`
-	Date         string  `json:"date" database:"date`
-	RecurrenceId string  `json:"recurrenceId" database:"recurrence_id"`
+	Id           string  `json:"id"`
+	CalendarId   string  `json:"calendarId"`
+	Title        string  `json:"title"`
+	Description  *string `json:"description"`
+	Location     *string `json:"location"`
+	Duration     int     `json:"duration"`
+	Date         string  `json:"date"`
+	RecurrenceId string  `json:"recurrenceId"`
+	// Set on an occurrence of a series that was edited on its own, to the date
+	// it had in the series
+	OriginalDate string     `json:"-"`
+	Sequence     int        `json:"sequence"`
+	Labels       []string   `json:"labels"`
+	Reminders    []Reminder `json:"reminders"`
+	Attendees    []Attendee `json:"attendees"`
+	// Set when an email is about part of a series: the original date of the
+	// occurrence, and whether the change also applies to every later one.
+	Occurrence    string `json:"-"`
+	ThisAndFuture bool   `json:"-"`
 }
 
 type GenerateEventRequest struct {
@@ -30,49 +43,76 @@ type GenerateEventRequest struct {
 }
 
 type CreateEventRequest struct {
-	CalendarId  string    `json:"calendarId"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	Duration    int       `json:"duration"`
-	Date        time.Time `json:"date"`
-	Recurring   bool      `json:"recurring"`
-	Invitees    []string  `json:"invitees"`
+	CalendarId  string     `json:"calendarId"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Location    string     `json:"location"`
+	Duration    int        `json:"duration"`
+	Date        time.Time  `json:"date"`
+	Recurring   bool       `json:"recurring"`
+	Invitees    []string   `json:"invitees"`
+	Reminders   []Reminder `json:"reminders"`
 }
 
 var dateFormat = "2006-01-02T15:04:05Z07:00"
 
+// Returns the date attendees' calendars know an occurrence of a series by,
+// which stays the same when the occurrence is moved on its own.
+func occurrenceDate(event Event) string {
+	if event.OriginalDate != "" {
+		return event.OriginalDate
+	}
+	return event.Date
+}
+
+// Reports whether the event belongs to a calendar the user is a member of.
+func canAccessEvent(ctx context.Context, userId string, eventId string) bool {
+	access, err := store.Events().CanAccess(ctx, eventId, userId)
+	if err != nil {
+		log.Println("Error checking event access:", err)
+	}
+	return err == nil && access
+}
+
 // GET /events
 func getEvents(w http.ResponseWriter, r *http.Request) {
-	session := getSession(r)
-	if session == nil {
-		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
-		return
-	}
+	session := currentSession(r)
 
 	userId := session.Identity.Id
 
-	var events []Event
-	Query(&events,
-		`
-		SELECT * FROM events
-		WHERE calendar_id IN (SELECT calendar_id FROM calendar_members WHERE user_id = $1)
-		`,
-		userId,
-	)
+	labelId := r.URL.Query().Get("label")
+	if labelId != "" {
+		if _, err := uuid.Parse(labelId); err != nil {
+			http.Error(w, `{"error": "Invalid label"}`, http.StatusBadRequest)
+			return
+		}
+	}
 
-	if len(events) == 0 {
-		events = []Event{}
+	events, err := store.Events().ListForUser(r.Context(), userId, labelId)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	labels, err := store.Labels().EventLabels(r.Context(), userId)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+	for idx, event := range events {
+		events[idx].Labels = labels[event.Id]
+		if events[idx].Labels == nil {
+			events[idx].Labels = []string{}
+		}
 	}
 	json.NewEncoder(w).Encode(events)
 }
 
 // POST /events
 func createEvent(w http.ResponseWriter, r *http.Request) {
-	session := getSession(r)
-	if session == nil {
-		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
-		return
-	}
+	session := currentSession(r)
 
 	var event CreateEventRequest
 	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
@@ -80,114 +120,149 @@ func createEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !isCalendarMember(r.Context(), session.Identity.Id, event.CalendarId) {
+		http.Error(w, `{"error": "Calendar not found"}`, http.StatusNotFound)
+		return
+	}
+
+	// The creator gets a copy of the invitation without being listed as an attendee
+	recipients := append(event.Invitees, session.Identity.Traits.Email)
+
+	// Recurring events are stored as 100 weekly occurrences sharing a recurrence id
+	occurrences := 1
+	recurrenceId := ""
 	if event.Recurring {
-		var newEvent Event
-		recurrence_id := uuid.New().String()
-		for i := 0; i < 100; i++ {
-			event_id := uuid.New().String()
-			_, err := Execute(
-				`
-				INSERT INTO events (id, calendar_id, title, description, duration, date, recurrence_id)
-				VALUES ($1, $2, $3, $4, $5, $6, $7)
-				`,
-				event_id, event.CalendarId, event.Title, event.Description, event.Duration, event.Date.AddDate(0, 0, i*7).Format(time.RFC3339), recurrence_id,
-			)
-			if err != nil {
-				log.Println("Error inserting event into database:", err)
-				http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
-				return
-			}
+		occurrences = 100
+		recurrenceId = uuid.New().String()
+	}
 
-			if i == 0 {
-				newEvent = Event{
-					Id:           event_id,
-					CalendarId:   event.CalendarId,
-					Title:        event.Title,
-					Description:  &event.Description,
-					Duration:     event.Duration,
-					Date:         event.Date.Format(time.RFC3339),
-					RecurrenceId: recurrence_id,
-				}
-				event.Invitees = append(event.Invitees, session.Identity.Traits.Email)
-				SendEvent(
-					event.Invitees,
-					fmt.Sprintf("Generated by Prayuj Calendar on %s", time.Now().In(time.Local).Format("Mon, 02 Jan 2006 3:04 PM")),
-					newEvent,
-					false,
-				)
-			}
+	events := make([]Event, occurrences)
+	for i := range events {
+		events[i] = Event{
+			Id:           uuid.New().String(),
+			CalendarId:   event.CalendarId,
+			Title:        event.Title,
+			Description:  &event.Description,
+			Location:     &event.Location,
+			Duration:     event.Duration,
+			Date:         event.Date.AddDate(0, 0, i*7).Format(time.RFC3339),
+			RecurrenceId: recurrenceId,
 		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(newEvent)
-	} else {
-		event_id := uuid.New().String()
-		_, err := Execute(
-			`
-			INSERT INTO events (id, calendar_id, title, description, duration, date)
-			VALUES ($1, $2, $3, $4, $5, $6)
-			`,
-			event_id, event.CalendarId, event.Title, event.Description, event.Duration, event.Date.Format(time.RFC3339),
-		)
+	}
+	newEvent := events[0]
+
+	err := store.Transaction(r.Context(), func(tx Store) error {
+		if err := tx.Events().Create(r.Context(), session.Identity.Id, events...); err != nil {
+			return err
+		}
+
+		var err error
+		newEvent.Reminders, err = createReminders(r.Context(), tx, session.Identity.Id, newEvent, event.Reminders)
 		if err != nil {
-			log.Println("Error inserting event into database:", err)
-			http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
-			return
+			return err
 		}
 
-		newEvent := Event{
-			Id:          event_id,
-			CalendarId:  event.CalendarId,
-			Title:       event.Title,
-			Description: &event.Description,
-			Duration:    event.Duration,
-			Date:        event.Date.Format(time.RFC3339),
+		newEvent.Attendees, err = addAttendees(r.Context(), tx, newEvent, event.Invitees)
+		if err != nil {
+			return err
 		}
-		event.Invitees = append(event.Invitees, session.Identity.Traits.Email)
-		SendEvent(
-			event.Invitees,
-			fmt.Sprintf("Generated by Prayuj Calendar on %s", time.Now().In(time.Local).Format("Mon, 02 Jan 2006 3:04 PM")),
-			newEvent,
-			false,
-		)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(newEvent)
+
+		return QueueEventMail(r.Context(), tx, "new", newEvent, session.Identity.Traits.DisplayName(), recipients)
+	})
+	if err != nil {
+		log.Println("Error inserting event into database:", err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newEvent)
 }
 
 // GET /events/{id}
 func getEvent(w http.ResponseWriter, r *http.Request) {
-	session := getSession(r)
-	if session == nil {
-		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
-		return
-	}
+	session := currentSession(r)
 	vars := mux.Vars(r)
 
 	userId := session.Identity.Id
 	eventId := vars["id"]
 
-	var event []Event
-	Query(&event, "SELECT * FROM events WHERE id = $1 AND user_id = $2", eventId, userId)
-
-	if len(event) == 0 {
+	event, err := store.Events().GetOwned(r.Context(), eventId, userId)
+	if errors.Is(err, ErrNotFound) {
 		http.Error(w, `{"error": "Event not found"}`, http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	labels, err := store.Labels().EventLabels(r.Context(), userId)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+	event.Labels = labels[event.Id]
+	if event.Labels == nil {
+		event.Labels = []string{}
+	}
+	event.Reminders, err = store.Reminders().ListForEvent(r.Context(), userId, event)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+	event.Attendees, err = store.Attendees().List(r.Context(), eventKey(event))
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(event[0])
+	json.NewEncoder(w).Encode(event)
+}
+
+// Reports whether an update changes anything attendees can see, so they aren't
+// emailed when the event only moves to another calendar.
+func eventChanged(old Event, updated Event) (bool, error) {
+	oldDate, err := time.Parse(dateFormat, old.Date)
+	if err != nil {
+		return false, err
+	}
+	newDate, err := time.Parse(dateFormat, updated.Date)
+	if err != nil {
+		return false, err
+	}
+
+	text := func(value *string) string {
+		if value == nil {
+			return ""
+		}
+		return *value
+	}
+	return !oldDate.Equal(newDate) ||
+		old.Title != updated.Title ||
+		old.Duration != updated.Duration ||
+		text(old.Description) != text(updated.Description) ||
+		text(old.Location) != text(updated.Location), nil
 }
 
 // PUT /events/{id}
+// Attendees are sent the updated invitation unless ?notify=false.
 func updateEvent(w http.ResponseWriter, r *http.Request) {
-	session := getSession(r)
-	if session == nil {
-		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
-		return
-	}
+	session := currentSession(r)
 	vars := mux.Vars(r)
 	eventId := vars["id"]
 	recurring := r.URL.Query().Get("recurring")
+	notify := r.URL.Query().Get("notify") != "false"
+
+	if !canAccessEvent(r.Context(), session.Identity.Id, eventId) {
+		http.Error(w, `{"error": "Event not found"}`, http.StatusNotFound)
+		return
+	}
 
 	var event Event
 	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
@@ -195,110 +270,178 @@ func updateEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if recurring == "true" {
-		var events []Event
-		Query(&events, "SELECT * FROM events WHERE id = $1", eventId)
-		recurrence_id := events[0].RecurrenceId
-		oldDate := events[0].Date
+	old, err := store.Events().Get(r.Context(), eventId)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, `{"error": "Event not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
 
-		if recurrence_id == "" {
-			log.Println("Event is not recurring")
-			http.Error(w, `{"error": "Event is not recurring"}`, http.StatusBadRequest)
-			return
-		}
+	// Events can only be moved to calendars the user is a member of
+	if event.CalendarId == "" {
+		event.CalendarId = old.CalendarId
+	}
+	if event.CalendarId != old.CalendarId && !isCalendarMember(r.Context(), session.Identity.Id, event.CalendarId) {
+		http.Error(w, `{"error": "Calendar not found"}`, http.StatusNotFound)
+		return
+	}
 
-		oldEventDate, err := time.Parse(dateFormat, oldDate)
-		if err != nil {
-			log.Println("Error parsing old event date:", err)
-			http.Error(w, `{"error": "Invalid event date"}`, http.StatusInternalServerError)
-			return
+	if recurring == "true" && old.RecurrenceId == "" {
+		log.Println("Event is not recurring")
+		http.Error(w, `{"error": "Event is not recurring"}`, http.StatusBadRequest)
+		return
+	}
+
+	changed, err := eventChanged(old, event)
+	if err != nil {
+		log.Println("Error parsing event date:", err)
+		http.Error(w, `{"error": "Invalid event date"}`, http.StatusBadRequest)
+		return
+	}
+
+	event.Id = eventId
+	err = store.Transaction(r.Context(), func(tx Store) error {
+		events := tx.Events()
+
+		event.Sequence = old.Sequence
+		if changed {
+			sequence, err := events.NextSequence(r.Context(), eventId)
+			if err != nil {
+				return err
+			}
+			event.Sequence = sequence
 		}
 
-		newEventDate, err := time.Parse(dateFormat, event.Date)
+		var err error
+		if recurring == "true" {
+			err = events.UpdateFollowing(r.Context(), old, event)
+		} else {
+			err = events.Update(r.Context(), event)
+		}
 		if err != nil {
-			log.Println("Error parsing new event date:", err)
-			http.Error(w, `{"error": "Invalid new event date"}`, http.StatusInternalServerError)
-			return
+			return err
 		}
 
-		dateDifference := newEventDate.Sub(oldEventDate)
-		interval := dateDifference.Seconds() / 86400
+		if !changed || !notify {
+			return nil
+		}
 
-		_, err = Execute(
-			`
-			UPDATE events
-			SET title = $1, calendar_id = $2, description = $3, duration = $4, date = date + $7 * INTERVAL '1 day'
-			WHERE recurrence_id = $5 AND date >= $6
-			`, event.Title, event.CalendarId, event.Description, event.Duration, recurrence_id, oldDate, interval,
-		)
+		// Occurrences edited on their own stay in the series, so every attendee of
+		// the series is told, under the series UID, about the occurrence that
+		// changed within it
+		updated := old
+		updated.Title = event.Title
+		updated.Description = event.Description
+		updated.Location = event.Location
+		updated.Duration = event.Duration
+		updated.Date = event.Date
+		updated.Sequence = event.Sequence
+		updated.Reminders, err = tx.Reminders().ListForEvent(r.Context(), session.Identity.Id, old)
 		if err != nil {
-			log.Println("Error updating events:", err)
-			http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
-			return
+			return err
 		}
-	} else {
-		_, err := Execute(
-			`UPDATE events SET title = $1, calendar_id = $2, description = $3, duration = $4, date = $5, recurrence_id = NULL WHERE id = $6`,
-			event.Title, event.CalendarId, event.Description, event.Duration, event.Date, eventId,
-		)
+		updated.Attendees, err = tx.Attendees().List(r.Context(), eventKey(old))
 		if err != nil {
-			log.Println(err)
-			http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
-			return
+			return err
 		}
+		if old.RecurrenceId != "" {
+			updated.Occurrence = occurrenceDate(old)
+			updated.ThisAndFuture = recurring == "true"
+		}
+
+		recipients := attendeeEmails(updated.Attendees, session.Identity.Traits.Email)
+		return QueueEventMail(r.Context(), tx, "updated", updated, session.Identity.Traits.DisplayName(), recipients)
+	})
+	if err != nil {
+		log.Println("Error updating events:", err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
 // DELETE /events/{id}
+// Attendees are sent a cancellation unless ?notify=false.
 func deleteEvent(w http.ResponseWriter, r *http.Request) {
-	session := getSession(r)
-	if session == nil {
-		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
-		return
-	}
+	session := currentSession(r)
 	vars := mux.Vars(r)
 	eventId := vars["id"]
 	recurring := r.URL.Query().Get("recurring")
+	notify := r.URL.Query().Get("notify") != "false"
+
+	if !canAccessEvent(r.Context(), session.Identity.Id, eventId) {
+		http.Error(w, `{"error": "Event not found"}`, http.StatusNotFound)
+		return
+	}
 
-	var event []Event
-	Query(&event, "SELECT * FROM events WHERE id = $1", eventId)
+	event, err := store.Events().Get(r.Context(), eventId)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, `{"error": "Event not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+	event.Attendees, err = store.Attendees().List(r.Context(), eventKey(event))
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
 
-	if event[0].RecurrenceId != "" && recurring == "true" {
-		_, err := Execute("DELETE FROM events WHERE recurrence_id = $1 AND date >= $2", event[0].RecurrenceId, event[0].Date)
+	err = store.Transaction(r.Context(), func(tx Store) error {
+		events := tx.Events()
+
+		sequence, err := events.NextSequence(r.Context(), eventId)
 		if err != nil {
-			log.Println(err)
-			http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
-			return
+			return err
+		}
+
+		if event.RecurrenceId != "" && recurring == "true" {
+			err = events.DeleteFollowing(r.Context(), event.RecurrenceId, occurrenceDate(event))
+		} else {
+			err = events.Delete(r.Context(), eventId)
 		}
-	} else {
-		_, err := Execute("DELETE FROM events WHERE id = $1", eventId)
 		if err != nil {
-			log.Println(err)
-			http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
-			return
+			return err
 		}
-	}
 
-	SendEvent(
-		[]string{session.Identity.Traits.Email},
-		fmt.Sprintf("Generated by Prayuj Calendar on %s", time.Now().In(time.Local).Format("Mon, 02 Jan 2006 3:04 PM")),
-		event[0],
-		true,
-	)
+		if err := tx.Attendees().RemoveOrphaned(r.Context(), eventKey(event)); err != nil {
+			return err
+		}
+
+		if !notify {
+			return nil
+		}
+
+		cancelled := event
+		cancelled.Sequence = sequence
+		if cancelled.RecurrenceId != "" {
+			cancelled.Occurrence = occurrenceDate(cancelled)
+			cancelled.ThisAndFuture = recurring == "true"
+		}
+
+		recipients := attendeeEmails(cancelled.Attendees, session.Identity.Traits.Email)
+		return QueueEventMail(r.Context(), tx, "cancelled", cancelled, session.Identity.Traits.DisplayName(), recipients)
+	})
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
 // POST /events/generate
 func generateEventInformation(w http.ResponseWriter, r *http.Request) {
-	session := getSession(r)
-	if session == nil {
-		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
-		return
-	}
-
 	client := openai.NewClient(os.Getenv("OPENAI_API_KEY"))
 	var request GenerateEventRequest
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
@@ -339,8 +482,11 @@ func generateEventInformation(w http.ResponseWriter, r *http.Request) {
 		},
 	}
 
+	ctx, cancel := context.WithTimeout(r.Context(), llmTimeout)
+	defer cancel()
+
 	response, err := client.CreateChatCompletion(
-		context.Background(),
+		ctx,
 		openai.ChatCompletionRequest{
 			Model: openai.GPT4oMini,
 			Messages: []openai.ChatCompletionMessage{
@@ -381,6 +527,10 @@ func generateEventInformation(w http.ResponseWriter, r *http.Request) {
 		},
 	)
 
+	if errors.Is(err, context.DeadlineExceeded) {
+		http.Error(w, `{"error": "Timed out generating event"}`, http.StatusGatewayTimeout)
+		return
+	}
 	if err != nil {
 		log.Println("Error with OpenAI API:", err)
 		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
@@ -400,17 +550,16 @@ func generateEventInformation(w http.ResponseWriter, r *http.Request) {
 }
 
 // POST /events/{id}/share
+// The people it is shared with aren't invited, so they aren't shown who is.
 func shareEvent(w http.ResponseWriter, r *http.Request) {
-	session := getSession(r)
-	if session == nil {
-		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
-		return
-	}
+	session := currentSession(r)
 	vars := mux.Vars(r)
 	eventId := vars["id"]
 
-	var event []Event
-	Query(&event, "SELECT * FROM events WHERE id = $1", eventId)
+	if !canAccessEvent(r.Context(), session.Identity.Id, eventId) {
+		http.Error(w, `{"error": "Event not found"}`, http.StatusNotFound)
+		return
+	}
 
 	var body struct {
 		Emails []string `json:"emails"`
@@ -421,7 +570,28 @@ func shareEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	SendEvent(body.Emails, "Shared with you by Prayuj Calendar", event[0], false)
+	event, err := store.Events().Get(r.Context(), eventId)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, `{"error": "Event not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	event.Reminders, err = store.Reminders().ListForEvent(r.Context(), session.Identity.Id, event)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+	if err := QueueEventMail(r.Context(), store, "shared", event, session.Identity.Traits.DisplayName(), body.Emails); err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 }
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Claims every queued email, returning the calendar attachment each recipient
+// was sent, unfolded.
+func queuedInvitations(t *testing.T) map[string]string {
+	t.Helper()
+
+	messages, err := store.Outbox().Claim(context.Background(), 1000, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invitations := make(map[string]string)
+	for _, message := range messages {
+		parsed, err := mail.ReadMessage(strings.NewReader(message.Message))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parts := multipart.NewReader(parsed.Body, params["boundary"])
+		for {
+			part, err := parts.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(part.Header.Get("Content-Type"), "text/calendar") {
+				continue
+			}
+			content, err := io.ReadAll(part)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, to := range message.To {
+				invitations[to] = strings.ReplaceAll(string(content), "\r\n ", "")
+			}
+		}
+	}
+	return invitations
+}
+
+func expectInvitation(t *testing.T, invitations map[string]string, to string, lines ...string) {
+	t.Helper()
+
+	invitation, ok := invitations[to]
+	if !ok {
+		t.Fatalf("%s wasn't sent the invitation", to)
+	}
+	for _, line := range lines {
+		if !strings.Contains(invitation, line+"\r\n") {
+			t.Fatalf("invitation to %s is missing %q:\n%s", to, line, invitation)
+		}
+	}
+}
+
+func TestEditedOccurrenceStaysInSeries(t *testing.T) {
+	server := newRouteRecorder(setupTestServer(t))
+
+	var calendar Calendar
+	server.expect(t, testRequest{method: "POST", path: "/calendars", body: Calendar{Name: "Work"}}, http.StatusOK, &calendar)
+
+	var series Event
+	server.expect(t, testRequest{
+		method: "POST",
+		path:   "/events",
+		body: CreateEventRequest{
+			CalendarId: calendar.Id,
+			Title:      "Standup",
+			Duration:   15,
+			Date:       time.Date(2030, 1, 7, 15, 0, 0, 0, time.UTC),
+			Recurring:  true,
+			Invitees:   []string{"guest@example.com"},
+		},
+	}, http.StatusOK, &series)
+	queuedInvitations(t)
+
+	var events []Event
+	server.expect(t, testRequest{method: "GET", path: "/events"}, http.StatusOK, &events)
+	if len(events) != 100 {
+		t.Fatalf("got %d occurrences, want 100", len(events))
+	}
+	occurrence := events[1]
+	uid := "UID:" + series.RecurrenceId + "@prayujt.com"
+
+	for _, date := range []string{"2030-01-15T15:00:00Z", "2030-01-16T09:30:00Z"} {
+		moved := occurrence
+		moved.Date = date
+		server.expect(t, testRequest{method: "PUT", path: "/events/" + occurrence.Id, body: moved}, http.StatusOK, nil)
+
+		// Every move names the occurrence by its date in the series
+		invitations := queuedInvitations(t)
+		for _, to := range []string{"guest@example.com", devUser.Email} {
+			expectInvitation(t, invitations, to,
+				"METHOD:REQUEST",
+				uid,
+				"RECURRENCE-ID:20300114T150000Z",
+				"DTSTART:"+strings.NewReplacer("-", "", ":", "").Replace(date),
+			)
+		}
+
+		var fetched Event
+		server.expect(t, testRequest{method: "GET", path: "/events/" + occurrence.Id}, http.StatusOK, &fetched)
+		if fetched.RecurrenceId != series.RecurrenceId || len(fetched.Attendees) != 1 {
+			t.Fatalf("got %+v, want the occurrence still in the series with its attendee", fetched)
+		}
+	}
+
+	server.expect(t, testRequest{method: "DELETE", path: "/events/" + occurrence.Id}, http.StatusOK, nil)
+	invitations := queuedInvitations(t)
+	for _, to := range []string{"guest@example.com", devUser.Email} {
+		expectInvitation(t, invitations, to, "METHOD:CANCEL", uid, "RECURRENCE-ID:20300114T150000Z")
+	}
+
+	// Deleting this and every later occurrence cancels the rest of the series
+	server.expect(t, testRequest{method: "DELETE", path: "/events/" + events[2].Id + "?recurring=true"}, http.StatusOK, nil)
+	expectInvitation(t, queuedInvitations(t), "guest@example.com",
+		"METHOD:CANCEL", uid, "RECURRENCE-ID;RANGE=THISANDFUTURE:20300121T150000Z")
+	server.expect(t, testRequest{method: "GET", path: "/events"}, http.StatusOK, &events)
+	if len(events) != 1 {
+		t.Fatalf("got %d occurrences, want only the first", len(events))
+	}
+}
+
+func TestEventChangesRequireAccess(t *testing.T) {
+	server := newRouteRecorder(setupTestServer(t))
+
+	var calendar Calendar
+	server.expect(t, testRequest{method: "POST", path: "/calendars", body: Calendar{Name: "Private"}}, http.StatusOK, &calendar)
+
+	var event Event
+	server.expect(t, testRequest{
+		method: "POST",
+		path:   "/events",
+		body:   CreateEventRequest{CalendarId: calendar.Id, Title: "Interview", Duration: 60, Date: time.Now().Add(time.Hour)},
+	}, http.StatusOK, &event)
+
+	changed := event
+	changed.Title = "Hijacked"
+	server.expect(t, testRequest{method: "PUT", path: "/events/" + event.Id, user: testUser.Id, body: changed}, http.StatusNotFound, nil)
+	server.expect(t, testRequest{method: "DELETE", path: "/events/" + event.Id, user: testUser.Id}, http.StatusNotFound, nil)
+
+	stored, err := store.Events().Get(context.Background(), event.Id)
+	if err != nil || stored.Title != "Interview" {
+		t.Fatalf("got %+v, %v; want the event untouched", stored, err)
+	}
+}
+
+func TestEventsStayInTheUsersCalendars(t *testing.T) {
+	server := newRouteRecorder(setupTestServer(t))
+
+	var own, other Calendar
+	server.expect(t, testRequest{method: "POST", path: "/calendars", body: Calendar{Name: "Work"}}, http.StatusOK, &own)
+	server.expect(t, testRequest{method: "POST", path: "/calendars", user: testUser.Id, body: Calendar{Name: "Private"}}, http.StatusOK, &other)
+
+	request := CreateEventRequest{CalendarId: other.Id, Title: "Planted", Duration: 30, Date: time.Now().Add(time.Hour)}
+	server.expect(t, testRequest{method: "POST", path: "/events", body: request}, http.StatusNotFound, nil)
+
+	var event Event
+	request.CalendarId, request.Recurring = own.Id, true
+	server.expect(t, testRequest{method: "POST", path: "/events", body: request}, http.StatusOK, &event)
+
+	moved := event
+	moved.CalendarId = other.Id
+	server.expect(t, testRequest{method: "PUT", path: "/events/" + event.Id, body: moved}, http.StatusNotFound, nil)
+	server.expect(t, testRequest{method: "PUT", path: "/events/" + event.Id + "?recurring=true", body: moved}, http.StatusNotFound, nil)
+
+	// Leaving the calendar out keeps the event where it is
+	moved.CalendarId = ""
+	moved.Title = "Renamed"
+	server.expect(t, testRequest{method: "PUT", path: "/events/" + event.Id, body: moved}, http.StatusOK, nil)
+
+	stored, err := store.Events().Get(context.Background(), event.Id)
+	if err != nil || stored.CalendarId != own.Id {
+		t.Fatalf("got %+v, %v; want the event still in %s", stored, err, own.Id)
+	}
+}
+
+func TestMovingSeriesMovesEditedOccurrences(t *testing.T) {
+	server := newRouteRecorder(setupTestServer(t))
+
+	var calendar Calendar
+	server.expect(t, testRequest{method: "POST", path: "/calendars", body: Calendar{Name: "Work"}}, http.StatusOK, &calendar)
+
+	var series Event
+	server.expect(t, testRequest{
+		method: "POST",
+		path:   "/events",
+		body: CreateEventRequest{
+			CalendarId: calendar.Id,
+			Title:      "Standup",
+			Duration:   15,
+			Date:       time.Date(2030, 1, 7, 15, 0, 0, 0, time.UTC),
+			Recurring:  true,
+		},
+	}, http.StatusOK, &series)
+
+	var events []Event
+	server.expect(t, testRequest{method: "GET", path: "/events"}, http.StatusOK, &events)
+	occurrence := events[1]
+
+	edited := occurrence
+	edited.Date = "2030-01-16T15:00:00Z"
+	server.expect(t, testRequest{method: "PUT", path: "/events/" + occurrence.Id, body: edited}, http.StatusOK, nil)
+
+	// Moving the whole series a day later moves the occurrence's place in it too
+	shifted := series
+	shifted.Date = "2030-01-08T15:00:00Z"
+	server.expect(t, testRequest{method: "PUT", path: "/events/" + series.Id + "?recurring=true", body: shifted}, http.StatusOK, nil)
+	queuedInvitations(t)
+
+	edited.Date = "2030-01-18T15:00:00Z"
+	server.expect(t, testRequest{method: "PUT", path: "/events/" + occurrence.Id, body: edited}, http.StatusOK, nil)
+	expectInvitation(t, queuedInvitations(t), devUser.Email, "RECURRENCE-ID:20300115T150000Z")
+
+	// Deleting from the second occurrence's new place in the series removes it
+	server.expect(t, testRequest{method: "DELETE", path: "/events/" + occurrence.Id + "?recurring=true"}, http.StatusOK, nil)
+	server.expect(t, testRequest{method: "GET", path: "/events"}, http.StatusOK, &events)
+	if len(events) != 1 || events[0].Id != series.Id {
+		t.Fatalf("got %d events, want only the first occurrence", len(events))
+	}
+}
+
+func TestSharedEventsHideAttendees(t *testing.T) {
+	server := newRouteRecorder(setupTestServer(t))
+
+	var calendar Calendar
+	server.expect(t, testRequest{method: "POST", path: "/calendars", body: Calendar{Name: "Work"}}, http.StatusOK, &calendar)
+
+	var event Event
+	server.expect(t, testRequest{
+		method: "POST",
+		path:   "/events",
+		body: CreateEventRequest{
+			CalendarId: calendar.Id,
+			Title:      "Offsite",
+			Duration:   60,
+			Date:       time.Now().Add(time.Hour),
+			Invitees:   []string{"guest@example.com"},
+		},
+	}, http.StatusOK, &event)
+	queuedInvitations(t)
+
+	share := map[string][]string{"emails": {"friend@example.com"}}
+	server.expect(t, testRequest{method: "POST", path: "/events/" + event.Id + "/share", user: testUser.Id, body: share}, http.StatusNotFound, nil)
+	server.expect(t, testRequest{method: "POST", path: "/events/" + event.Id + "/share", body: share}, http.StatusOK, nil)
+
+	messages, err := store.Outbox().Claim(context.Background(), 10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].To[0] != "friend@example.com" {
+		t.Fatalf("got %+v, want one email to the friend", messages)
+	}
+	if strings.Contains(messages[0].Message, "guest@example.com") {
+		t.Fatalf("shared email shows the attendees:\n%s", messages[0].Message)
+	}
+}
+
+func TestInvitationsListAttendees(t *testing.T) {
+	event := Event{
+		Title:     "Offsite",
+		Date:      "2030-01-07T15:00:00Z",
+		Duration:  60,
+		Attendees: []Attendee{{Email: "guest@example.com", Status: "accepted"}},
+	}
+	mail, err := newEventMail(event, "Organizer", "other@example.com", defaultSettings(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, kind := range eventMailKinds {
+		_, text, html, err := RenderEventMail(kind, mail)
+		if err != nil {
+			t.Fatal(err)
+		}
+		listed := strings.Contains(text, "guest@example.com") || strings.Contains(html, "guest@example.com")
+		if listed != (kind != "shared") {
+			t.Errorf("%s email lists attendees: %v", kind, listed)
+		}
+	}
+}
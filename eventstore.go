@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+type PostgresEventStore struct {
+	db Querier
+}
+
+var eventColumns = "id, calendar_id, title, description, location, duration, date, recurrence_id, original_date, sequence"
+
+func scanEvent(row scanner) (Event, error) {
+	var event Event
+	var description, location, recurrenceId sql.NullString
+	var date time.Time
+	var originalDate sql.NullTime
+	err := row.Scan(
+		&event.Id,
+		&event.CalendarId,
+		&event.Title,
+		&description,
+		&location,
+		&event.Duration,
+		&date,
+		&recurrenceId,
+		&originalDate,
+		&event.Sequence,
+	)
+	if err != nil {
+		return event, err
+	}
+
+	event.Description = nullString(description)
+	event.Location = nullString(location)
+	event.Date = date.UTC().Format(dateFormat)
+	event.RecurrenceId = recurrenceId.String
+	if originalDate.Valid {
+		event.OriginalDate = originalDate.Time.UTC().Format(dateFormat)
+	}
+	return event, nil
+}
+
+func (s *PostgresEventStore) queryEvents(ctx context.Context, query string, args ...any) ([]Event, error) {
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (s *PostgresEventStore) ListForUser(ctx context.Context, userId string, labelId string) ([]Event, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	query := `
+		SELECT ` + eventColumns + ` FROM events
+		WHERE calendar_id IN (SELECT calendar_id FROM calendar_members WHERE user_id = $1)
+		`
+	args := []any{userId}
+
+	if labelId != "" {
+		query += `AND id IN (SELECT event_id FROM event_labels WHERE label_id = $2)
+		`
+		args = append(args, labelId)
+	}
+	query += `ORDER BY date ASC`
+
+	return s.queryEvents(ctx, query, args...)
+}
+
+func (s *PostgresEventStore) ListBetween(ctx context.Context, userId string, start time.Time, end time.Time) ([]Event, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	return s.queryEvents(ctx,
+		`
+		SELECT `+eventColumns+` FROM events
+		WHERE calendar_id IN (SELECT calendar_id FROM calendar_members WHERE user_id = $1)
+		AND date >= $2 AND date < $3
+		ORDER BY date ASC
+		`,
+		userId,
+		start,
+		end,
+	)
+}
+
+func (s *PostgresEventStore) Get(ctx context.Context, id string) (Event, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return Event{}, ErrNotFound
+	}
+
+	event, err := scanEvent(s.db.QueryRow(ctx, "SELECT "+eventColumns+" FROM events WHERE id = $1", id))
+	if err == pgx.ErrNoRows {
+		return event, ErrNotFound
+	}
+	return event, err
+}
+
+func (s *PostgresEventStore) GetOwned(ctx context.Context, id string, userId string) (Event, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return Event{}, ErrNotFound
+	}
+
+	event, err := scanEvent(s.db.QueryRow(ctx,
+		"SELECT "+eventColumns+" FROM events WHERE id = $1 AND user_id = $2",
+		id,
+		userId,
+	))
+	if err == pgx.ErrNoRows {
+		return event, ErrNotFound
+	}
+	return event, err
+}
+
+func (s *PostgresEventStore) CanAccess(ctx context.Context, eventId string, userId string) (bool, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	if _, err := uuid.Parse(eventId); err != nil {
+		return false, nil
+	}
+
+	var exists bool
+	err := s.db.QueryRow(ctx,
+		`
+		SELECT EXISTS (
+			SELECT 1 FROM events
+			WHERE id = $1
+			AND calendar_id IN (SELECT calendar_id FROM calendar_members WHERE user_id = $2)
+		)
+		`,
+		eventId,
+		userId,
+	).Scan(&exists)
+	return exists, err
+}
+
+func (s *PostgresEventStore) Create(ctx context.Context, userId string, events ...Event) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	rows := make([][]any, len(events))
+	for idx, event := range events {
+		var recurrenceId any
+		if event.RecurrenceId != "" {
+			recurrenceId = event.RecurrenceId
+		}
+		rows[idx] = []any{
+			event.Id,
+			event.CalendarId,
+			userId,
+			event.Title,
+			event.Description,
+			event.Location,
+			event.Duration,
+			event.Date,
+			recurrenceId,
+			event.Sequence,
+		}
+	}
+
+	columns := []string{"id", "calendar_id", "user_id", "title", "description", "location", "duration", "date", "recurrence_id", "sequence"}
+	return withTransaction(ctx, s.db, func(q Querier) error {
+		return insertRows(ctx, q, "events", columns, rows, "")
+	})
+}
+
+// Every occurrence of a series shares one UID, so a new revision has to be
+// numbered past the highest sequence in the whole series.
+func (s *PostgresEventStore) NextSequence(ctx context.Context, eventId string) (int, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	var sequence sql.NullInt64
+	err := s.db.QueryRow(ctx,
+		`
+		SELECT MAX(sequence) + 1 FROM events
+		WHERE id = $1 OR recurrence_id = (SELECT recurrence_id FROM events WHERE id = $1)
+		`,
+		eventId,
+	).Scan(&sequence)
+	if err != nil {
+		return 0, err
+	}
+	if !sequence.Valid {
+		return 0, ErrNotFound
+	}
+	return int(sequence.Int64), nil
+}
+
+func (s *PostgresEventStore) Update(ctx context.Context, event Event) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	if _, err := uuid.Parse(event.Id); err != nil {
+		return ErrNotFound
+	}
+
+	return expectRows(s.db.Exec(ctx,
+		`
+		UPDATE events
+		SET title = $1, calendar_id = $2, description = $3, duration = $4, date = $5, location = $6, sequence = $7,
+			original_date = CASE WHEN recurrence_id IS NULL THEN NULL ELSE COALESCE(original_date, date) END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $8
+		`,
+		event.Title,
+		event.CalendarId,
+		event.Description,
+		event.Duration,
+		event.Date,
+		event.Location,
+		event.Sequence,
+		event.Id,
+	))
+}
+
+func (s *PostgresEventStore) UpdateFollowing(ctx context.Context, old Event, event Event) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	oldDate, err := time.Parse(dateFormat, old.Date)
+	if err != nil {
+		return err
+	}
+	newDate, err := time.Parse(dateFormat, event.Date)
+	if err != nil {
+		return err
+	}
+	interval := newDate.Sub(oldDate).Seconds() / 86400
+
+	return expectRows(s.db.Exec(ctx,
+		`
+		UPDATE events
+		SET title = $1, calendar_id = $2, description = $3, duration = $4, date = date + $5 * INTERVAL '1 day',
+			original_date = original_date + $5 * INTERVAL '1 day',
+			location = $6, sequence = $7, updated_at = CURRENT_TIMESTAMP
+		WHERE recurrence_id = $8 AND COALESCE(original_date, date) >= $9
+		`,
+		event.Title,
+		event.CalendarId,
+		event.Description,
+		event.Duration,
+		interval,
+		event.Location,
+		event.Sequence,
+		old.RecurrenceId,
+		occurrenceDate(old),
+	))
+}
+
+func (s *PostgresEventStore) Delete(ctx context.Context, id string) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrNotFound
+	}
+
+	return expectRows(s.db.Exec(ctx, "DELETE FROM events WHERE id = $1", id))
+}
+
+func (s *PostgresEventStore) DeleteFollowing(ctx context.Context, recurrenceId string, from string) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	return expectRows(s.db.Exec(ctx,
+		"DELETE FROM events WHERE recurrence_id = $1 AND COALESCE(original_date, date) >= $2",
+		recurrenceId,
+		from,
+	))
+}
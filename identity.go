@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// An IdentityProvider authenticates requests and answers questions about users.
+// The rest of the app only talks to identityProvider, so the calendar can run
+// behind Kratos, any OIDC issuer, or a fixed list of users.
+type IdentityProvider interface {
+	// Returns the session the request authenticates with, or nil if it doesn't.
+	// Errors mean the provider couldn't decide, not that the request is anonymous.
+	Session(r *http.Request) (*Session, error)
+	// Returns every user. Errors if only some of them could be listed, since
+	// syncing deletes the users that are missing.
+	Users(ctx context.Context) ([]User, error)
+	// Users whose email, username or name starts with query.
+	SearchUsers(ctx context.Context, query string) ([]User, error)
+	// Returns nil if there is no such user.
+	User(ctx context.Context, id string) (*User, error)
+	UserByEmail(ctx context.Context, email string) (*User, error)
+}
+
+type IdentityConfig struct {
+	// One of "kratos", "oidc" or "static"
+	Provider string
+
+	KratosPublicUrl string
+	KratosAdminUrl  string
+
+	OIDCIssuer   string
+	OIDCAudience string
+	OIDCJWKSPath string
+
+	StaticUsersPath string
+}
+
+var identityProvider IdentityProvider
+
+func InitIdentityProvider(config IdentityConfig) error {
+	switch config.Provider {
+	case "kratos":
+		identityProvider = &KratosProvider{PublicUrl: config.KratosPublicUrl, AdminUrl: config.KratosAdminUrl}
+	case "oidc":
+		provider, err := NewOIDCProvider(config.OIDCIssuer, config.OIDCAudience, config.OIDCJWKSPath)
+		if err != nil {
+			return err
+		}
+		identityProvider = provider
+	case "static":
+		provider, err := NewStaticProvider(config.StaticUsersPath)
+		if err != nil {
+			return err
+		}
+		identityProvider = provider
+	default:
+		return fmt.Errorf("unknown identity provider %q", config.Provider)
+	}
+	return nil
+}
+
+func (user User) Identity() Identity {
+	return Identity{
+		Id:    user.Id,
+		State: "active",
+		Traits: Traits{
+			Email:     user.Email,
+			FirstName: user.FirstName,
+			LastName:  user.LastName,
+			Username:  user.Username,
+			Avatar:    user.Avatar,
+		},
+	}
+}
+
+func (identity Identity) User() User {
+	return User{
+		Id:        identity.Id,
+		Email:     identity.Traits.Email,
+		FirstName: identity.Traits.FirstName,
+		LastName:  identity.Traits.LastName,
+		Username:  identity.Traits.Username,
+		Avatar:    identity.Traits.Avatar,
+	}
+}
+
+// Case-insensitive prefix match on email, username, first, last and full name,
+// for providers that can't search themselves.
+func searchUsers(users []User, query string) []User {
+	query = strings.ToLower(strings.TrimSpace(query))
+	matches := []User{}
+	for _, user := range users {
+		fields := []string{
+			user.Email,
+			user.Username,
+			user.FirstName,
+			user.LastName,
+			strings.TrimSpace(user.FirstName + " " + user.LastName),
+		}
+		for _, field := range fields {
+			if field != "" && strings.HasPrefix(strings.ToLower(field), query) {
+				matches = append(matches, user)
+				break
+			}
+		}
+	}
+	return matches
+}
+
+func findUserByEmail(users []User, email string) *User {
+	for _, user := range users {
+		if strings.EqualFold(user.Email, email) {
+			return &user
+		}
+	}
+	return nil
+}
+
+// Returns the user, or nil if they don't exist or the provider couldn't be reached.
+func GetUser(ctx context.Context, id string) *User {
+	user, err := identityProvider.User(ctx, id)
+	if err != nil {
+		log.Printf("Error looking up user %s: %v", id, err)
+		return nil
+	}
+	return user
+}
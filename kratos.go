@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type User struct {
@@ -16,77 +21,195 @@ type User struct {
 	Avatar    string `json:"avatar"`
 }
 
-func GetUsers() []User {
-	if environment == "development" {
-		return []User{
-			{
-				Id:        "b849d4e4-de61-4c27-b6c6-7f2566f7079f",
-				Email:     "prayuj@prayujt.com",
-				FirstName: "Prayuj",
-				LastName:  "Tuli",
-				Username:  "prayujt",
-				Avatar:    "https://static.prayujt.com/images/PRAYUJ.jpg",
-			},
-			{
-				Id:        "075a7914-1b03-4e02-b883-894857198a25",
-				Email:     "test@email.com",
-				FirstName: "Test",
-				LastName:  "User",
-				Username:  "testuser",
-				Avatar:    "",
-			},
-		}
+// Resolves ory_kratos_session cookies with whoami on the public API, and looks
+// users up through the admin API.
+type KratosProvider struct {
+	PublicUrl string
+	AdminUrl  string
+}
+
+var kratosClient = &http.Client{Timeout: 10 * time.Second}
+
+func (k *KratosProvider) Session(r *http.Request) (*Session, error) {
+	sessionCookie, err := r.Cookie("ory_kratos_session")
+	if err != nil {
+		return nil, nil
 	}
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/admin/identities", kratosAdminUrl), nil)
+	return sessionCache.Get(r.Context(), sessionCookie.Value, k.fetchSession)
+}
+
+// Asks Kratos who the session token belongs to. Returns a nil session if Kratos
+// rejected the token, and an error if it couldn't be reached.
+func (k *KratosProvider) fetchSession(ctx context.Context, token string) (*Session, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/sessions/whoami", k.PublicUrl), nil)
 	if err != nil {
-		return nil
+		return nil, err
 	}
+	req.AddCookie(&http.Cookie{Name: "ory_kratos_session", Value: token})
 
-	resp, err := client.Do(req)
-	if err != nil || resp.StatusCode != http.StatusOK {
-		log.Printf("Error: %v", err)
-		log.Printf("Status code: %d", resp.StatusCode)
-		return nil
+	resp, err := kratosClient.Do(req)
+	if err != nil {
+		return nil, err
 	}
 	defer resp.Body.Close()
 
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("whoami returned status %d", resp.StatusCode)
+	}
+
+	var session Session
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Makes a GET request to the admin API, giving up when ctx is done or the
+// identity timeout passes.
+func (k *KratosProvider) adminGet(ctx context.Context, path string) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, identityTimeout)
+	req, err := http.NewRequestWithContext(ctx, "GET", k.AdminUrl+path, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp, err := kratosClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = cancelOnClose{resp.Body, cancel}
+	return resp, nil
+}
+
+// The most identities the admin API returns in one page.
+var kratosPageSize = 1000
+
+// Follows the next links of the admin API until every identity is listed. Fails
+// if any page does, rather than returning some of the users.
+func (k *KratosProvider) Users(ctx context.Context) ([]User, error) {
+	users := []User{}
+	pageToken := ""
+	for {
+		query := url.Values{"page_size": {strconv.Itoa(kratosPageSize)}}
+		if pageToken != "" {
+			query.Set("page_token", pageToken)
+		}
+		identities, next, err := k.identitiesPage(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		for _, identity := range identities {
+			users = append(users, identity.User())
+		}
+		if next == "" {
+			return users, nil
+		}
+		if next == pageToken {
+			return nil, fmt.Errorf("listing identities returned the same page token twice")
+		}
+		pageToken = next
+	}
+}
+
+// Fetches one page of identities, along with the token of the next page, which
+// is empty on the last one.
+func (k *KratosProvider) identitiesPage(ctx context.Context, query url.Values) ([]Identity, string, error) {
+	resp, err := k.adminGet(ctx, "/admin/identities?"+query.Encode())
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("listing identities returned status %d", resp.StatusCode)
+	}
+
 	var identities []Identity
 	if err := json.NewDecoder(resp.Body).Decode(&identities); err != nil {
-		return nil
+		return nil, "", err
+	}
+	return identities, nextPageToken(resp.Header.Get("Link")), nil
+}
+
+// Finds the page_token of the rel="next" link in a Link header.
+func nextPageToken(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		target, params, ok := strings.Cut(strings.TrimSpace(link), ";")
+		if !ok || !strings.Contains(params, `rel="next"`) {
+			continue
+		}
+		next, err := url.Parse(strings.Trim(strings.TrimSpace(target), "<>"))
+		if err != nil {
+			return ""
+		}
+		return next.Query().Get("page_token")
 	}
+	return ""
+}
 
-	var users []User
-	for _, identity := range identities {
-		users = append(users, User{
-			Id:        identity.Id,
-			Email:     identity.Traits.Email,
-			FirstName: identity.Traits.FirstName,
-			LastName:  identity.Traits.LastName,
-			Username:  identity.Traits.Username,
-			Avatar:    identity.Traits.Avatar,
-		})
+// The admin API can only match identifiers exactly, so prefix search filters
+// the full list.
+func (k *KratosProvider) SearchUsers(ctx context.Context, query string) ([]User, error) {
+	users, err := k.Users(ctx)
+	if err != nil {
+		return nil, err
 	}
-	return users
+	return searchUsers(users, query), nil
 }
 
-func getUsers(w http.ResponseWriter, r *http.Request) {
-	session := getSession(r)
-	if session == nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+func (k *KratosProvider) User(ctx context.Context, id string) (*User, error) {
+	resp, err := k.adminGet(ctx, "/admin/identities/"+url.PathEscape(id))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("getting identity returned status %d", resp.StatusCode)
+	}
+
+	var identity Identity
+	if err := json.NewDecoder(resp.Body).Decode(&identity); err != nil {
+		return nil, err
 	}
+	user := identity.User()
+	return &user, nil
+}
 
-	if !session.Active {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
+func (k *KratosProvider) UserByEmail(ctx context.Context, email string) (*User, error) {
+	resp, err := k.adminGet(ctx, "/admin/identities?credentials_identifier="+url.QueryEscape(email))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("listing identities returned status %d", resp.StatusCode)
 	}
 
-	users := GetUsers()
-	if users == nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	var identities []Identity
+	if err := json.NewDecoder(resp.Body).Decode(&identities); err != nil {
+		return nil, err
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(users)
+	if len(identities) == 0 {
+		return nil, nil
+	}
+	user := identities[0].User()
+	return &user, nil
+}
+
+// Releases a request's context once its body has been read.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Serves the identities from the admin API a page at a time, failing the page
+// with the token fail.
+func newKratosAdmin(t *testing.T, pages [][]Identity, fail string) *KratosProvider {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pageToken := r.URL.Query().Get("page_token")
+		if fail != "" && pageToken == fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		page := 0
+		if pageToken != "" {
+			fmt.Sscanf(pageToken, "page-%d", &page)
+		}
+		if page+1 < len(pages) {
+			w.Header().Set("Link", fmt.Sprintf(`</admin/identities?page_size=1&page_token=page-0>; rel="first",</admin/identities?page_size=1&page_token=page-%d>; rel="next"`, page+1))
+		}
+		json.NewEncoder(w).Encode(pages[page])
+	}))
+	t.Cleanup(server.Close)
+
+	return &KratosProvider{AdminUrl: server.URL}
+}
+
+func TestKratosUsersFollowsPages(t *testing.T) {
+	provider := newKratosAdmin(t, [][]Identity{
+		{{Id: devUser.Id, Traits: Traits{Email: devUser.Email}}},
+		{{Id: testUser.Id, Traits: Traits{Email: testUser.Email}}},
+	}, "")
+
+	users, err := provider.Users(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0].Id != devUser.Id || users[1].Id != testUser.Id {
+		t.Fatalf("got %+v, want both pages of users", users)
+	}
+}
+
+func TestSyncUsersKeepsUsersWhenListingFails(t *testing.T) {
+	setupTestServer(t)
+	if err := store.Users().Save(context.Background(), devUser, testUser); err != nil {
+		t.Fatal(err)
+	}
+
+	identityProvider = newKratosAdmin(t, [][]Identity{
+		{{Id: devUser.Id, Traits: Traits{Email: devUser.Email}}},
+		{{Id: testUser.Id, Traits: Traits{Email: testUser.Email}}},
+	}, "page-1")
+
+	if err := SyncUsers(context.Background()); err == nil {
+		t.Fatal("got no error, want the failed page reported")
+	}
+	user, err := store.Users().GetByEmail(context.Background(), testUser.Email)
+	if err != nil {
+		t.Fatalf("got %v, want the user on the failed page kept", err)
+	}
+	if user.Id != testUser.Id {
+		t.Fatalf("got %+v, want %s", user, testUser.Id)
+	}
+}
+
+func TestSyncUsersKeepsUsersWhenListingIsEmpty(t *testing.T) {
+	setupTestServer(t)
+	if err := store.Users().Save(context.Background(), devUser, testUser); err != nil {
+		t.Fatal(err)
+	}
+
+	identityProvider = newKratosAdmin(t, [][]Identity{{}}, "")
+
+	if err := SyncUsers(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	users, err := store.Users().List(context.Background())
+	if err != nil || len(users) != 2 {
+		t.Fatalf("got %+v, %v; want both users kept", users, err)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type Label struct {
+	Id     string `json:"id"`
+	UserId string `json:"userId"`
+	Name   string `json:"name"`
+	Color  string `json:"color"`
+}
+
+var defaultLabelColor = "#93c4fd"
+
+var labelColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+func ownsLabel(ctx context.Context, userId string, labelId string) bool {
+	owner, err := store.Labels().IsOwner(ctx, labelId, userId)
+	if err != nil {
+		log.Println("Error checking label owner:", err)
+	}
+	return err == nil && owner
+}
+
+// GET /labels
+func getLabels(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+
+	labels, err := store.Labels().ListForUser(r.Context(), session.Identity.Id)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(labels)
+}
+
+// POST /labels
+func createLabel(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+
+	var label Label
+	if err := json.NewDecoder(r.Body).Decode(&label); err != nil {
+		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+	if label.Name == "" {
+		http.Error(w, `{"error": "Label name is required"}`, http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(label.Name) > 255 {
+		http.Error(w, `{"error": "Label name is too long"}`, http.StatusBadRequest)
+		return
+	}
+	if label.Color == "" {
+		label.Color = defaultLabelColor
+	}
+	if !labelColorPattern.MatchString(label.Color) {
+		http.Error(w, `{"error": "Label color must look like #RRGGBB"}`, http.StatusBadRequest)
+		return
+	}
+
+	label.Id = uuid.New().String()
+	label.UserId = session.Identity.Id
+
+	err := store.Labels().Create(r.Context(), label)
+	if errors.Is(err, ErrConflict) {
+		http.Error(w, `{"error": "A label with that name already exists"}`, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Error creating label"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(label)
+}
+
+// PUT /labels/{id}
+func updateLabel(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+
+	vars := mux.Vars(r)
+	labelId := vars["id"]
+
+	if !ownsLabel(r.Context(), session.Identity.Id, labelId) {
+		http.Error(w, `{"error": "Label not found"}`, http.StatusNotFound)
+		return
+	}
+
+	var label Label
+	if err := json.NewDecoder(r.Body).Decode(&label); err != nil {
+		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+	if label.Name == "" {
+		http.Error(w, `{"error": "Label name is required"}`, http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(label.Name) > 255 {
+		http.Error(w, `{"error": "Label name is too long"}`, http.StatusBadRequest)
+		return
+	}
+	if label.Color == "" {
+		label.Color = defaultLabelColor
+	}
+	if !labelColorPattern.MatchString(label.Color) {
+		http.Error(w, `{"error": "Label color must look like #RRGGBB"}`, http.StatusBadRequest)
+		return
+	}
+
+	label.Id = labelId
+	label.UserId = session.Identity.Id
+
+	err := store.Labels().Update(r.Context(), label)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, `{"error": "Label not found"}`, http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, ErrConflict) {
+		http.Error(w, `{"error": "A label with that name already exists"}`, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Error updating label"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(label)
+}
+
+// DELETE /labels/{id}
+func deleteLabel(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+
+	vars := mux.Vars(r)
+	labelId := vars["id"]
+
+	if !ownsLabel(r.Context(), session.Identity.Id, labelId) {
+		http.Error(w, `{"error": "Label not found"}`, http.StatusNotFound)
+		return
+	}
+
+	err := store.Labels().Delete(r.Context(), labelId, session.Identity.Id)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, `{"error": "Label not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Error deleting label"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// POST /tasks/{id}/labels
+func addTaskLabel(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+
+	vars := mux.Vars(r)
+	taskId := vars["id"]
+
+	var body struct {
+		LabelId string `json:"labelId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+
+	if !ownsLabel(r.Context(), session.Identity.Id, body.LabelId) {
+		http.Error(w, `{"error": "Label not found"}`, http.StatusNotFound)
+		return
+	}
+
+	if !ownsTask(r.Context(), session.Identity.Id, taskId) {
+		http.Error(w, `{"error": "Task not found"}`, http.StatusNotFound)
+		return
+	}
+
+	if err := store.Labels().AddToTask(r.Context(), taskId, body.LabelId); err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Error adding label to task"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// DELETE /tasks/{id}/labels/{labelId}
+func removeTaskLabel(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+
+	vars := mux.Vars(r)
+	taskId := vars["id"]
+	labelId := vars["labelId"]
+
+	if !ownsLabel(r.Context(), session.Identity.Id, labelId) {
+		http.Error(w, `{"error": "Label not found"}`, http.StatusNotFound)
+		return
+	}
+
+	if err := store.Labels().RemoveFromTask(r.Context(), taskId, labelId); err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Error removing label from task"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// POST /events/{id}/labels
+func addEventLabel(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+
+	vars := mux.Vars(r)
+	eventId := vars["id"]
+
+	var body struct {
+		LabelId string `json:"labelId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+
+	if !ownsLabel(r.Context(), session.Identity.Id, body.LabelId) {
+		http.Error(w, `{"error": "Label not found"}`, http.StatusNotFound)
+		return
+	}
+
+	if !canAccessEvent(r.Context(), session.Identity.Id, eventId) {
+		http.Error(w, `{"error": "Event not found"}`, http.StatusNotFound)
+		return
+	}
+
+	if err := store.Labels().AddToEvent(r.Context(), eventId, body.LabelId); err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Error adding label to event"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// DELETE /events/{id}/labels/{labelId}
+func removeEventLabel(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+
+	vars := mux.Vars(r)
+	eventId := vars["id"]
+	labelId := vars["labelId"]
+
+	if !ownsLabel(r.Context(), session.Identity.Id, labelId) {
+		http.Error(w, `{"error": "Label not found"}`, http.StatusNotFound)
+		return
+	}
+	if _, err := uuid.Parse(eventId); err != nil {
+		http.Error(w, `{"error": "Event not found"}`, http.StatusNotFound)
+		return
+	}
+
+	if err := store.Labels().RemoveFromEvent(r.Context(), eventId, labelId); err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Error removing label from event"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
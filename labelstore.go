@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+type PostgresLabelStore struct {
+	db Querier
+}
+
+func (s *PostgresLabelStore) ListForUser(ctx context.Context, userId string) ([]Label, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	rows, err := s.db.Query(ctx,
+		`
+		SELECT id, user_id, name, color FROM labels
+		WHERE user_id = $1
+		ORDER BY name ASC
+		`,
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	labels := []Label{}
+	for rows.Next() {
+		var label Label
+		if err := rows.Scan(&label.Id, &label.UserId, &label.Name, &label.Color); err != nil {
+			return nil, err
+		}
+		labels = append(labels, label)
+	}
+	return labels, rows.Err()
+}
+
+func (s *PostgresLabelStore) IsOwner(ctx context.Context, labelId string, userId string) (bool, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	if _, err := uuid.Parse(labelId); err != nil {
+		return false, nil
+	}
+
+	var exists bool
+	err := s.db.QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM labels WHERE id = $1 AND user_id = $2)",
+		labelId,
+		userId,
+	).Scan(&exists)
+	return exists, err
+}
+
+func (s *PostgresLabelStore) Create(ctx context.Context, label Label) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	_, err := s.db.Exec(ctx,
+		`
+		INSERT INTO labels (id, user_id, name, color)
+		VALUES ($1, $2, $3, $4)
+		`,
+		label.Id,
+		label.UserId,
+		label.Name,
+		label.Color,
+	)
+	return conflictOnDuplicate(err)
+}
+
+func (s *PostgresLabelStore) Update(ctx context.Context, label Label) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	if _, err := uuid.Parse(label.Id); err != nil {
+		return ErrNotFound
+	}
+
+	return conflictOnDuplicate(expectRows(s.db.Exec(ctx,
+		`
+		UPDATE labels
+		SET name = $1, color = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3 AND user_id = $4
+		`,
+		label.Name,
+		label.Color,
+		label.Id,
+		label.UserId,
+	)))
+}
+
+func (s *PostgresLabelStore) Delete(ctx context.Context, labelId string, userId string) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	if _, err := uuid.Parse(labelId); err != nil {
+		return ErrNotFound
+	}
+
+	return expectRows(s.db.Exec(ctx,
+		`
+		DELETE FROM labels
+		WHERE id = $1 AND user_id = $2
+		`,
+		labelId,
+		userId,
+	))
+}
+
+// Reads (target id, label id) rows into a map of target id to label ids.
+func (s *PostgresLabelStore) queryLinks(ctx context.Context, query string, args ...any) (map[string][]string, error) {
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := make(map[string][]string)
+	for rows.Next() {
+		var targetId, labelId string
+		if err := rows.Scan(&targetId, &labelId); err != nil {
+			return nil, err
+		}
+		links[targetId] = append(links[targetId], labelId)
+	}
+	return links, rows.Err()
+}
+
+func (s *PostgresLabelStore) TaskLabels(ctx context.Context, userId string) (map[string][]string, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	return s.queryLinks(ctx,
+		`
+		SELECT task_labels.task_id, task_labels.label_id
+		FROM task_labels
+		JOIN labels ON labels.id = task_labels.label_id
+		WHERE labels.user_id = $1
+		`,
+		userId,
+	)
+}
+
+func (s *PostgresLabelStore) EventLabels(ctx context.Context, userId string) (map[string][]string, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	return s.queryLinks(ctx,
+		`
+		SELECT event_labels.event_id, event_labels.label_id
+		FROM event_labels
+		JOIN labels ON labels.id = event_labels.label_id
+		WHERE labels.user_id = $1
+		`,
+		userId,
+	)
+}
+
+func (s *PostgresLabelStore) AddToTask(ctx context.Context, taskId string, labelId string) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	_, err := s.db.Exec(ctx,
+		`
+		INSERT INTO task_labels (task_id, label_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+		`,
+		taskId,
+		labelId,
+	)
+	return err
+}
+
+func (s *PostgresLabelStore) RemoveFromTask(ctx context.Context, taskId string, labelId string) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	if _, err := uuid.Parse(labelId); err != nil {
+		return nil
+	}
+
+	_, err := s.db.Exec(ctx,
+		`
+		DELETE FROM task_labels
+		WHERE task_id = $1 AND label_id = $2
+		`,
+		taskId,
+		labelId,
+	)
+	return err
+}
+
+func (s *PostgresLabelStore) AddToEvent(ctx context.Context, eventId string, labelId string) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	_, err := s.db.Exec(ctx,
+		`
+		INSERT INTO event_labels (event_id, label_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+		`,
+		eventId,
+		labelId,
+	)
+	return err
+}
+
+func (s *PostgresLabelStore) RemoveFromEvent(ctx context.Context, eventId string, labelId string) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	if _, err := uuid.Parse(eventId); err != nil {
+		return nil
+	}
+	if _, err := uuid.Parse(labelId); err != nil {
+		return nil
+	}
+
+	_, err := s.db.Exec(ctx,
+		`
+		DELETE FROM event_labels
+		WHERE event_id = $1 AND label_id = $2
+		`,
+		eventId,
+		labelId,
+	)
+	return err
+}
@@ -2,12 +2,17 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
-	"net/smtp"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
-func GenerateIcal(event Event, delete bool) string {
+// Builds the iCalendar object for an event. method is the iTIP method:
+// "REQUEST" for invitations and updates, "CANCEL" for cancellations and
+// "PUBLISH" for events that are only shared.
+func GenerateIcal(event Event, method string) string {
 	var buf bytes.Buffer
 
 	startTime, err := time.Parse(time.RFC3339, event.Date)
@@ -18,70 +23,171 @@ func GenerateIcal(event Event, delete bool) string {
 
 	endTime := startTime.Add(time.Duration(event.Duration) * time.Minute)
 
-	fmt.Fprintf(&buf, "BEGIN:VCALENDAR\n")
-	fmt.Fprintf(&buf, "VERSION:2.0\n")
-	fmt.Fprintf(&buf, "CALSCALE:GREGORIAN\n")
-	fmt.Fprintf(&buf, "BEGIN:VEVENT\n")
-	fmt.Fprintf(&buf, "UID:%s@prayujt.com\n", event.Id)
-	if delete {
-		fmt.Fprintf(&buf, "SUMMARY:CANCELLED: %s\n", event.Title)
+	fmt.Fprintf(&buf, "BEGIN:VCALENDAR\r\n")
+	fmt.Fprintf(&buf, "VERSION:2.0\r\n")
+	fmt.Fprintf(&buf, "PRODID:-//prayujt.com//Calendar//EN\r\n")
+	fmt.Fprintf(&buf, "CALSCALE:GREGORIAN\r\n")
+	fmt.Fprintf(&buf, "METHOD:%s\r\n", method)
+	fmt.Fprintf(&buf, "BEGIN:VEVENT\r\n")
+	// Every occurrence of a series shares one UID, so updates and cancellations
+	// replace what the recipient's calendar already has
+	fmt.Fprintf(&buf, "UID:%s@prayujt.com\r\n", eventKey(event))
+	fmt.Fprintf(&buf, "SEQUENCE:%d\r\n", event.Sequence)
+	fmt.Fprintf(&buf, "DTSTAMP:%s\r\n", time.Now().UTC().Format("20060102T150405Z"))
+	if event.Occurrence != "" {
+		occurrence, err := time.Parse(time.RFC3339, event.Occurrence)
+		if err != nil {
+			fmt.Println("Error parsing occurrence date:", err)
+			return ""
+		}
+		if event.ThisAndFuture {
+			fmt.Fprintf(&buf, "RECURRENCE-ID;RANGE=THISANDFUTURE:%s\r\n", occurrence.UTC().Format("20060102T150405Z"))
+		} else {
+			fmt.Fprintf(&buf, "RECURRENCE-ID:%s\r\n", occurrence.UTC().Format("20060102T150405Z"))
+		}
+	}
+	if method == "CANCEL" {
+		fmt.Fprintf(&buf, "SUMMARY:CANCELLED: %s\r\n", icalText(event.Title))
 	} else {
-		fmt.Fprintf(&buf, "SUMMARY:%s\n", event.Title)
+		fmt.Fprintf(&buf, "SUMMARY:%s\r\n", icalText(event.Title))
 	}
 	if event.Description != nil {
-		fmt.Fprintf(&buf, "DESCRIPTION:%s\n", *event.Description)
+		fmt.Fprintf(&buf, "DESCRIPTION:%s\r\n", icalText(*event.Description))
+	}
+	if event.Location != nil && *event.Location != "" {
+		fmt.Fprintf(&buf, "LOCATION:%s\r\n", icalText(*event.Location))
+	}
+	fmt.Fprintf(&buf, "DTSTART:%s\r\n", startTime.UTC().Format("20060102T150405Z"))
+	fmt.Fprintf(&buf, "DTEND:%s\r\n", endTime.UTC().Format("20060102T150405Z"))
+
+	if event.RecurrenceId != "" && event.Occurrence == "" {
+		fmt.Fprintf(&buf, "RRULE:FREQ=WEEKLY;INTERVAL=1\r\n")
 	}
-	fmt.Fprintf(&buf, "DTSTART:%s\n", startTime.Format("20060102T150405Z"))
-	fmt.Fprintf(&buf, "DTEND:%s\n", endTime.Format("20060102T150405Z"))
 
-	if event.RecurrenceId != "" {
-		fmt.Fprintf(&buf, "RRULE:FREQ=WEEKLY;INTERVAL=1\n")
+	if method != "PUBLISH" {
+		fmt.Fprintf(&buf, "ORGANIZER;CN=%s:mailto:%s\r\n", icalParam(fromName), fromEmail)
+		for _, attendee := range event.Attendees {
+			fmt.Fprintf(&buf, "ATTENDEE;PARTSTAT=%s;RSVP=TRUE:mailto:%s\r\n", strings.ToUpper(attendee.Status), attendee.Email)
+		}
 	}
 
-	if delete {
-		fmt.Fprintf(&buf, "STATUS:CANCELLED\n")
+	if method == "CANCEL" {
+		fmt.Fprintf(&buf, "STATUS:CANCELLED\r\n")
 	} else {
-		fmt.Fprintf(&buf, "STATUS:CONFIRMED\n")
+		fmt.Fprintf(&buf, "STATUS:CONFIRMED\r\n")
+		for _, reminder := range event.Reminders {
+			fmt.Fprintf(&buf, "BEGIN:VALARM\r\n")
+			fmt.Fprintf(&buf, "ACTION:DISPLAY\r\n")
+			fmt.Fprintf(&buf, "DESCRIPTION:%s\r\n", icalText(event.Title))
+			fmt.Fprintf(&buf, "TRIGGER:-PT%dM\r\n", reminder.MinutesBefore)
+			fmt.Fprintf(&buf, "END:VALARM\r\n")
+		}
 	}
-	fmt.Fprintf(&buf, "END:VEVENT\n")
-	fmt.Fprintf(&buf, "END:VCALENDAR\n")
-	return buf.String()
+	fmt.Fprintf(&buf, "END:VEVENT\r\n")
+	fmt.Fprintf(&buf, "END:VCALENDAR\r\n")
+	return foldIcal(buf.String())
 }
 
-func SendEvent(to []string, body string, event Event, delete bool) {
-	fromEmail := "calendar@prayujt.com"
-	fromName := "Prayuj Calendar"
+// Quotes a parameter value, which can't contain double quotes.
+func icalParam(value string) string {
+	return `"` + strings.ReplaceAll(value, `"`, "'") + `"`
+}
 
-	smtpHost := "mail.prayujt.com"
-	smtpPort := "587"
+// Escapes a TEXT value as RFC 5545 requires.
+func icalText(value string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(value)
+}
 
-	subject := fmt.Sprintf("Subject: %s\n", event.Title)
-	fromHeader := fmt.Sprintf("From: %s <%s>\n", fromName, fromEmail)
-	toHeader := fmt.Sprintf("To: %s\n", to[0])
+// Folds content lines longer than 75 octets, without splitting UTF-8 characters.
+func foldIcal(content string) string {
+	var buf strings.Builder
+	for _, line := range strings.SplitAfter(content, "\r\n") {
+		for len(line) > 77 {
+			cut := 75
+			for cut > 0 && !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			buf.WriteString(line[:cut] + "\r\n ")
+			line = line[cut:]
+		}
+		buf.WriteString(line)
+	}
+	return buf.String()
+}
 
-	dateHeader := fmt.Sprintf("Date: %s\n", time.Now().Format(time.RFC1123Z))
+var fromEmail string
+var fromName string
 
-	icalContent := GenerateIcal(event, delete)
+var icalMethods = map[string]string{
+	"new":       "REQUEST",
+	"updated":   "REQUEST",
+	"cancelled": "CANCEL",
+	"shared":    "PUBLISH",
+}
 
-	// Construct the full message with headers and body
-	message := []byte(fromHeader + toHeader + subject + dateHeader + "MIME-Version: 1.0\n" +
-		"Content-Type: multipart/mixed; boundary=boundary\n\n" +
-		"--boundary\n" +
-		"Content-Type: text/plain; charset=\"utf-8\"\n" +
-		"Content-Transfer-Encoding: 7bit\n\n" +
-		body + "\n\n" +
-		"--boundary\n" +
-		"Content-Type: text/calendar; charset=\"utf-8\"\n" +
-		"Content-Disposition: attachment; filename=\"event.ics\"\n" +
-		"Content-Transfer-Encoding: 7bit\n\n" +
-		icalContent + "\n" +
-		"--boundary--")
+// Queues an email about the event to every recipient, rendered from the template
+// for kind ("new", "updated", "cancelled" or "shared") in each recipient's locale
+// and time zone. Pass the transaction that changes the event so the emails are
+// only sent if the change is committed.
+func QueueEventMail(ctx context.Context, tx Store, kind string, event Event, organizer string, to []string) error {
+	settings, err := recipientSettings(ctx, tx, to)
+	if err != nil {
+		return err
+	}
+	method := icalMethods[kind]
+	icalContent := GenerateIcal(event, method)
+
+	for _, recipient := range to {
+		mail, err := newEventMail(event, organizer, recipient, settings[recipient])
+		if err != nil {
+			return err
+		}
+		subject, text, html, err := RenderEventMail(kind, mail)
+		if err != nil {
+			return err
+		}
 
-	auth := smtp.PlainAuth("", fromEmail, mailPassword, smtpHost)
+		message, err := OutgoingMail{
+			To:       []string{recipient},
+			Subject:  subject,
+			Text:     text,
+			HTML:     html,
+			Calendar: icalContent,
+			Method:   method,
+			Thread:   eventKey(event),
+			// Updates and cancellations reply to the invitation
+			StartsThread: kind == "new",
+		}.Bytes()
+		if err != nil {
+			return err
+		}
+
+		if err := EnqueueMessage(ctx, tx, []string{recipient}, message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	err := smtp.SendMail(smtpHost+":"+smtpPort, auth, fromEmail, to, message)
+// Queues a plain text email.
+func QueueMail(ctx context.Context, tx Store, to []string, subject string, body string) error {
+	message, err := OutgoingMail{To: to, Subject: subject, Text: body}.Bytes()
 	if err != nil {
-		fmt.Println("Error sending email:", err)
-		return
+		return err
+	}
+
+	return EnqueueMessage(ctx, tx, to, message)
+}
+
+// Messages are signed when they are sent rather than when they are queued, so
+// retries after a key rotation carry a valid signature.
+func sendMessage(to []string, message []byte) error {
+	if dkimSigner != nil {
+		signed, err := dkimSigner.Sign(message)
+		if err != nil {
+			return err
+		}
+		message = signed
 	}
+	return mailer.Send(fromEmail, to, message)
 }
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A Mailer delivers a fully built RFC 5322 message.
+type Mailer interface {
+	Send(from string, to []string, message []byte) error
+}
+
+type MailConfig struct {
+	Transport string
+	FromEmail string
+	FromName  string
+
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	// One of "starttls", "tls" (implicit TLS, usually port 465) or "none"
+	SMTPSecurity string
+
+	MaildirPath string
+
+	// Messages are DKIM signed when a selector and key are set. The domain
+	// defaults to the one in FromEmail.
+	DKIMSelector string
+	DKIMKeyPath  string
+	DKIMDomain   string
+}
+
+var mailer Mailer
+
+func InitMailer(config MailConfig) error {
+	fromEmail = config.FromEmail
+	fromName = config.FromName
+
+	if config.DKIMSelector != "" || config.DKIMKeyPath != "" {
+		if config.DKIMSelector == "" || config.DKIMKeyPath == "" {
+			return fmt.Errorf("DKIM needs both a selector and a private key")
+		}
+		domain := config.DKIMDomain
+		if domain == "" {
+			domain = mailDomain()
+		}
+		signer, err := NewDKIMSigner(domain, config.DKIMSelector, config.DKIMKeyPath)
+		if err != nil {
+			return err
+		}
+		dkimSigner = signer
+	}
+
+	switch config.Transport {
+	case "smtp":
+		if config.SMTPHost == "" {
+			return fmt.Errorf("SMTP host must be set")
+		}
+		switch config.SMTPSecurity {
+		case "starttls", "tls", "none":
+		default:
+			return fmt.Errorf("unknown SMTP security %q", config.SMTPSecurity)
+		}
+		mailer = &SMTPMailer{
+			Host:     config.SMTPHost,
+			Port:     config.SMTPPort,
+			Username: config.SMTPUsername,
+			Password: config.SMTPPassword,
+			Security: config.SMTPSecurity,
+		}
+	case "maildir":
+		maildir := &MaildirMailer{Path: config.MaildirPath}
+		if err := maildir.init(); err != nil {
+			return err
+		}
+		mailer = maildir
+	case "memory":
+		mailer = &MemoryMailer{}
+	default:
+		return fmt.Errorf("unknown mail transport %q", config.Transport)
+	}
+	return nil
+}
+
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	Security string
+}
+
+var smtpTimeout = 30 * time.Second
+
+// Trusted instead of the system roots when set.
+var smtpRootCAs *x509.CertPool
+
+func (m *SMTPMailer) Send(from string, to []string, message []byte) error {
+	addr := net.JoinHostPort(m.Host, m.Port)
+	tlsConfig := &tls.Config{ServerName: m.Host, RootCAs: smtpRootCAs}
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+
+	var conn net.Conn
+	var err error
+	if m.Security == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	client, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if m.Security == "starttls" {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s does not support STARTTLS", addr)
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	if m.Username != "" && m.Password != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.Username, m.Password, m.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		if err := client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// Writes every message into a maildir, which most mail clients can open, so
+// development setups don't need a mail server.
+type MaildirMailer struct {
+	Path string
+}
+
+func (m *MaildirMailer) init() error {
+	if m.Path == "" {
+		return fmt.Errorf("maildir path must be set")
+	}
+	for _, dir := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(m.Path, dir), 0o755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MaildirMailer) Send(from string, to []string, message []byte) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	name := fmt.Sprintf("%d.%s.%s", time.Now().UnixNano(), uuid.New().String(), hostname)
+
+	// Maildir readers only look at new/, so the rename makes delivery atomic
+	tmp := filepath.Join(m.Path, "tmp", name)
+	if err := os.WriteFile(tmp, message, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(m.Path, "new", name))
+}
+
+type SentMessage struct {
+	From    string
+	To      []string
+	Message []byte
+}
+
+// Keeps every message in memory, for tests.
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []SentMessage
+	err      error
+}
+
+func (m *MemoryMailer) Send(from string, to []string, message []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return m.err
+	}
+	m.messages = append(m.messages, SentMessage{
+		From:    from,
+		To:      append([]string{}, to...),
+		Message: append([]byte{}, message...),
+	})
+	return nil
+}
+
+func (m *MemoryMailer) Messages() []SentMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]SentMessage{}, m.messages...)
+}
+
+// Makes every Send fail with err until it is called again with nil.
+func (m *MemoryMailer) Fail(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.err = err
+}
+
+func (m *MemoryMailer) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = nil
+}
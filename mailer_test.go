@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A message as a fakeSMTPServer received it.
+type receivedMail struct {
+	TLS bool
+	// The decoded AUTH PLAIN response, "\x00user\x00password"
+	Auth string
+	From string
+	To   []string
+	Data string
+}
+
+// Speaks just enough SMTP for SMTPMailer, recording every message it accepts.
+type fakeSMTPServer struct {
+	listener net.Listener
+	// Offered with STARTTLS, or used for the whole connection when implicit
+	tlsConfig   *tls.Config
+	implicitTLS bool
+
+	mu       sync.Mutex
+	received []receivedMail
+	// Replies to RCPT TO by recipient, in place of 250
+	rcptReplies map[string]string
+}
+
+func newFakeSMTPServer(t *testing.T, tlsConfig *tls.Config, implicitTLS bool) *fakeSMTPServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeSMTPServer{
+		listener:    listener,
+		tlsConfig:   tlsConfig,
+		implicitTLS: implicitTLS,
+		rcptReplies: map[string]string{},
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+// An SMTPMailer pointed at the server.
+func (s *fakeSMTPServer) mailer(security string) *SMTPMailer {
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return &SMTPMailer{Host: "localhost", Port: port, Security: security}
+}
+
+func (s *fakeSMTPServer) reply(recipient string, reply string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rcptReplies[recipient] = reply
+}
+
+func (s *fakeSMTPServer) messages() []receivedMail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]receivedMail{}, s.received...)
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	if s.implicitTLS {
+		conn = tls.Server(conn, s.tlsConfig)
+	}
+	text := textproto.NewConn(conn)
+	defer func() { text.Close() }()
+
+	mail := receivedMail{TLS: s.implicitTLS}
+	text.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			text.PrintfLine("250-localhost")
+			if s.tlsConfig != nil && !mail.TLS {
+				text.PrintfLine("250-STARTTLS")
+			}
+			text.PrintfLine("250 AUTH PLAIN")
+		case "STARTTLS":
+			text.PrintfLine("220 Ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, text = tlsConn, textproto.NewConn(tlsConn)
+			mail.TLS = true
+		case "AUTH":
+			_, response, _ := strings.Cut(arg, " ")
+			decoded, err := base64.StdEncoding.DecodeString(response)
+			if err != nil {
+				text.PrintfLine("501 Invalid response")
+				continue
+			}
+			mail.Auth = string(decoded)
+			text.PrintfLine("235 Authenticated")
+		case "MAIL":
+			mail.From = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			text.PrintfLine("250 OK")
+		case "RCPT":
+			recipient := strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>")
+			s.mu.Lock()
+			reply := s.rcptReplies[recipient]
+			s.mu.Unlock()
+			if reply != "" {
+				text.PrintfLine("%s", reply)
+				continue
+			}
+			mail.To = append(mail.To, recipient)
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			mail.Data = string(data)
+			s.mu.Lock()
+			s.received = append(s.received, mail)
+			s.mu.Unlock()
+			mail = receivedMail{TLS: mail.TLS, Auth: mail.Auth}
+			text.PrintfLine("250 Queued")
+		case "RSET":
+			mail = receivedMail{TLS: mail.TLS, Auth: mail.Auth}
+			text.PrintfLine("250 OK")
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+// A self-signed certificate for localhost, trusted by SMTPMailer until the
+// test ends.
+func trustTestCertificate(t *testing.T) *tls.Config {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldRoots := smtpRootCAs
+	t.Cleanup(func() { smtpRootCAs = oldRoots })
+	smtpRootCAs = x509.NewCertPool()
+	smtpRootCAs.AddCert(certificate)
+
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func TestDeliverOutboxOverSMTP(t *testing.T) {
+	setupOutboxTest(t)
+	server := newFakeSMTPServer(t, nil, false)
+	mailer = server.mailer("none")
+	ctx := context.Background()
+
+	to := []string{"first@example.com", "second@example.com"}
+	message := "Subject: Hello\r\n\r\nHi\r\n.leading dot\r\n"
+	if err := EnqueueMessage(ctx, store, to, []byte(message)); err != nil {
+		t.Fatal(err)
+	}
+	DeliverOutbox(ctx)
+
+	received := server.messages()
+	if len(received) != 1 {
+		t.Fatalf("server received %d messages, want 1", len(received))
+	}
+	got := received[0]
+	if got.From != fromEmail || strings.Join(got.To, ",") != strings.Join(to, ",") {
+		t.Fatalf("got envelope from %s to %v, want from %s to %v", got.From, got.To, fromEmail, to)
+	}
+	if want := strings.ReplaceAll(message, "\r\n", "\n"); got.Data != want {
+		t.Fatalf("got DATA %q, want %q", got.Data, want)
+	}
+	if sent := outboxMessages(t, "sent"); len(sent) != 1 {
+		t.Fatalf("got %d sent messages in the outbox, want 1", len(sent))
+	}
+}
+
+func TestDeliverOutboxRetriesTemporarySMTPFailures(t *testing.T) {
+	_, advance := setupOutboxTest(t)
+	server := newFakeSMTPServer(t, nil, false)
+	mailer = server.mailer("none")
+	ctx := context.Background()
+
+	if err := EnqueueMessage(ctx, store, []string{"user@example.com"}, []byte("Subject: Hello\r\n\r\nHi\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	server.reply("user@example.com", "451 4.3.0 Try again later")
+	DeliverOutbox(ctx)
+
+	pending := outboxMessages(t, "pending")
+	if len(pending) != 1 || pending[0].Attempts != 1 || pending[0].LastError == nil || !strings.HasPrefix(*pending[0].LastError, "451") {
+		t.Fatalf("got %+v, want the message pending after a 451", pending)
+	}
+	if received := server.messages(); len(received) != 0 {
+		t.Fatalf("server received %d messages, want 0", len(received))
+	}
+
+	server.reply("user@example.com", "")
+	advance(mailBackoff(1))
+	DeliverOutbox(ctx)
+
+	if received := server.messages(); len(received) != 1 {
+		t.Fatalf("server received %d messages on the retry, want 1", len(received))
+	}
+	if sent := outboxMessages(t, "sent"); len(sent) != 1 || sent[0].Attempts != 2 {
+		t.Fatalf("got %+v, want the message sent on the second attempt", sent)
+	}
+}
+
+func TestSMTPMailerSecurity(t *testing.T) {
+	tlsConfig := trustTestCertificate(t)
+	message := []byte("Subject: Hello\r\n\r\nHi\r\n")
+
+	for _, test := range []struct {
+		security    string
+		implicitTLS bool
+	}{
+		{"starttls", false},
+		{"tls", true},
+	} {
+		t.Run(test.security, func(t *testing.T) {
+			server := newFakeSMTPServer(t, tlsConfig, test.implicitTLS)
+			smtpMailer := server.mailer(test.security)
+			smtpMailer.Username, smtpMailer.Password = "user", "secret"
+
+			if err := smtpMailer.Send("calendar@example.com", []string{"user@example.com"}, message); err != nil {
+				t.Fatal(err)
+			}
+			received := server.messages()
+			if len(received) != 1 || !received[0].TLS || received[0].Auth != "\x00user\x00secret" {
+				t.Fatalf("got %+v, want one message sent over TLS after authenticating", received)
+			}
+		})
+	}
+
+	t.Run("starttls not offered", func(t *testing.T) {
+		server := newFakeSMTPServer(t, nil, false)
+		err := server.mailer("starttls").Send("calendar@example.com", []string{"user@example.com"}, message)
+		if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+			t.Fatalf("got %v, want an error about STARTTLS", err)
+		}
+		if received := server.messages(); len(received) != 0 {
+			t.Fatalf("server received %d messages in the clear, want 0", len(received))
+		}
+	})
+
+	t.Run("permanent failure", func(t *testing.T) {
+		server := newFakeSMTPServer(t, nil, false)
+		server.reply("missing@example.com", "550 5.1.1 No such user")
+		err := server.mailer("none").Send("calendar@example.com", []string{"user@example.com", "missing@example.com"}, message)
+
+		var protocolErr *textproto.Error
+		if !errors.As(err, &protocolErr) || protocolErr.Code != 550 {
+			t.Fatalf("got %v, want the server's 550", err)
+		}
+	})
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+//go:embed templates/mail
+var mailTemplateFS embed.FS
+
+var eventMailKinds = []string{"new", "updated", "cancelled", "shared"}
+
+type RSVPLinks struct {
+	Accept    string
+	Tentative string
+	Decline   string
+}
+
+// Everything the event templates can show, already formatted for the recipient.
+type EventMail struct {
+	Locale      string
+	Title       string
+	Start       string
+	End         string
+	Timezone    string
+	Location    string
+	Description string
+	Organizer   string
+	From        string
+	Attendees   []Attendee
+	RSVP        *RSVPLinks
+}
+
+var catalogs = map[string]map[string]string{
+	"en": {
+		"subject.new":           "Invitation: %s",
+		"subject.updated":       "Updated invitation: %s",
+		"subject.cancelled":     "Cancelled: %s",
+		"subject.shared":        "Shared event: %s",
+		"intro.new":             "%s invited you to an event.",
+		"intro.updated":         "%s updated an event you're invited to.",
+		"intro.cancelled":       "%s cancelled an event you were invited to.",
+		"intro.shared":          "%s shared an event with you.",
+		"when":                  "When",
+		"where":                 "Where",
+		"organizer":             "Organizer",
+		"attendees":             "Attendees",
+		"status.needs-action":   "awaiting response",
+		"status.accepted":       "accepted",
+		"status.declined":       "declined",
+		"status.tentative":      "maybe",
+		"rsvp.prompt":           "Are you going?",
+		"rsvp.accept":           "Yes",
+		"rsvp.tentative":        "Maybe",
+		"rsvp.decline":          "No",
+		"footer":                "Sent by %s",
+		"subject.digest.daily":  "Your agenda for %s",
+		"subject.digest.weekly": "Your week ahead: %s",
+		"digest.heading.daily":  "Your agenda for %s",
+		"digest.heading.weekly": "Your week starting %s",
+		"digest.no-events":      "Nothing scheduled.",
+		"digest.tasks":          "Tasks",
+		"digest.no-tasks":       "No tasks due.",
+		"digest.due":            "due %s",
+		"digest.overdue":        "overdue since %s",
+		"digest.unsubscribe":    "Unsubscribe from this email",
+
+		"page.unsubscribe.title":             "Unsubscribe",
+		"page.unsubscribe.button":            "Unsubscribe",
+		"page.unsubscribe.confirm.daily":     "Stop getting the daily digest?",
+		"page.unsubscribe.confirm.weekly":    "Stop getting the weekly digest?",
+		"page.unsubscribe.confirm.reminders": "Stop getting reminder emails? Reminders will still show up as notifications.",
+		"page.unsubscribe.confirm.all":       "Stop getting all calendar emails?",
+		"page.unsubscribe.done.daily":        "You have been unsubscribed from the daily digest.",
+		"page.unsubscribe.done.weekly":       "You have been unsubscribed from the weekly digest.",
+		"page.unsubscribe.done.reminders":    "You will no longer get reminder emails. Reminders will still show up as notifications.",
+		"page.unsubscribe.done.all":          "You have been unsubscribed from all calendar emails.",
+		"page.rsvp.title.accepted":           "Accept invitation",
+		"page.rsvp.title.tentative":          "Maybe",
+		"page.rsvp.title.declined":           "Decline invitation",
+		"page.rsvp.confirm.accepted":         "Let the organizer know you're going?",
+		"page.rsvp.confirm.tentative":        "Let the organizer know you might go?",
+		"page.rsvp.confirm.declined":         "Let the organizer know you aren't going?",
+		"page.rsvp.button.accepted":          "Accept",
+		"page.rsvp.button.tentative":         "Maybe",
+		"page.rsvp.button.declined":          "Decline",
+		"page.rsvp.done":                     "Your response (%s) has been recorded.",
+	},
+	"es": {
+		"subject.new":           "Invitación: %s",
+		"subject.updated":       "Invitación actualizada: %s",
+		"subject.cancelled":     "Cancelado: %s",
+		"subject.shared":        "Evento compartido: %s",
+		"intro.new":             "%s te ha invitado a un evento.",
+		"intro.updated":         "%s ha actualizado un evento al que estás invitado.",
+		"intro.cancelled":       "%s ha cancelado un evento al que estabas invitado.",
+		"intro.shared":          "%s ha compartido un evento contigo.",
+		"when":                  "Cuándo",
+		"where":                 "Dónde",
+		"organizer":             "Organizador",
+		"attendees":             "Asistentes",
+		"status.needs-action":   "sin respuesta",
+		"status.accepted":       "asistirá",
+		"status.declined":       "no asistirá",
+		"status.tentative":      "quizás",
+		"rsvp.prompt":           "¿Asistirás?",
+		"rsvp.accept":           "Sí",
+		"rsvp.tentative":        "Quizás",
+		"rsvp.decline":          "No",
+		"footer":                "Enviado por %s",
+		"subject.digest.daily":  "Tu agenda para el %s",
+		"subject.digest.weekly": "Tu semana: %s",
+		"digest.heading.daily":  "Tu agenda para el %s",
+		"digest.heading.weekly": "Tu semana a partir del %s",
+		"digest.no-events":      "Nada programado.",
+		"digest.tasks":          "Tareas",
+		"digest.no-tasks":       "No hay tareas pendientes.",
+		"digest.due":            "vence %s",
+		"digest.overdue":        "vencida desde %s",
+		"digest.unsubscribe":    "Cancelar la suscripción a este correo",
+
+		"page.unsubscribe.title":             "Cancelar suscripción",
+		"page.unsubscribe.button":            "Cancelar suscripción",
+		"page.unsubscribe.confirm.daily":     "¿Dejar de recibir el resumen diario?",
+		"page.unsubscribe.confirm.weekly":    "¿Dejar de recibir el resumen semanal?",
+		"page.unsubscribe.confirm.reminders": "¿Dejar de recibir recordatorios por correo? Los recordatorios seguirán apareciendo como notificaciones.",
+		"page.unsubscribe.confirm.all":       "¿Dejar de recibir todos los correos del calendario?",
+		"page.unsubscribe.done.daily":        "Ya no recibirás el resumen diario.",
+		"page.unsubscribe.done.weekly":       "Ya no recibirás el resumen semanal.",
+		"page.unsubscribe.done.reminders":    "Ya no recibirás recordatorios por correo. Los recordatorios seguirán apareciendo como notificaciones.",
+		"page.unsubscribe.done.all":          "Ya no recibirás ningún correo del calendario.",
+		"page.rsvp.title.accepted":           "Aceptar invitación",
+		"page.rsvp.title.tentative":          "Quizás",
+		"page.rsvp.title.declined":           "Rechazar invitación",
+		"page.rsvp.confirm.accepted":         "¿Avisar al organizador de que asistirás?",
+		"page.rsvp.confirm.tentative":        "¿Avisar al organizador de que quizás asistas?",
+		"page.rsvp.confirm.declined":         "¿Avisar al organizador de que no asistirás?",
+		"page.rsvp.button.accepted":          "Aceptar",
+		"page.rsvp.button.tentative":         "Quizás",
+		"page.rsvp.button.declined":          "Rechazar",
+		"page.rsvp.done":                     "Se ha registrado tu respuesta (%s).",
+	},
+}
+
+var weekdayNames = map[string][7]string{
+	"es": {"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"},
+}
+
+var monthNames = map[string][12]string{
+	"es": {"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+}
+
+func translate(locale string, key string, args ...any) string {
+	message, ok := catalogs[locale][key]
+	if !ok {
+		message, ok = catalogs[defaultLocale][key]
+	}
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return message
+	}
+	return fmt.Sprintf(message, args...)
+}
+
+// Formats a time the way the locale writes dates, since Go only knows English names.
+func formatTime(locale string, t time.Time) string {
+	switch locale {
+	case "es":
+		return fmt.Sprintf("%s, %d de %s de %d, %s", weekdayNames["es"][t.Weekday()], t.Day(), monthNames["es"][t.Month()-1], t.Year(), t.Format("15:04"))
+	default:
+		return t.Format("Monday, January 2, 2006 at 3:04 PM")
+	}
+}
+
+// Formats just the date, e.g. for the days of a digest.
+func formatDate(locale string, t time.Time) string {
+	switch locale {
+	case "es":
+		return fmt.Sprintf("%s %d de %s", weekdayNames["es"][t.Weekday()], t.Day(), monthNames["es"][t.Month()-1])
+	default:
+		return t.Format("Monday, January 2")
+	}
+}
+
+// Formats just the time of day.
+func formatClock(locale string, t time.Time) string {
+	switch locale {
+	case "es":
+		return t.Format("15:04")
+	default:
+		return t.Format("3:04 PM")
+	}
+}
+
+// The translation function is swapped for the recipient's locale before every render.
+var placeholderFuncs = map[string]any{
+	"t": func(key string, args ...any) string { return key },
+}
+
+var eventTextTemplates = make(map[string]*texttemplate.Template)
+var eventHtmlTemplates = make(map[string]*htmltemplate.Template)
+
+var digestTextTemplate *texttemplate.Template
+var digestHtmlTemplate *htmltemplate.Template
+
+func init() {
+	for _, kind := range eventMailKinds {
+		eventTextTemplates[kind] = texttemplate.Must(
+			texttemplate.New("").Funcs(placeholderFuncs).ParseFS(mailTemplateFS,
+				"templates/mail/event_"+kind+".txt",
+				"templates/mail/event_details.txt",
+			),
+		)
+		eventHtmlTemplates[kind] = htmltemplate.Must(
+			htmltemplate.New("").Funcs(placeholderFuncs).ParseFS(mailTemplateFS,
+				"templates/mail/event_"+kind+".html",
+				"templates/mail/event_details.html",
+			),
+		)
+	}
+
+	digestTextTemplate = texttemplate.Must(
+		texttemplate.New("").Funcs(placeholderFuncs).ParseFS(mailTemplateFS,
+			"templates/mail/digest.txt",
+			"templates/mail/event_details.txt",
+		),
+	)
+	digestHtmlTemplate = htmltemplate.Must(
+		htmltemplate.New("").Funcs(placeholderFuncs).ParseFS(mailTemplateFS,
+			"templates/mail/digest.html",
+			"templates/mail/event_details.html",
+		),
+	)
+}
+
+// Renders the plain text and HTML bodies from the named templates, translated
+// into locale.
+func renderMail(textTemplate *texttemplate.Template, htmlTemplate *htmltemplate.Template, name string, locale string, data any) (string, string, error) {
+	t := func(key string, args ...any) string { return translate(locale, key, args...) }
+
+	textTemplate, err := textTemplate.Clone()
+	if err != nil {
+		return "", "", err
+	}
+	var text bytes.Buffer
+	err = textTemplate.Funcs(map[string]any{"t": t}).ExecuteTemplate(&text, name+".txt", data)
+	if err != nil {
+		return "", "", err
+	}
+
+	htmlTemplate, err = htmlTemplate.Clone()
+	if err != nil {
+		return "", "", err
+	}
+	var html bytes.Buffer
+	err = htmlTemplate.Funcs(map[string]any{"t": t}).ExecuteTemplate(&html, name+".html", data)
+	if err != nil {
+		return "", "", err
+	}
+
+	return strings.TrimSpace(text.String()), html.String(), nil
+}
+
+// Renders the subject, plain text and HTML bodies of an event email.
+func RenderEventMail(kind string, mail EventMail) (string, string, string, error) {
+	text, html, err := renderMail(eventTextTemplates[kind], eventHtmlTemplates[kind], "event_"+kind, mail.Locale, mail)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	subject := translate(mail.Locale, "subject."+kind, mail.Title)
+	return subject, text, html, nil
+}
+
+// Builds the template data for one recipient, showing times in their time zone
+// and their own RSVP links.
+func newEventMail(event Event, organizer string, recipient string, settings Settings) (EventMail, error) {
+	start, err := time.Parse(time.RFC3339, event.Date)
+	if err != nil {
+		return EventMail{}, err
+	}
+	location := settings.Location()
+	start = start.In(location)
+	end := start.Add(time.Duration(event.Duration) * time.Minute)
+
+	mail := EventMail{
+		Locale:    settings.Locale,
+		Title:     event.Title,
+		Start:     formatTime(settings.Locale, start),
+		End:       formatTime(settings.Locale, end),
+		Timezone:  location.String(),
+		Organizer: organizer,
+		From:      fromName,
+		Attendees: event.Attendees,
+	}
+	if event.Location != nil {
+		mail.Location = *event.Location
+	}
+	if event.Description != nil {
+		mail.Description = *event.Description
+	}
+
+	for _, attendee := range event.Attendees {
+		if strings.EqualFold(attendee.Email, recipient) {
+			mail.RSVP = &RSVPLinks{
+				Accept:    rsvpUrl(attendee, "accepted"),
+				Tentative: rsvpUrl(attendee, "tentative"),
+				Decline:   rsvpUrl(attendee, "declined"),
+			}
+		}
+	}
+	return mail, nil
+}
+
+// Looks up the locale and time zone for each recipient that has an account.
+// Everyone else gets the defaults.
+func recipientSettings(ctx context.Context, tx Store, recipients []string) (map[string]Settings, error) {
+	settings := make(map[string]Settings)
+	for _, recipient := range recipients {
+		user, err := tx.Users().GetByEmail(ctx, recipient)
+		if errors.Is(err, ErrNotFound) {
+			settings[recipient] = Settings{Locale: defaultLocale, Timezone: defaultTimezone}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		stored, err := tx.Settings().Get(ctx, user.Id)
+		if errors.Is(err, ErrNotFound) {
+			stored = defaultSettings(user.Id)
+		} else if err != nil {
+			return nil, err
+		}
+		settings[recipient] = stored
+	}
+	return settings, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRecipientSettingsUseStoredUsers(t *testing.T) {
+	memory := NewMemoryStore()
+	ctx := context.Background()
+
+	if err := memory.Users().Save(ctx, testUser); err != nil {
+		t.Fatal(err)
+	}
+	saved := Settings{UserId: testUser.Id, Locale: "es", Timezone: "Europe/Madrid"}
+	if err := memory.Settings().Save(ctx, saved); err != nil {
+		t.Fatal(err)
+	}
+
+	recipients := []string{"TEST@email.com", "stranger@example.com"}
+	settings, err := recipientSettings(ctx, memory, recipients)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := settings[recipients[0]]; got.Locale != "es" || got.Timezone != "Europe/Madrid" {
+		t.Fatalf("got %+v for the user, want their saved settings", got)
+	}
+	if got := settings[recipients[1]]; got.Locale != defaultLocale || got.Timezone != defaultTimezone {
+		t.Fatalf("got %+v for someone without an account, want the defaults", got)
+	}
+}
@@ -1,21 +1,30 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
-	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
 type Session struct {
-	Id       string   `json:"id"`
-	Active   bool     `json:"active"`
-	Identity Identity `json:"identity"`
+	Id        string    `json:"id"`
+	Active    bool      `json:"active"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Identity  Identity  `json:"identity"`
+	// Set when the request authenticated with a personal access token
+	TokenId string   `json:"-"`
+	Scopes  []string `json:"-"`
 }
 
 type Identity struct {
@@ -32,80 +41,162 @@ type Traits struct {
 	Avatar    string `json:"avatar"`
 }
 
-var kratosPublicUrl string
-var kratosAdminUrl string
+func (traits Traits) DisplayName() string {
+	name := strings.TrimSpace(traits.FirstName + " " + traits.LastName)
+	if name == "" {
+		return traits.Email
+	}
+	return name
+}
+
 var environment string
-var mailPassword string
+var adminUserIds []string
 
 func main() {
-	kratosPublicUrl = os.Getenv("KRATOS_PUBLIC_URL")
-	if kratosPublicUrl == "" {
-		kratosPublicUrl = "https://idp.prayujt.com"
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrateCommand(os.Args[2:])
+		return
 	}
-	kratosAdminUrl = os.Getenv("KRATOS_ADMIN_URL")
-	if kratosAdminUrl == "" {
-		kratosAdminUrl = "http://kratos-admin.kratos.svc.cluster.local"
+
+	identityConfig := IdentityConfig{
+		Provider:        getEnv("IDENTITY_PROVIDER", "kratos"),
+		KratosPublicUrl: getEnv("KRATOS_PUBLIC_URL", "https://idp.prayujt.com"),
+		KratosAdminUrl:  getEnv("KRATOS_ADMIN_URL", "http://kratos-admin.kratos.svc.cluster.local"),
+		OIDCIssuer:      os.Getenv("OIDC_ISSUER"),
+		OIDCAudience:    os.Getenv("OIDC_AUDIENCE"),
+		OIDCJWKSPath:    os.Getenv("OIDC_JWKS_PATH"),
+		StaticUsersPath: os.Getenv("STATIC_USERS_PATH"),
 	}
-	databaseUrl := os.Getenv("DATABASE_URL")
-	if databaseUrl == "" {
-		log.Fatal("DATABASE_URL must be set")
+	databaseConfig, err := LoadDatabaseConfig()
+	if err != nil {
+		log.Fatal(err)
 	}
-	mailPassword = os.Getenv("MAIL_PASSWORD")
-	if mailPassword == "" {
+	mailConfig := MailConfig{
+		Transport:    getEnv("MAIL_TRANSPORT", "smtp"),
+		FromEmail:    getEnv("MAIL_FROM", "calendar@prayujt.com"),
+		FromName:     getEnv("MAIL_FROM_NAME", "Prayuj Calendar"),
+		SMTPHost:     getEnv("SMTP_HOST", "mail.prayujt.com"),
+		SMTPPort:     getEnv("SMTP_PORT", "587"),
+		SMTPSecurity: getEnv("SMTP_SECURITY", "starttls"),
+		MaildirPath:  getEnv("MAILDIR_PATH", "maildir"),
+		DKIMSelector: os.Getenv("DKIM_SELECTOR"),
+		DKIMKeyPath:  os.Getenv("DKIM_PRIVATE_KEY_PATH"),
+		DKIMDomain:   os.Getenv("DKIM_DOMAIN"),
+	}
+	mailConfig.SMTPUsername = getEnv("SMTP_USERNAME", mailConfig.FromEmail)
+	mailConfig.SMTPPassword = os.Getenv("MAIL_PASSWORD")
+	if mailConfig.Transport == "smtp" && mailConfig.SMTPPassword == "" {
 		log.Fatal("MAIL_PASSWORD must be set")
 	}
+	if err := InitMailer(mailConfig); err != nil {
+		log.Fatal(err)
+	}
+
+	publicUrl = getEnv("PUBLIC_URL", "http://localhost:8080")
+	webhookSecret = os.Getenv("WEBHOOK_SECRET")
+
+	if admins := os.Getenv("ADMIN_USER_IDS"); admins != "" {
+		adminUserIds = strings.Split(admins, ",")
+	}
 
+	sessionCacheTTL, err := time.ParseDuration(getEnv("SESSION_CACHE_TTL", "1m"))
+	if err != nil {
+		log.Fatal("Invalid SESSION_CACHE_TTL: ", err)
+	}
+	sessionCacheNegativeTTL, err := time.ParseDuration(getEnv("SESSION_CACHE_NEGATIVE_TTL", "10s"))
+	if err != nil {
+		log.Fatal("Invalid SESSION_CACHE_NEGATIVE_TTL: ", err)
+	}
+	sessionCacheSize, err := strconv.Atoi(getEnv("SESSION_CACHE_SIZE", "10000"))
+	if err != nil || sessionCacheSize < 1 {
+		log.Fatal("Invalid SESSION_CACHE_SIZE")
+	}
+	sessionCache = NewSessionCache(sessionCacheTTL, sessionCacheNegativeTTL, sessionCacheSize)
+
+	// Other replicas keep serving a revoked token until their entry expires, so
+	// this stays much shorter than the session TTL
+	tokenCacheTTL, err := time.ParseDuration(getEnv("ACCESS_TOKEN_CACHE_TTL", "10s"))
+	if err != nil {
+		log.Fatal("Invalid ACCESS_TOKEN_CACHE_TTL: ", err)
+	}
+	tokenSessionCache = NewSessionCache(tokenCacheTTL, sessionCacheNegativeTTL, sessionCacheSize)
+
+	timeouts := map[string]*time.Duration{
+		"REQUEST_TIMEOUT":  &requestTimeout,
+		"DATABASE_TIMEOUT": &databaseTimeout,
+		"IDENTITY_TIMEOUT": &identityTimeout,
+		"LLM_TIMEOUT":      &llmTimeout,
+	}
+	for key, timeout := range timeouts {
+		if value := os.Getenv(key); value != "" {
+			*timeout, err = time.ParseDuration(value)
+			if err != nil || *timeout <= 0 {
+				log.Fatal("Invalid ", key)
+			}
+		}
+	}
+
+	// There is no default, so a missing variable can't turn on development mode
 	environment = os.Getenv("ENVIRONMENT")
 	if environment == "" {
-		environment = "development"
+		log.Fatal("ENVIRONMENT must be set")
 	}
 
-	InitDatabase(databaseUrl)
-	log.Println("Connected to database")
+	// Impersonation must never be reachable outside development
+	devUsersPath := os.Getenv("DEV_USERS_PATH")
+	if environment == "development" {
+		provider, err := NewDevProvider(devUsersPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		identityProvider = provider
+	} else if devUsersPath != "" {
+		log.Fatal("DEV_USERS_PATH is only allowed when ENVIRONMENT is development")
+	} else if err := InitIdentityProvider(identityConfig); err != nil {
+		log.Fatal(err)
+	}
+
+	// Stopping the process while it waits for the database or migrates cancels
+	// the wait, rather than leaving it to finish first
+	startup, stopStartup := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if err := InitDatabase(startup, databaseConfig); err != nil {
+		log.Fatal("Error connecting to database: ", err)
+	}
+	log.Printf("Connected to database with up to %d connections", pool.Config().MaxConns)
+
+	// Replicas can all migrate on start; the advisory lock lets only one at a time
+	if getEnv("MIGRATE_ON_START", "true") == "true" {
+		count, err := MigrateUp(startup)
+		if err != nil {
+			log.Fatal("Error migrating database: ", err)
+		}
+		log.Printf("Applied %d migrations", count)
+	}
+	stopStartup()
 
 	if environment == "development" {
 		log.Println("Running in development mode")
+		log.Printf("Requests act as the first development user unless %s or the %s cookie names another", devUserHeader, devUserCookie)
 	} else {
 		log.Println("Running in production mode")
-		log.Printf("Using Kratos public at: %s", kratosPublicUrl)
-		log.Printf("Using Kratos admin at: %s", kratosAdminUrl)
+		log.Printf("Using %s identity provider", identityConfig.Provider)
 	}
+	log.Printf("Sending mail through %s transport", mailConfig.Transport)
 
-	r := mux.NewRouter()
-
-	r.HandleFunc("/users", getUsers).Methods("GET")
-
-	r.HandleFunc("/events", getEvents).Methods("GET")
-	r.HandleFunc("/events/{id}", getEvent).Methods("GET")
-	r.HandleFunc("/events", createEvent).Methods("POST")
-	r.HandleFunc("/events/generate", generateEventInformation).Methods("POST")
-	r.HandleFunc("/events/{id}", updateEvent).Methods("PUT")
-	r.HandleFunc("/events/{id}", deleteEvent).Methods("DELETE")
-	r.HandleFunc("/events/{id}/share", shareEvent).Methods("POST")
-
-	r.HandleFunc("/tasks", getTasks).Methods("GET")
-	r.HandleFunc("/tasks", createTask).Methods("POST")
-	r.HandleFunc("/tasks/{id}", updateTask).Methods("PUT")
-	r.HandleFunc("/tasks/{id}", deleteTask).Methods("DELETE")
+	r := newRouter()
 
-	r.HandleFunc("/calendars", getCalendars).Methods("GET")
-	r.HandleFunc("/calendars", createCalendar).Methods("POST")
-	r.HandleFunc("/calendars/{id}", updateCalendar).Methods("PUT")
-	r.HandleFunc("/calendars/{id}", deleteCalendar).Methods("DELETE")
-
-	r.HandleFunc("/calendars/{id}/members", addCalendarMember).Methods("POST")
-	r.HandleFunc("/calendars/{id}/members/{userId}", removeCalendarMember).Methods("DELETE")
+	go RunReminderScheduler(time.Minute)
+	go RunMailWorker(10 * time.Second)
+	go RunDigestScheduler(time.Minute)
+	go RunUserSync(5 * time.Minute)
 
 	fmt.Println("Server running on 0.0.0.0:8080")
 
-	log.Println("All Users:")
-	log.Printf("%+v", GetUsers())
-
 	if environment == "development" {
 		corsMiddleware := handlers.CORS(
 			handlers.AllowedOrigins([]string{"http://localhost:5173", "http://localhost:4173"}),
 			handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
-			handlers.AllowedHeaders([]string{"Content-Type", "Authorization", "Cookie"}),
+			handlers.AllowedHeaders([]string{"Content-Type", "Authorization", "Cookie", devUserHeader}),
 			handlers.AllowCredentials(),
 		)
 		log.Fatal(http.ListenAndServe(":8080", corsMiddleware(r)))
@@ -114,49 +205,118 @@ func main() {
 	}
 }
 
-func getSession(r *http.Request) *Session {
-	if environment == "development" {
-		return &Session{
-			Active: true,
-			Identity: Identity{
-				Id:    "b849d4e4-de61-4c27-b6c6-7f2566f7079f",
-				State: "active",
-				Traits: Traits{
-					Email:     "prayuj@prayujt.com",
-					FirstName: "Prayuj",
-					LastName:  "Tuli",
-					Username:  "prayujt",
-					Avatar:    "",
-				},
-			},
-		}
-	}
+// Builds the router with every route the server handles.
+func newRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.Use(withRequestTimeout)
 
-	sessionCookie, err := r.Cookie("ory_kratos_session")
-	if err != nil {
-		return nil
-	}
-	log.Printf("Session cookie: %s", sessionCookie.Value)
+	// Public routes, linked from emails and used without a session
+	r.HandleFunc("/rsvp/{token}", respondToInvitation).Methods("GET", "POST")
+	r.HandleFunc("/unsubscribe/{token}", unsubscribe).Methods("GET", "POST")
 
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/sessions/whoami", kratosPublicUrl), nil)
-	if err != nil {
-		return nil
+	// Called by Kratos, authenticated with a shared secret instead of a session
+	r.HandleFunc("/webhooks/kratos/registration", registrationWebhook).Methods("POST")
+	r.HandleFunc("/webhooks/kratos/deletion", deletionWebhook).Methods("POST")
+
+	// Every other route requires a session
+	api := r.NewRoute().Subrouter()
+	api.Use(requireSession)
+
+	api.HandleFunc("/users", getUsers).Methods("GET")
+
+	api.HandleFunc("/events", getEvents).Methods("GET")
+	api.HandleFunc("/events/{id}", getEvent).Methods("GET")
+	api.HandleFunc("/events", createEvent).Methods("POST")
+	api.HandleFunc("/events/generate", generateEventInformation).Methods("POST")
+	api.HandleFunc("/events/{id}", updateEvent).Methods("PUT")
+	api.HandleFunc("/events/{id}", deleteEvent).Methods("DELETE")
+	api.HandleFunc("/events/{id}/share", shareEvent).Methods("POST")
+	api.HandleFunc("/events/{id}/labels", addEventLabel).Methods("POST")
+	api.HandleFunc("/events/{id}/labels/{labelId}", removeEventLabel).Methods("DELETE")
+	api.HandleFunc("/events/{id}/reminders", getEventReminders).Methods("GET")
+	api.HandleFunc("/events/{id}/reminders", createEventReminder).Methods("POST")
+
+	api.HandleFunc("/tasks", getTasks).Methods("GET")
+	api.HandleFunc("/tasks", createTask).Methods("POST")
+	api.HandleFunc("/tasks/{id}", updateTask).Methods("PUT")
+	api.HandleFunc("/tasks/{id}", deleteTask).Methods("DELETE")
+	api.HandleFunc("/tasks/{id}/labels", addTaskLabel).Methods("POST")
+	api.HandleFunc("/tasks/{id}/labels/{labelId}", removeTaskLabel).Methods("DELETE")
+	api.HandleFunc("/tasks/{id}/timer/start", startTimer).Methods("POST")
+	api.HandleFunc("/tasks/{id}/timer/stop", stopTimer).Methods("POST")
+	api.HandleFunc("/tasks/{id}/time-entries", getTimeEntries).Methods("GET")
+	api.HandleFunc("/tasks/{id}/reminders", getTaskReminders).Methods("GET")
+	api.HandleFunc("/tasks/{id}/reminders", createTaskReminder).Methods("POST")
+
+	api.HandleFunc("/timer", getRunningTimer).Methods("GET")
+	api.HandleFunc("/reports/time", getTimeReport).Methods("GET")
+	api.HandleFunc("/analytics", getAnalytics).Methods("GET")
+
+	api.HandleFunc("/calendars", getCalendars).Methods("GET")
+	api.HandleFunc("/calendars", createCalendar).Methods("POST")
+	api.HandleFunc("/calendars/{id}", updateCalendar).Methods("PUT")
+	api.HandleFunc("/calendars/{id}", deleteCalendar).Methods("DELETE")
+
+	api.HandleFunc("/calendars/{id}/members", addCalendarMember).Methods("POST")
+	api.HandleFunc("/calendars/{id}/members/{userId}", removeCalendarMember).Methods("DELETE")
+	api.HandleFunc("/calendars/{id}/reminders", getCalendarReminders).Methods("GET")
+	api.HandleFunc("/calendars/{id}/reminders", createCalendarReminder).Methods("POST")
+
+	api.HandleFunc("/reminders/{id}", deleteReminder).Methods("DELETE")
+	api.HandleFunc("/reminders/{id}/snooze", snoozeReminder).Methods("POST")
+
+	api.HandleFunc("/notifications", getNotifications).Methods("GET")
+	api.HandleFunc("/notifications/{id}/read", readNotification).Methods("POST")
+
+	api.HandleFunc("/settings", getSettings).Methods("GET")
+	api.HandleFunc("/settings", updateSettings).Methods("PUT")
+
+	api.HandleFunc("/tokens", getAccessTokens).Methods("GET")
+	api.HandleFunc("/tokens", createAccessToken).Methods("POST")
+	api.HandleFunc("/tokens/{id}", revokeAccessToken).Methods("DELETE")
+
+	api.HandleFunc("/admin/mail", getOutbox).Methods("GET")
+	api.HandleFunc("/admin/mail/{id}/retry", retryOutboxMessage).Methods("POST")
+	api.HandleFunc("/admin/metrics", getMetrics).Methods("GET")
+
+	api.HandleFunc("/labels", getLabels).Methods("GET")
+	api.HandleFunc("/labels", createLabel).Methods("POST")
+	api.HandleFunc("/labels/{id}", updateLabel).Methods("PUT")
+	api.HandleFunc("/labels/{id}", deleteLabel).Methods("DELETE")
+
+	return r
+}
+
+func getEnv(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
 	}
+	return fallback
+}
 
-	req.Header.Set("Cookie", fmt.Sprintf("ory_kratos_session=%s", sessionCookie.Value))
-	resp, err := client.Do(req)
-	if err != nil || resp.StatusCode != http.StatusOK {
-		log.Printf("Error: %v", err)
-		log.Printf("Status code: %d", resp.StatusCode)
-		return nil
+func getSession(r *http.Request) *Session {
+	// Personal access tokens work the same whichever provider is in use
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && strings.HasPrefix(token, accessTokenPrefix) {
+		session, err := tokenSessionCache.Get(r.Context(), token, fetchTokenSession)
+		if err != nil {
+			log.Printf("Error looking up access token: %v", err)
+			return nil
+		}
+		if session == nil {
+			return nil
+		}
+		recordTokenUse(r.Context(), hashToken(token))
+		return session
 	}
-	defer resp.Body.Close()
 
-	var session Session
-	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
+	session, err := identityProvider.Session(r)
+	if err != nil {
+		log.Printf("Error looking up session: %v", err)
 		return nil
 	}
+	return session
+}
 
-	return &session
+func isAdmin(session *Session) bool {
+	return slices.Contains(adminUserIds, session.Identity.Id)
 }
@@ -0,0 +1,2192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStore is a Store that keeps everything in memory, behaving like the
+// Postgres stores: the same ordering, the same ErrNotFound cases, and the same
+// cascades when a calendar, event, task, label, reminder or user is deleted.
+// Tests run the handlers against it.
+type MemoryStore struct {
+	*memoryDB
+	// Set on the Store a transaction runs with, which already holds the lock
+	inTx bool
+}
+
+type memoryDB struct {
+	mu sync.Mutex
+	// Tests replace the clock to move time forward
+	now func() time.Time
+	memoryTables
+}
+
+// Everything a MemoryStore holds, copied whole so a transaction can be rolled
+// back.
+type memoryTables struct {
+	calendars map[string]*memoryCalendar
+	events    map[string]Event
+	tasks     map[string]Task
+	labels    map[string]Label
+	// The user who created each event
+	eventOwners map[string]string
+	// Label links, which live in their own tables in Postgres
+	eventLabels map[string][]string
+	taskLabels  map[string][]string
+	reminders   map[string]Reminder
+	// Keys of the reminders and digests already delivered
+	reminderDeliveries map[string]bool
+	digestDeliveries   map[string]bool
+	// Oldest first
+	notifications []Notification
+	settings      map[string]Settings
+	timeEntries   []memoryTimeEntry
+	// Attendees by event key
+	attendees map[string][]Attendee
+	outbox    []memoryOutboxMessage
+	tokens    map[string]memoryAccessToken
+	users     map[string]User
+}
+
+type memoryCalendar struct {
+	Calendar
+	OwnerId string
+}
+
+type memoryTimeEntry struct {
+	Id        string
+	TaskId    string
+	UserId    string
+	StartedAt time.Time
+	StoppedAt *time.Time
+}
+
+type memoryOutboxMessage struct {
+	OutboxMessage
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+}
+
+type memoryAccessToken struct {
+	AccessToken
+	Hash      string
+	ExpiresAt *time.Time
+	CreatedAt time.Time
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{memoryDB: &memoryDB{
+		now: time.Now,
+		memoryTables: memoryTables{
+			calendars:          map[string]*memoryCalendar{},
+			events:             map[string]Event{},
+			tasks:              map[string]Task{},
+			labels:             map[string]Label{},
+			eventOwners:        map[string]string{},
+			eventLabels:        map[string][]string{},
+			taskLabels:         map[string][]string{},
+			reminders:          map[string]Reminder{},
+			reminderDeliveries: map[string]bool{},
+			digestDeliveries:   map[string]bool{},
+			settings:           map[string]Settings{},
+			attendees:          map[string][]Attendee{},
+			tokens:             map[string]memoryAccessToken{},
+			users:              map[string]User{},
+		},
+	}}
+}
+
+func (m *MemoryStore) Calendars() CalendarStore         { return &memoryCalendarStore{m} }
+func (m *MemoryStore) Events() EventStore               { return &memoryEventStore{m} }
+func (m *MemoryStore) Tasks() TaskStore                 { return &memoryTaskStore{m} }
+func (m *MemoryStore) Labels() LabelStore               { return &memoryLabelStore{m} }
+func (m *MemoryStore) Reminders() ReminderStore         { return &memoryReminderStore{m} }
+func (m *MemoryStore) Notifications() NotificationStore { return &memoryNotificationStore{m} }
+func (m *MemoryStore) Settings() SettingsStore          { return &memorySettingsStore{m} }
+func (m *MemoryStore) Digests() DigestStore             { return &memoryDigestStore{m} }
+func (m *MemoryStore) Timers() TimerStore               { return &memoryTimerStore{m} }
+func (m *MemoryStore) Reports() ReportStore             { return &memoryReportStore{m} }
+func (m *MemoryStore) Attendees() AttendeeStore         { return &memoryAttendeeStore{m} }
+func (m *MemoryStore) Outbox() OutboxStore              { return &memoryOutboxStore{m} }
+func (m *MemoryStore) Tokens() TokenStore               { return &memoryTokenStore{m} }
+func (m *MemoryStore) Users() UserStore                 { return &memoryUserStore{m} }
+
+// Transactions hold the lock from start to finish, so they run one at a time
+// and nothing else sees their writes until they are done. Calling the store
+// the transaction started on from inside fn would wait on that lock forever,
+// just as it would wait on the transaction's row locks in Postgres.
+func (m *MemoryStore) Transaction(ctx context.Context, fn func(tx Store) error) error {
+	if m.inTx {
+		return fn(m)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := m.memoryTables.clone()
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			m.memoryTables = snapshot
+			panic(recovered)
+		}
+	}()
+
+	if err := fn(&MemoryStore{memoryDB: m.memoryDB, inTx: true}); err != nil {
+		m.memoryTables = snapshot
+		return err
+	}
+	return nil
+}
+
+func (m *MemoryStore) lock() {
+	if !m.inTx {
+		m.mu.Lock()
+	}
+}
+
+func (m *MemoryStore) unlock() {
+	if !m.inTx {
+		m.mu.Unlock()
+	}
+}
+
+func cloneLinks[T any](links map[string][]T) map[string][]T {
+	cloned := make(map[string][]T, len(links))
+	for key, values := range links {
+		cloned[key] = slices.Clone(values)
+	}
+	return cloned
+}
+
+func (t memoryTables) clone() memoryTables {
+	calendars := make(map[string]*memoryCalendar, len(t.calendars))
+	for id, calendar := range t.calendars {
+		cloned := *calendar
+		cloned.Members = slices.Clone(calendar.Members)
+		calendars[id] = &cloned
+	}
+
+	return memoryTables{
+		calendars:          calendars,
+		events:             maps.Clone(t.events),
+		tasks:              maps.Clone(t.tasks),
+		labels:             maps.Clone(t.labels),
+		eventOwners:        maps.Clone(t.eventOwners),
+		eventLabels:        cloneLinks(t.eventLabels),
+		taskLabels:         cloneLinks(t.taskLabels),
+		reminders:          maps.Clone(t.reminders),
+		reminderDeliveries: maps.Clone(t.reminderDeliveries),
+		digestDeliveries:   maps.Clone(t.digestDeliveries),
+		notifications:      slices.Clone(t.notifications),
+		settings:           maps.Clone(t.settings),
+		timeEntries:        slices.Clone(t.timeEntries),
+		attendees:          cloneLinks(t.attendees),
+		outbox:             slices.Clone(t.outbox),
+		tokens:             maps.Clone(t.tokens),
+		users:              maps.Clone(t.users),
+	}
+}
+
+// The deletes below must be called with the lock held, and cascade the way
+// the foreign keys do in Postgres.
+func (t *memoryTables) deleteCalendar(id string) {
+	delete(t.calendars, id)
+	for eventId, event := range t.events {
+		if event.CalendarId == id {
+			t.deleteEvent(eventId)
+		}
+	}
+	for reminderId, reminder := range t.reminders {
+		if reminder.CalendarId != nil && *reminder.CalendarId == id {
+			t.deleteReminder(reminderId)
+		}
+	}
+}
+
+func (t *memoryTables) deleteEvent(id string) {
+	delete(t.events, id)
+	delete(t.eventOwners, id)
+	delete(t.eventLabels, id)
+	for reminderId, reminder := range t.reminders {
+		if reminder.EventId != nil && *reminder.EventId == id {
+			t.deleteReminder(reminderId)
+		}
+	}
+}
+
+func (t *memoryTables) deleteTask(id string) {
+	delete(t.tasks, id)
+	delete(t.taskLabels, id)
+	t.timeEntries = slices.DeleteFunc(t.timeEntries, func(entry memoryTimeEntry) bool {
+		return entry.TaskId == id
+	})
+	for reminderId, reminder := range t.reminders {
+		if reminder.TaskId != nil && *reminder.TaskId == id {
+			t.deleteReminder(reminderId)
+		}
+	}
+}
+
+func (t *memoryTables) deleteLabel(id string) {
+	delete(t.labels, id)
+	for _, links := range []map[string][]string{t.eventLabels, t.taskLabels} {
+		for targetId, labelIds := range links {
+			links[targetId] = slices.DeleteFunc(labelIds, func(labelId string) bool { return labelId == id })
+		}
+	}
+}
+
+func (t *memoryTables) deleteReminder(id string) {
+	delete(t.reminders, id)
+	for key := range t.reminderDeliveries {
+		if strings.HasPrefix(key, id+"|") {
+			delete(t.reminderDeliveries, key)
+		}
+	}
+}
+
+// Must be called with the lock held.
+func (t *memoryTables) member(userId string, calendarId string) bool {
+	calendar, ok := t.calendars[calendarId]
+	return ok && slices.Contains(calendar.Members, userId)
+}
+
+// Copies the event so callers can't change what is stored through its pointers.
+func copyEvent(event Event) Event {
+	if event.Description != nil {
+		description := *event.Description
+		event.Description = &description
+	}
+	if event.Location != nil {
+		location := *event.Location
+		event.Location = &location
+	}
+	event.Labels = nil
+	event.Reminders = nil
+	event.Attendees = nil
+	event.Occurrence = ""
+	event.ThisAndFuture = false
+	return event
+}
+
+func copyTask(task Task) Task {
+	if task.Description != nil {
+		description := *task.Description
+		task.Description = &description
+	}
+	task.Labels = nil
+	task.ActualDuration = 0
+	task.DurationVariance = 0
+	return task
+}
+
+// Parses a timestamp the way Postgres would accept it and formats it the way
+// the Postgres stores return it.
+func normalizeDate(date string) (string, time.Time, error) {
+	parsed, err := time.Parse(time.RFC3339, date)
+	if err != nil {
+		return "", parsed, fmt.Errorf("invalid timestamp %q", date)
+	}
+	return parsed.UTC().Format(dateFormat), parsed, nil
+}
+
+// Dates are normalized when stored, so they can be parsed without checking.
+func eventTime(event Event) time.Time {
+	date, _ := time.Parse(dateFormat, event.Date)
+	return date
+}
+
+func originalTime(event Event) time.Time {
+	date, _ := time.Parse(dateFormat, occurrenceDate(event))
+	return date
+}
+
+type memoryCalendarStore struct {
+	*MemoryStore
+}
+
+func (s *memoryCalendarStore) ListForUser(ctx context.Context, userId string) ([]Calendar, error) {
+	s.lock()
+	defer s.unlock()
+
+	calendars := []Calendar{}
+	for _, calendar := range s.calendars {
+		if slices.Contains(calendar.Members, userId) {
+			listed := calendar.Calendar
+			listed.Members = slices.Clone(calendar.Members)
+			calendars = append(calendars, listed)
+		}
+	}
+	sort.Slice(calendars, func(i, j int) bool {
+		if calendars[i].IsDefault != calendars[j].IsDefault {
+			return calendars[i].IsDefault
+		}
+		return calendars[i].Name < calendars[j].Name
+	})
+	return calendars, nil
+}
+
+func (s *memoryCalendarStore) IsMember(ctx context.Context, calendarId string, userId string) (bool, error) {
+	s.lock()
+	defer s.unlock()
+
+	calendar, ok := s.calendars[calendarId]
+	return ok && slices.Contains(calendar.Members, userId), nil
+}
+
+func (s *memoryCalendarStore) HasDefault(ctx context.Context, userId string) (bool, error) {
+	s.lock()
+	defer s.unlock()
+
+	for _, calendar := range s.calendars {
+		if calendar.IsDefault && slices.Contains(calendar.Members, userId) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memoryCalendarStore) Create(ctx context.Context, calendar Calendar, ownerId string) error {
+	s.lock()
+	defer s.unlock()
+
+	if _, ok := s.calendars[calendar.Id]; ok {
+		return fmt.Errorf("calendar %s already exists", calendar.Id)
+	}
+	calendar.Members = []string{ownerId}
+	s.calendars[calendar.Id] = &memoryCalendar{Calendar: calendar, OwnerId: ownerId}
+	return nil
+}
+
+func (s *memoryCalendarStore) Update(ctx context.Context, calendar Calendar, ownerId string) error {
+	s.lock()
+	defer s.unlock()
+
+	stored, ok := s.calendars[calendar.Id]
+	if !ok || stored.OwnerId != ownerId {
+		return ErrNotFound
+	}
+	stored.Name = calendar.Name
+	stored.Color = calendar.Color
+	return nil
+}
+
+func (s *memoryCalendarStore) Delete(ctx context.Context, id string, ownerId string) error {
+	s.lock()
+	defer s.unlock()
+
+	if calendar, ok := s.calendars[id]; !ok || calendar.OwnerId != ownerId {
+		return ErrNotFound
+	}
+	s.deleteCalendar(id)
+	return nil
+}
+
+func (s *memoryCalendarStore) AddMember(ctx context.Context, calendarId string, userId string, ownerId string) error {
+	s.lock()
+	defer s.unlock()
+
+	calendar, ok := s.calendars[calendarId]
+	if !ok || calendar.OwnerId != ownerId {
+		return ErrNotFound
+	}
+	if slices.Contains(calendar.Members, userId) {
+		return fmt.Errorf("%s is already a member of calendar %s", userId, calendarId)
+	}
+	calendar.Members = append(calendar.Members, userId)
+	return nil
+}
+
+func (s *memoryCalendarStore) RemoveMember(ctx context.Context, calendarId string, userId string, ownerId string) error {
+	s.lock()
+	defer s.unlock()
+
+	calendar, ok := s.calendars[calendarId]
+	if !ok || calendar.OwnerId != ownerId {
+		return ErrNotFound
+	}
+	idx := slices.Index(calendar.Members, userId)
+	if idx == -1 {
+		return ErrNotFound
+	}
+	calendar.Members = slices.Delete(calendar.Members, idx, idx+1)
+	return nil
+}
+
+type memoryEventStore struct {
+	*MemoryStore
+}
+
+// Must be called with the lock held. Returns the matching events, earliest first.
+func (s *memoryEventStore) filter(match func(Event) bool) []Event {
+	events := []Event{}
+	for _, event := range s.events {
+		if match(event) {
+			events = append(events, copyEvent(event))
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return eventTime(events[i]).Before(eventTime(events[j]))
+	})
+	return events
+}
+
+func (s *memoryEventStore) ListForUser(ctx context.Context, userId string, labelId string) ([]Event, error) {
+	s.lock()
+	defer s.unlock()
+
+	return s.filter(func(event Event) bool {
+		if labelId != "" && !slices.Contains(s.eventLabels[event.Id], labelId) {
+			return false
+		}
+		return s.member(userId, event.CalendarId)
+	}), nil
+}
+
+func (s *memoryEventStore) ListBetween(ctx context.Context, userId string, start time.Time, end time.Time) ([]Event, error) {
+	s.lock()
+	defer s.unlock()
+
+	return s.filter(func(event Event) bool {
+		date := eventTime(event)
+		return s.member(userId, event.CalendarId) && !date.Before(start) && date.Before(end)
+	}), nil
+}
+
+func (s *memoryEventStore) Get(ctx context.Context, id string) (Event, error) {
+	s.lock()
+	defer s.unlock()
+
+	event, ok := s.events[id]
+	if !ok {
+		return Event{}, ErrNotFound
+	}
+	return copyEvent(event), nil
+}
+
+func (s *memoryEventStore) GetOwned(ctx context.Context, id string, userId string) (Event, error) {
+	s.lock()
+	defer s.unlock()
+
+	event, ok := s.events[id]
+	if !ok || s.eventOwners[id] != userId {
+		return Event{}, ErrNotFound
+	}
+	return copyEvent(event), nil
+}
+
+func (s *memoryEventStore) CanAccess(ctx context.Context, eventId string, userId string) (bool, error) {
+	s.lock()
+	defer s.unlock()
+
+	event, ok := s.events[eventId]
+	return ok && s.member(userId, event.CalendarId), nil
+}
+
+// Like the Postgres store, inserts nothing if any event is invalid.
+func (s *memoryEventStore) Create(ctx context.Context, userId string, events ...Event) error {
+	s.lock()
+	defer s.unlock()
+
+	created := make([]Event, len(events))
+	for idx, event := range events {
+		if _, ok := s.events[event.Id]; ok || slices.ContainsFunc(created[:idx], func(other Event) bool { return other.Id == event.Id }) {
+			return fmt.Errorf("event %s already exists", event.Id)
+		}
+		if _, ok := s.calendars[event.CalendarId]; !ok {
+			return fmt.Errorf("calendar %s does not exist", event.CalendarId)
+		}
+		date, _, err := normalizeDate(event.Date)
+		if err != nil {
+			return err
+		}
+
+		event = copyEvent(event)
+		event.Date = date
+		event.OriginalDate = ""
+		created[idx] = event
+	}
+
+	for _, event := range created {
+		s.events[event.Id] = event
+		s.eventOwners[event.Id] = userId
+	}
+	return nil
+}
+
+func (s *memoryEventStore) NextSequence(ctx context.Context, eventId string) (int, error) {
+	s.lock()
+	defer s.unlock()
+
+	event, ok := s.events[eventId]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	sequence := event.Sequence
+	if event.RecurrenceId != "" {
+		for _, other := range s.events {
+			if other.RecurrenceId == event.RecurrenceId {
+				sequence = max(sequence, other.Sequence)
+			}
+		}
+	}
+	return sequence + 1, nil
+}
+
+func (s *memoryEventStore) Update(ctx context.Context, event Event) error {
+	s.lock()
+	defer s.unlock()
+
+	stored, ok := s.events[event.Id]
+	if !ok {
+		return ErrNotFound
+	}
+	date, _, err := normalizeDate(event.Date)
+	if err != nil {
+		return err
+	}
+
+	updated := copyEvent(event)
+	stored.Title = updated.Title
+	stored.CalendarId = updated.CalendarId
+	stored.Description = updated.Description
+	stored.Duration = updated.Duration
+	stored.Location = updated.Location
+	stored.Sequence = updated.Sequence
+	if stored.RecurrenceId != "" && stored.OriginalDate == "" {
+		stored.OriginalDate = stored.Date
+	}
+	stored.Date = date
+	s.events[event.Id] = stored
+	return nil
+}
+
+func (s *memoryEventStore) UpdateFollowing(ctx context.Context, old Event, event Event) error {
+	s.lock()
+	defer s.unlock()
+
+	_, oldDate, err := normalizeDate(old.Date)
+	if err != nil {
+		return err
+	}
+	_, from, err := normalizeDate(occurrenceDate(old))
+	if err != nil {
+		return err
+	}
+	_, newDate, err := normalizeDate(event.Date)
+	if err != nil {
+		return err
+	}
+	shift := newDate.Sub(oldDate)
+
+	updated := 0
+	for id, stored := range s.events {
+		if stored.RecurrenceId != old.RecurrenceId || originalTime(stored).Before(from) {
+			continue
+		}
+		changes := copyEvent(event)
+		stored.Title = changes.Title
+		stored.CalendarId = changes.CalendarId
+		stored.Description = changes.Description
+		stored.Duration = changes.Duration
+		stored.Date = eventTime(stored).Add(shift).UTC().Format(dateFormat)
+		if stored.OriginalDate != "" {
+			stored.OriginalDate = originalTime(stored).Add(shift).UTC().Format(dateFormat)
+		}
+		stored.Location = changes.Location
+		stored.Sequence = changes.Sequence
+		s.events[id] = stored
+		updated++
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *memoryEventStore) Delete(ctx context.Context, id string) error {
+	s.lock()
+	defer s.unlock()
+
+	if _, ok := s.events[id]; !ok {
+		return ErrNotFound
+	}
+	s.deleteEvent(id)
+	return nil
+}
+
+func (s *memoryEventStore) DeleteFollowing(ctx context.Context, recurrenceId string, from string) error {
+	s.lock()
+	defer s.unlock()
+
+	_, fromDate, err := normalizeDate(from)
+	if err != nil {
+		return err
+	}
+
+	deleted := 0
+	for id, event := range s.events {
+		if event.RecurrenceId == recurrenceId && !originalTime(event).Before(fromDate) {
+			s.deleteEvent(id)
+			deleted++
+		}
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type memoryTaskStore struct {
+	*MemoryStore
+}
+
+// Must be called with the lock held. Returns the matching tasks unfinished
+// first, then soonest due, with tasks without a deadline last like Postgres
+// sorts NULLs.
+func (s *memoryTaskStore) filter(match func(Task) bool) []Task {
+	tasks := []Task{}
+	for _, task := range s.tasks {
+		if match(task) {
+			tasks = append(tasks, copyTask(task))
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		if tasks[i].Completed != tasks[j].Completed {
+			return !tasks[i].Completed
+		}
+		if (tasks[i].Deadline == "") != (tasks[j].Deadline == "") {
+			return tasks[j].Deadline == ""
+		}
+		iDeadline, _ := time.Parse(dateFormat, tasks[i].Deadline)
+		jDeadline, _ := time.Parse(dateFormat, tasks[j].Deadline)
+		return iDeadline.Before(jDeadline)
+	})
+	return tasks
+}
+
+// Returns the task with its deadline normalized, or an error if it can't be
+// stored.
+func storedTask(task Task) (Task, error) {
+	task = copyTask(task)
+	if task.Deadline != "" {
+		deadline, _, err := normalizeDate(task.Deadline)
+		if err != nil {
+			return task, err
+		}
+		task.Deadline = deadline
+	}
+	return task, nil
+}
+
+func (s *memoryTaskStore) ListForUser(ctx context.Context, userId string, labelId string) ([]Task, error) {
+	s.lock()
+	defer s.unlock()
+
+	return s.filter(func(task Task) bool {
+		if labelId != "" && !slices.Contains(s.taskLabels[task.Id], labelId) {
+			return false
+		}
+		return task.UserId == userId
+	}), nil
+}
+
+func (s *memoryTaskStore) ListDue(ctx context.Context, userId string, before time.Time) ([]Task, error) {
+	s.lock()
+	defer s.unlock()
+
+	return s.filter(func(task Task) bool {
+		if task.UserId != userId || task.Completed || task.Deadline == "" {
+			return false
+		}
+		deadline, _ := time.Parse(dateFormat, task.Deadline)
+		return deadline.Before(before)
+	}), nil
+}
+
+func (s *memoryTaskStore) IsOwner(ctx context.Context, taskId string, userId string) (bool, error) {
+	s.lock()
+	defer s.unlock()
+
+	task, ok := s.tasks[taskId]
+	return ok && task.UserId == userId, nil
+}
+
+func (s *memoryTaskStore) Create(ctx context.Context, task Task) error {
+	s.lock()
+	defer s.unlock()
+
+	if _, ok := s.tasks[task.Id]; ok {
+		return fmt.Errorf("task %s already exists", task.Id)
+	}
+	task, err := storedTask(task)
+	if err != nil {
+		return err
+	}
+	s.tasks[task.Id] = task
+	return nil
+}
+
+func (s *memoryTaskStore) Update(ctx context.Context, task Task) error {
+	s.lock()
+	defer s.unlock()
+
+	stored, ok := s.tasks[task.Id]
+	if !ok || stored.UserId != task.UserId {
+		return ErrNotFound
+	}
+	task, err := storedTask(task)
+	if err != nil {
+		return err
+	}
+	s.tasks[task.Id] = task
+	return nil
+}
+
+func (s *memoryTaskStore) Delete(ctx context.Context, taskId string, userId string) error {
+	s.lock()
+	defer s.unlock()
+
+	task, ok := s.tasks[taskId]
+	if !ok || task.UserId != userId {
+		return ErrNotFound
+	}
+	s.deleteTask(taskId)
+	return nil
+}
+
+type memoryLabelStore struct {
+	*MemoryStore
+}
+
+// Must be called with the lock held. Label names are unique per user.
+func (s *memoryLabelStore) checkName(label Label) error {
+	for _, other := range s.labels {
+		if other.Id != label.Id && other.UserId == label.UserId && other.Name == label.Name {
+			return ErrConflict
+		}
+	}
+	return nil
+}
+
+func (s *memoryLabelStore) ListForUser(ctx context.Context, userId string) ([]Label, error) {
+	s.lock()
+	defer s.unlock()
+
+	labels := []Label{}
+	for _, label := range s.labels {
+		if label.UserId == userId {
+			labels = append(labels, label)
+		}
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].Name < labels[j].Name
+	})
+	return labels, nil
+}
+
+func (s *memoryLabelStore) IsOwner(ctx context.Context, labelId string, userId string) (bool, error) {
+	s.lock()
+	defer s.unlock()
+
+	label, ok := s.labels[labelId]
+	return ok && label.UserId == userId, nil
+}
+
+func (s *memoryLabelStore) Create(ctx context.Context, label Label) error {
+	s.lock()
+	defer s.unlock()
+
+	if _, ok := s.labels[label.Id]; ok {
+		return fmt.Errorf("label %s already exists", label.Id)
+	}
+	if err := s.checkName(label); err != nil {
+		return err
+	}
+	s.labels[label.Id] = label
+	return nil
+}
+
+func (s *memoryLabelStore) Update(ctx context.Context, label Label) error {
+	s.lock()
+	defer s.unlock()
+
+	stored, ok := s.labels[label.Id]
+	if !ok || stored.UserId != label.UserId {
+		return ErrNotFound
+	}
+	if err := s.checkName(label); err != nil {
+		return err
+	}
+	stored.Name = label.Name
+	stored.Color = label.Color
+	s.labels[label.Id] = stored
+	return nil
+}
+
+func (s *memoryLabelStore) Delete(ctx context.Context, labelId string, userId string) error {
+	s.lock()
+	defer s.unlock()
+
+	label, ok := s.labels[labelId]
+	if !ok || label.UserId != userId {
+		return ErrNotFound
+	}
+	s.deleteLabel(labelId)
+	return nil
+}
+
+// Must be called with the lock held.
+func (s *memoryLabelStore) links(links map[string][]string, userId string) map[string][]string {
+	owned := make(map[string][]string)
+	for targetId, labelIds := range links {
+		for _, labelId := range labelIds {
+			if s.labels[labelId].UserId == userId {
+				owned[targetId] = append(owned[targetId], labelId)
+			}
+		}
+	}
+	return owned
+}
+
+func (s *memoryLabelStore) TaskLabels(ctx context.Context, userId string) (map[string][]string, error) {
+	s.lock()
+	defer s.unlock()
+
+	return s.links(s.taskLabels, userId), nil
+}
+
+func (s *memoryLabelStore) EventLabels(ctx context.Context, userId string) (map[string][]string, error) {
+	s.lock()
+	defer s.unlock()
+
+	return s.links(s.eventLabels, userId), nil
+}
+
+// Must be called with the lock held.
+func (s *memoryLabelStore) link(links map[string][]string, targetId string, labelId string) error {
+	if _, ok := s.labels[labelId]; !ok {
+		return fmt.Errorf("label %s does not exist", labelId)
+	}
+	if !slices.Contains(links[targetId], labelId) {
+		links[targetId] = append(links[targetId], labelId)
+	}
+	return nil
+}
+
+// Must be called with the lock held.
+func (s *memoryLabelStore) unlink(links map[string][]string, targetId string, labelId string) {
+	links[targetId] = slices.DeleteFunc(links[targetId], func(linked string) bool { return linked == labelId })
+	if len(links[targetId]) == 0 {
+		delete(links, targetId)
+	}
+}
+
+func (s *memoryLabelStore) AddToTask(ctx context.Context, taskId string, labelId string) error {
+	s.lock()
+	defer s.unlock()
+
+	if _, ok := s.tasks[taskId]; !ok {
+		return fmt.Errorf("task %s does not exist", taskId)
+	}
+	return s.link(s.taskLabels, taskId, labelId)
+}
+
+func (s *memoryLabelStore) RemoveFromTask(ctx context.Context, taskId string, labelId string) error {
+	s.lock()
+	defer s.unlock()
+
+	s.unlink(s.taskLabels, taskId, labelId)
+	return nil
+}
+
+func (s *memoryLabelStore) AddToEvent(ctx context.Context, eventId string, labelId string) error {
+	s.lock()
+	defer s.unlock()
+
+	if _, ok := s.events[eventId]; !ok {
+		return fmt.Errorf("event %s does not exist", eventId)
+	}
+	return s.link(s.eventLabels, eventId, labelId)
+}
+
+func (s *memoryLabelStore) RemoveFromEvent(ctx context.Context, eventId string, labelId string) error {
+	s.lock()
+	defer s.unlock()
+
+	s.unlink(s.eventLabels, eventId, labelId)
+	return nil
+}
+
+type memoryReminderStore struct {
+	*MemoryStore
+}
+
+func copyString(value *string) *string {
+	if value == nil {
+		return nil
+	}
+	copied := *value
+	return &copied
+}
+
+func copyReminder(reminder Reminder) Reminder {
+	reminder.EventId = copyString(reminder.EventId)
+	reminder.RecurrenceId = copyString(reminder.RecurrenceId)
+	reminder.CalendarId = copyString(reminder.CalendarId)
+	reminder.TaskId = copyString(reminder.TaskId)
+	reminder.SnoozedUntil = copyString(reminder.SnoozedUntil)
+	return reminder
+}
+
+func deliveryKey(parts ...string) string {
+	return strings.Join(parts, "|")
+}
+
+// Must be called with the lock held. Checks what the constraints on the
+// reminders table do.
+func (s *memoryReminderStore) check(reminder Reminder) error {
+	targets := 0
+	for _, target := range []*string{reminder.EventId, reminder.RecurrenceId, reminder.CalendarId, reminder.TaskId} {
+		if target != nil {
+			targets++
+		}
+	}
+	if targets != 1 {
+		return fmt.Errorf("reminder %s needs exactly one target", reminder.Id)
+	}
+	if reminder.Overdue && reminder.TaskId == nil {
+		return fmt.Errorf("reminder %s is overdue but not on a task", reminder.Id)
+	}
+	if !validReminder(reminder) {
+		return fmt.Errorf("reminder %s is invalid", reminder.Id)
+	}
+	if _, ok := s.reminders[reminder.Id]; ok {
+		return fmt.Errorf("reminder %s already exists", reminder.Id)
+	}
+
+	if reminder.EventId != nil {
+		if _, ok := s.events[*reminder.EventId]; !ok {
+			return fmt.Errorf("event %s does not exist", *reminder.EventId)
+		}
+	}
+	if reminder.CalendarId != nil {
+		if _, ok := s.calendars[*reminder.CalendarId]; !ok {
+			return fmt.Errorf("calendar %s does not exist", *reminder.CalendarId)
+		}
+	}
+	if reminder.TaskId != nil {
+		if _, ok := s.tasks[*reminder.TaskId]; !ok {
+			return fmt.Errorf("task %s does not exist", *reminder.TaskId)
+		}
+	}
+	return nil
+}
+
+// Must be called with the lock held. Returns the matching reminders sorted by
+// less, then by id.
+func (s *memoryReminderStore) filter(match func(Reminder) bool, less func(a Reminder, b Reminder) bool) []Reminder {
+	reminders := []Reminder{}
+	for _, reminder := range s.reminders {
+		if match(reminder) {
+			reminders = append(reminders, copyReminder(reminder))
+		}
+	}
+	sort.Slice(reminders, func(i, j int) bool {
+		if less(reminders[i], reminders[j]) || less(reminders[j], reminders[i]) {
+			return less(reminders[i], reminders[j])
+		}
+		return reminders[i].Id < reminders[j].Id
+	})
+	return reminders
+}
+
+func longestNoticeFirst(a Reminder, b Reminder) bool {
+	return a.MinutesBefore > b.MinutesBefore
+}
+
+func (s *memoryReminderStore) ListForEvent(ctx context.Context, userId string, event Event) ([]Reminder, error) {
+	s.lock()
+	defer s.unlock()
+
+	return s.filter(func(reminder Reminder) bool {
+		if reminder.UserId != userId {
+			return false
+		}
+		return (reminder.EventId != nil && *reminder.EventId == event.Id) ||
+			(reminder.RecurrenceId != nil && *reminder.RecurrenceId == event.RecurrenceId)
+	}, longestNoticeFirst), nil
+}
+
+func (s *memoryReminderStore) ListForCalendar(ctx context.Context, userId string, calendarId string) ([]Reminder, error) {
+	s.lock()
+	defer s.unlock()
+
+	return s.filter(func(reminder Reminder) bool {
+		return reminder.UserId == userId && reminder.CalendarId != nil && *reminder.CalendarId == calendarId
+	}, longestNoticeFirst), nil
+}
+
+func (s *memoryReminderStore) ListForTask(ctx context.Context, userId string, taskId string) ([]Reminder, error) {
+	s.lock()
+	defer s.unlock()
+
+	return s.filter(func(reminder Reminder) bool {
+		return reminder.UserId == userId && reminder.TaskId != nil && *reminder.TaskId == taskId
+	}, func(a Reminder, b Reminder) bool {
+		if a.Overdue != b.Overdue {
+			return !a.Overdue
+		}
+		return longestNoticeFirst(a, b)
+	}), nil
+}
+
+// Like the Postgres store, inserts nothing if any reminder is invalid.
+func (s *memoryReminderStore) Create(ctx context.Context, reminders ...Reminder) error {
+	s.lock()
+	defer s.unlock()
+
+	for idx, reminder := range reminders {
+		if err := s.check(reminder); err != nil {
+			return err
+		}
+		if slices.ContainsFunc(reminders[:idx], func(other Reminder) bool { return other.Id == reminder.Id }) {
+			return fmt.Errorf("reminder %s already exists", reminder.Id)
+		}
+	}
+
+	for _, reminder := range reminders {
+		reminder = copyReminder(reminder)
+		reminder.SnoozedUntil = nil
+		s.reminders[reminder.Id] = reminder
+	}
+	return nil
+}
+
+func (s *memoryReminderStore) Delete(ctx context.Context, id string, userId string) error {
+	s.lock()
+	defer s.unlock()
+
+	reminder, ok := s.reminders[id]
+	if !ok || reminder.UserId != userId {
+		return ErrNotFound
+	}
+	s.deleteReminder(id)
+	return nil
+}
+
+func (s *memoryReminderStore) Snooze(ctx context.Context, id string, userId string, minutes int) (Reminder, error) {
+	s.lock()
+	defer s.unlock()
+
+	reminder, ok := s.reminders[id]
+	if !ok || reminder.UserId != userId || reminder.TaskId == nil {
+		return Reminder{}, ErrNotFound
+	}
+	snoozedUntil := timestamp(s.now().Add(time.Duration(minutes) * time.Minute))
+	reminder.SnoozedUntil = &snoozedUntil
+	s.reminders[id] = reminder
+	return copyReminder(reminder), nil
+}
+
+func (s *memoryReminderStore) ClearSnoozes(ctx context.Context, taskId string, userId string) error {
+	s.lock()
+	defer s.unlock()
+
+	for id, reminder := range s.reminders {
+		if reminder.UserId == userId && reminder.TaskId != nil && *reminder.TaskId == taskId {
+			reminder.SnoozedUntil = nil
+			s.reminders[id] = reminder
+		}
+	}
+	return nil
+}
+
+// Must be called with the lock held. Reports whether the reminder is for the
+// event, with calendar defaults giving way to reminders set on the event or
+// its series.
+func (s *memoryReminderStore) remindsOf(reminder Reminder, event Event) bool {
+	switch {
+	case reminder.EventId != nil:
+		return *reminder.EventId == event.Id
+	case reminder.RecurrenceId != nil:
+		return *reminder.RecurrenceId == event.RecurrenceId
+	case reminder.CalendarId != nil:
+		if *reminder.CalendarId != event.CalendarId {
+			return false
+		}
+		for _, override := range s.reminders {
+			if override.UserId != reminder.UserId {
+				continue
+			}
+			if (override.EventId != nil && *override.EventId == event.Id) ||
+				(override.RecurrenceId != nil && *override.RecurrenceId == event.RecurrenceId) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// Must be called with the lock held.
+func (s *memoryReminderStore) delivered(reminder dueReminder) bool {
+	return s.reminderDeliveries[deliveryKey(reminder.ReminderId, reminder.TargetId, timestamp(reminder.FireAt))]
+}
+
+func sortDue(due []dueReminder) []dueReminder {
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].FireAt.Equal(due[j].FireAt) {
+			return due[i].FireAt.Before(due[j].FireAt)
+		}
+		return due[i].ReminderId+due[i].TargetId < due[j].ReminderId+due[j].TargetId
+	})
+	return due
+}
+
+func (s *memoryReminderStore) DueForEvents(ctx context.Context) ([]dueReminder, error) {
+	s.lock()
+	defer s.unlock()
+
+	now := s.now()
+	grace := time.Duration(reminderGracePeriod) * time.Minute
+	due := []dueReminder{}
+	for _, reminder := range s.reminders {
+		for _, event := range s.events {
+			if !s.remindsOf(reminder, event) || !s.member(reminder.UserId, event.CalendarId) {
+				continue
+			}
+			date := eventTime(event)
+			fireAt := date.Add(-time.Duration(reminder.MinutesBefore) * time.Minute)
+			if fireAt.After(now) || !date.After(now.Add(-grace)) {
+				continue
+			}
+
+			candidate := dueReminder{
+				ReminderId: reminder.Id,
+				TargetId:   event.Id,
+				UserId:     reminder.UserId,
+				Method:     reminder.Method,
+				Title:      event.Title,
+				Date:       date,
+				FireAt:     fireAt,
+			}
+			if !s.delivered(candidate) {
+				due = append(due, candidate)
+			}
+		}
+	}
+	return sortDue(due), nil
+}
+
+func (s *memoryReminderStore) DueForTasks(ctx context.Context) ([]dueReminder, error) {
+	s.lock()
+	defer s.unlock()
+
+	now := s.now()
+	grace := time.Duration(reminderGracePeriod) * time.Minute
+	due := []dueReminder{}
+	for _, reminder := range s.reminders {
+		if reminder.TaskId == nil {
+			continue
+		}
+		task, ok := s.tasks[*reminder.TaskId]
+		if !ok || task.UserId != reminder.UserId || task.Completed || task.Deadline == "" {
+			continue
+		}
+
+		deadline, _ := time.Parse(dateFormat, task.Deadline)
+		fireAt := deadline.Add(-time.Duration(reminder.MinutesBefore) * time.Minute)
+		snoozed := reminder.SnoozedUntil != nil
+		if snoozed {
+			fireAt, _ = time.Parse(time.RFC3339Nano, *reminder.SnoozedUntil)
+		}
+		if fireAt.After(now) || !(reminder.Overdue || snoozed || deadline.After(now.Add(-grace))) {
+			continue
+		}
+
+		candidate := dueReminder{
+			ReminderId: reminder.Id,
+			TargetId:   task.Id,
+			UserId:     reminder.UserId,
+			Method:     reminder.Method,
+			Overdue:    reminder.Overdue,
+			Title:      task.Title,
+			Date:       deadline,
+			FireAt:     fireAt,
+			IsTask:     true,
+		}
+		if !s.delivered(candidate) {
+			due = append(due, candidate)
+		}
+	}
+	return sortDue(due), nil
+}
+
+func (s *memoryReminderStore) Claim(ctx context.Context, reminder dueReminder) (bool, error) {
+	s.lock()
+	defer s.unlock()
+
+	if _, ok := s.reminders[reminder.ReminderId]; !ok {
+		return false, fmt.Errorf("reminder %s does not exist", reminder.ReminderId)
+	}
+	if s.delivered(reminder) {
+		return false, nil
+	}
+	s.reminderDeliveries[deliveryKey(reminder.ReminderId, reminder.TargetId, timestamp(reminder.FireAt))] = true
+	return true, nil
+}
+
+type memoryNotificationStore struct {
+	*MemoryStore
+}
+
+func copyNotification(notification Notification) Notification {
+	notification.EventId = copyString(notification.EventId)
+	notification.TaskId = copyString(notification.TaskId)
+	notification.ReminderId = copyString(notification.ReminderId)
+	notification.ReadAt = copyString(notification.ReadAt)
+	return notification
+}
+
+func (s *memoryNotificationStore) Create(ctx context.Context, notification Notification) error {
+	s.lock()
+	defer s.unlock()
+
+	notification = copyNotification(notification)
+	notification.ReadAt = nil
+	notification.CreatedAt = timestamp(s.now())
+	s.notifications = append(s.notifications, notification)
+	return nil
+}
+
+func (s *memoryNotificationStore) ListForUser(ctx context.Context, userId string, unreadOnly bool) ([]Notification, error) {
+	s.lock()
+	defer s.unlock()
+
+	notifications := []Notification{}
+	for idx := len(s.notifications) - 1; idx >= 0 && len(notifications) < 100; idx-- {
+		notification := s.notifications[idx]
+		if notification.UserId == userId && (!unreadOnly || notification.ReadAt == nil) {
+			notifications = append(notifications, copyNotification(notification))
+		}
+	}
+	return notifications, nil
+}
+
+// Must be called with the lock held.
+func (s *memoryNotificationStore) markRead(match func(Notification) bool) {
+	readAt := timestamp(s.now())
+	for idx, notification := range s.notifications {
+		if notification.ReadAt == nil && match(notification) {
+			s.notifications[idx].ReadAt = &readAt
+		}
+	}
+}
+
+func (s *memoryNotificationStore) MarkRead(ctx context.Context, id string, userId string) error {
+	s.lock()
+	defer s.unlock()
+
+	s.markRead(func(notification Notification) bool {
+		return notification.Id == id && notification.UserId == userId
+	})
+	return nil
+}
+
+func (s *memoryNotificationStore) MarkTaskRead(ctx context.Context, taskId string, userId string) error {
+	s.lock()
+	defer s.unlock()
+
+	s.markRead(func(notification Notification) bool {
+		return notification.TaskId != nil && *notification.TaskId == taskId && notification.UserId == userId
+	})
+	return nil
+}
+
+type memorySettingsStore struct {
+	*MemoryStore
+}
+
+func (s *memorySettingsStore) Get(ctx context.Context, userId string) (Settings, error) {
+	s.lock()
+	defer s.unlock()
+
+	settings, ok := s.settings[userId]
+	if !ok {
+		return Settings{}, ErrNotFound
+	}
+	return settings, nil
+}
+
+func (s *memorySettingsStore) GetByUnsubscribeToken(ctx context.Context, token string) (Settings, error) {
+	s.lock()
+	defer s.unlock()
+
+	for _, settings := range s.settings {
+		if settings.UnsubscribeToken == token {
+			return settings, nil
+		}
+	}
+	return Settings{}, ErrNotFound
+}
+
+func (s *memorySettingsStore) Ensure(ctx context.Context, userId string) (Settings, error) {
+	s.lock()
+	defer s.unlock()
+
+	settings, ok := s.settings[userId]
+	if !ok {
+		settings = defaultSettings(userId)
+		settings.UnsubscribeToken = uuid.New().String()
+		s.settings[userId] = settings
+	}
+	return settings, nil
+}
+
+func (s *memorySettingsStore) Save(ctx context.Context, settings Settings) error {
+	s.lock()
+	defer s.unlock()
+
+	if stored, ok := s.settings[settings.UserId]; ok {
+		settings.UnsubscribeToken = stored.UnsubscribeToken
+	} else {
+		settings.UnsubscribeToken = uuid.New().String()
+	}
+	s.settings[settings.UserId] = settings
+	return nil
+}
+
+func (s *memorySettingsStore) Unsubscribe(ctx context.Context, token string, list string) error {
+	s.lock()
+	defer s.unlock()
+
+	switch list {
+	case "daily", "weekly", "reminders", "":
+	default:
+		return fmt.Errorf("unknown list %q", list)
+	}
+
+	for userId, settings := range s.settings {
+		if settings.UnsubscribeToken != token {
+			continue
+		}
+		if list == "daily" || list == "" {
+			settings.DailyDigest = false
+		}
+		if list == "weekly" || list == "" {
+			settings.WeeklyDigest = false
+		}
+		if list == "reminders" || list == "" {
+			settings.EmailReminders = false
+		}
+		s.settings[userId] = settings
+		return nil
+	}
+	return ErrNotFound
+}
+
+type memoryDigestStore struct {
+	*MemoryStore
+}
+
+func (s *memoryDigestStore) Subscribers(ctx context.Context) ([]Settings, error) {
+	s.lock()
+	defer s.unlock()
+
+	subscribers := []Settings{}
+	for _, settings := range s.settings {
+		if settings.DailyDigest || settings.WeeklyDigest {
+			subscribers = append(subscribers, settings)
+		}
+	}
+	sort.Slice(subscribers, func(i, j int) bool {
+		return subscribers[i].UserId < subscribers[j].UserId
+	})
+	return subscribers, nil
+}
+
+func (s *memoryDigestStore) Claim(ctx context.Context, userId string, kind string, date string) (bool, error) {
+	s.lock()
+	defer s.unlock()
+
+	key := deliveryKey(userId, kind, date)
+	if s.digestDeliveries[key] {
+		return false, nil
+	}
+	s.digestDeliveries[key] = true
+	return true, nil
+}
+
+type memoryTimerStore struct {
+	*MemoryStore
+}
+
+// Counts a running timer up to now, rounding to the minute like Postgres
+// rounds the ::int cast.
+func (entry memoryTimeEntry) minutes(now time.Time) int {
+	stoppedAt := now
+	if entry.StoppedAt != nil {
+		stoppedAt = *entry.StoppedAt
+	}
+	return int(math.Round(stoppedAt.Sub(entry.StartedAt).Minutes()))
+}
+
+func (entry memoryTimeEntry) timeEntry(now time.Time) TimeEntry {
+	timeEntry := TimeEntry{
+		Id:        entry.Id,
+		TaskId:    entry.TaskId,
+		UserId:    entry.UserId,
+		StartedAt: timestamp(entry.StartedAt),
+		Minutes:   entry.minutes(now),
+	}
+	if entry.StoppedAt != nil {
+		stoppedAt := timestamp(*entry.StoppedAt)
+		timeEntry.StoppedAt = &stoppedAt
+	}
+	return timeEntry
+}
+
+func (s *memoryTimerStore) Running(ctx context.Context, userId string) (TimeEntry, error) {
+	s.lock()
+	defer s.unlock()
+
+	for _, entry := range s.timeEntries {
+		if entry.UserId == userId && entry.StoppedAt == nil {
+			return entry.timeEntry(s.now()), nil
+		}
+	}
+	return TimeEntry{}, ErrNotFound
+}
+
+func (s *memoryTimerStore) ListForTask(ctx context.Context, taskId string, userId string) ([]TimeEntry, error) {
+	s.lock()
+	defer s.unlock()
+
+	now := s.now()
+	entries := []TimeEntry{}
+	for idx := len(s.timeEntries) - 1; idx >= 0; idx-- {
+		entry := s.timeEntries[idx]
+		if entry.TaskId == taskId && entry.UserId == userId {
+			entries = append(entries, entry.timeEntry(now))
+		}
+	}
+	return entries, nil
+}
+
+func (s *memoryTimerStore) Start(ctx context.Context, taskId string, userId string) (TimeEntry, error) {
+	s.lock()
+	defer s.unlock()
+
+	if _, ok := s.tasks[taskId]; !ok {
+		return TimeEntry{}, fmt.Errorf("task %s does not exist", taskId)
+	}
+
+	now := s.now()
+	for idx, entry := range s.timeEntries {
+		if entry.UserId == userId && entry.StoppedAt == nil {
+			s.timeEntries[idx].StoppedAt = &now
+		}
+	}
+
+	entry := memoryTimeEntry{Id: uuid.New().String(), TaskId: taskId, UserId: userId, StartedAt: now}
+	s.timeEntries = append(s.timeEntries, entry)
+	return entry.timeEntry(now), nil
+}
+
+func (s *memoryTimerStore) Stop(ctx context.Context, taskId string, userId string) (TimeEntry, error) {
+	s.lock()
+	defer s.unlock()
+
+	now := s.now()
+	for idx, entry := range s.timeEntries {
+		if entry.TaskId == taskId && entry.UserId == userId && entry.StoppedAt == nil {
+			s.timeEntries[idx].StoppedAt = &now
+			return s.timeEntries[idx].timeEntry(now), nil
+		}
+	}
+	return TimeEntry{}, ErrNotFound
+}
+
+// Must be called with the lock held.
+func (t *memoryTables) trackedMinutes(userId string, now time.Time) map[string]int {
+	minutes := make(map[string]int)
+	for _, entry := range t.timeEntries {
+		if entry.UserId == userId {
+			minutes[entry.TaskId] += entry.minutes(now)
+		}
+	}
+	return minutes
+}
+
+func (s *memoryTimerStore) TrackedMinutes(ctx context.Context, userId string) (map[string]int, error) {
+	s.lock()
+	defer s.unlock()
+
+	return s.trackedMinutes(userId, s.now()), nil
+}
+
+type memoryReportStore struct {
+	*MemoryStore
+}
+
+// Most minutes first, rounding each total like the Postgres store does.
+func reportEntries(minutes map[string]float64, name func(id string) string) []TimeReportEntry {
+	entries := []TimeReportEntry{}
+	for id, total := range minutes {
+		entries = append(entries, TimeReportEntry{Id: id, Name: name(id), Minutes: int(math.Round(total))})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Minutes != entries[j].Minutes {
+			return entries[i].Minutes > entries[j].Minutes
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries
+}
+
+// Must be called with the lock held.
+func (s *memoryReportStore) calendarName(id string) string {
+	return s.calendars[id].Name
+}
+
+// Must be called with the lock held.
+func (s *memoryReportStore) labelName(id string) string {
+	return s.labels[id].Name
+}
+
+// Must be called with the lock held. The ids of the user's labels among labelIds.
+func (s *memoryReportStore) ownLabels(labelIds []string, userId string) []string {
+	return slices.DeleteFunc(slices.Clone(labelIds), func(labelId string) bool {
+		return s.labels[labelId].UserId != userId
+	})
+}
+
+func (s *memoryReportStore) TimeReport(ctx context.Context, userId string, from time.Time, to time.Time) (TimeReport, error) {
+	s.lock()
+	defer s.unlock()
+
+	now := s.now()
+	calendars := make(map[string]float64)
+	labels := make(map[string]float64)
+	total := 0.0
+	for _, entry := range s.timeEntries {
+		stoppedAt := now
+		if entry.StoppedAt != nil {
+			stoppedAt = *entry.StoppedAt
+		}
+		if entry.UserId != userId || !entry.StartedAt.Before(to) || !stoppedAt.After(from) {
+			continue
+		}
+
+		// Entries that straddle the range only count the part inside it
+		start := entry.StartedAt
+		if start.Before(from) {
+			start = from
+		}
+		if stoppedAt.After(to) {
+			stoppedAt = to
+		}
+		minutes := stoppedAt.Sub(start).Minutes()
+		total += minutes
+
+		task, ok := s.tasks[entry.TaskId]
+		if !ok {
+			continue
+		}
+		if _, ok := s.calendars[task.CalendarId]; ok {
+			calendars[task.CalendarId] += minutes
+		}
+		for _, labelId := range s.ownLabels(s.taskLabels[task.Id], userId) {
+			labels[labelId] += minutes
+		}
+	}
+
+	return TimeReport{
+		From:         from.Format(time.RFC3339),
+		To:           to.Format(time.RFC3339),
+		TotalMinutes: int(math.Round(total)),
+		Calendars:    reportEntries(calendars, s.calendarName),
+		Labels:       reportEntries(labels, s.labelName),
+	}, nil
+}
+
+func (s *memoryReportStore) Analytics(ctx context.Context, userId string, from time.Time, to time.Time, location *time.Location) (Analytics, error) {
+	s.lock()
+	defer s.unlock()
+
+	now := s.now()
+	analytics := Analytics{
+		From:     from.Format(time.RFC3339),
+		To:       to.Format(time.RFC3339),
+		Timezone: location.String(),
+		Weekdays: emptyWeekdays(),
+	}
+
+	calendars := make(map[string]float64)
+	labels := make(map[string]float64)
+	for _, event := range s.events {
+		date := eventTime(event)
+		if !s.member(userId, event.CalendarId) || date.Before(from) || !date.Before(to) {
+			continue
+		}
+		calendars[event.CalendarId] += float64(event.Duration)
+		for _, labelId := range s.ownLabels(s.eventLabels[event.Id], userId) {
+			labels[labelId] += float64(event.Duration)
+		}
+
+		// Weekdays run Monday through Sunday
+		day := (int(date.In(location).Weekday()) + 6) % 7
+		analytics.Weekdays[day].Events++
+		analytics.Weekdays[day].Minutes += event.Duration
+	}
+	analytics.Calendars = reportEntries(calendars, s.calendarName)
+	analytics.Labels = reportEntries(labels, s.labelName)
+
+	tracked := s.trackedMinutes(userId, now)
+	accuracy := &analytics.EstimateAccuracy
+	totalError := 0.0
+	for _, task := range s.tasks {
+		if task.UserId != userId || task.Deadline == "" {
+			continue
+		}
+		deadline, _ := time.Parse(dateFormat, task.Deadline)
+		if !task.Completed && deadline.Before(now) {
+			analytics.Tasks.Overdue++
+		}
+		if deadline.Before(from) || !deadline.Before(to) {
+			continue
+		}
+
+		analytics.Tasks.Due++
+		if !task.Completed {
+			continue
+		}
+		analytics.Tasks.Completed++
+
+		minutes, ok := tracked[task.Id]
+		if !ok || task.Duration <= 0 {
+			continue
+		}
+		accuracy.Tasks++
+		accuracy.EstimatedMinutes += task.Duration
+		accuracy.ActualMinutes += minutes
+		totalError += math.Abs(float64(minutes-task.Duration)) / float64(task.Duration)
+	}
+	if accuracy.Tasks > 0 {
+		accuracy.AverageError = totalError / float64(accuracy.Tasks)
+	}
+	return analytics, nil
+}
+
+type memoryAttendeeStore struct {
+	*MemoryStore
+}
+
+func (s *memoryAttendeeStore) List(ctx context.Context, eventKey string) ([]Attendee, error) {
+	s.lock()
+	defer s.unlock()
+
+	attendees := slices.Clone(s.attendees[eventKey])
+	if attendees == nil {
+		attendees = []Attendee{}
+	}
+	sort.Slice(attendees, func(i, j int) bool {
+		return attendees[i].Email < attendees[j].Email
+	})
+	return attendees, nil
+}
+
+// Must be called with the lock held.
+func (s *memoryAttendeeStore) find(match func(Attendee) bool) (string, int, bool) {
+	for eventKey, attendees := range s.attendees {
+		if idx := slices.IndexFunc(attendees, match); idx != -1 {
+			return eventKey, idx, true
+		}
+	}
+	return "", 0, false
+}
+
+func (s *memoryAttendeeStore) Add(ctx context.Context, eventKey string, attendees ...Attendee) error {
+	s.lock()
+	defer s.unlock()
+
+	for _, attendee := range attendees {
+		_, _, exists := s.find(func(other Attendee) bool { return other.Token == attendee.Token })
+		if exists || slices.ContainsFunc(s.attendees[eventKey], func(other Attendee) bool { return other.Email == attendee.Email }) {
+			continue
+		}
+		s.attendees[eventKey] = append(s.attendees[eventKey], attendee)
+	}
+	return nil
+}
+
+func (s *memoryAttendeeStore) RemoveOrphaned(ctx context.Context, eventKey string) error {
+	s.lock()
+	defer s.unlock()
+
+	for _, event := range s.events {
+		if event.Id == eventKey || event.RecurrenceId == eventKey {
+			return nil
+		}
+	}
+	delete(s.attendees, eventKey)
+	return nil
+}
+
+func (s *memoryAttendeeStore) GetByToken(ctx context.Context, token string) (Attendee, error) {
+	s.lock()
+	defer s.unlock()
+
+	eventKey, idx, ok := s.find(func(attendee Attendee) bool { return attendee.Token == token })
+	if !ok {
+		return Attendee{}, ErrNotFound
+	}
+	return s.attendees[eventKey][idx], nil
+}
+
+func (s *memoryAttendeeStore) Respond(ctx context.Context, token string, status string) error {
+	s.lock()
+	defer s.unlock()
+
+	eventKey, idx, ok := s.find(func(attendee Attendee) bool { return attendee.Token == token })
+	if !ok {
+		return ErrNotFound
+	}
+	s.attendees[eventKey][idx].Status = status
+	return nil
+}
+
+type memoryOutboxStore struct {
+	*MemoryStore
+}
+
+func (message memoryOutboxMessage) outboxMessage(withMessage bool) OutboxMessage {
+	outboxMessage := message.OutboxMessage
+	outboxMessage.To = slices.Clone(message.To)
+	outboxMessage.LastError = copyString(message.LastError)
+	outboxMessage.SentAt = copyString(message.SentAt)
+	outboxMessage.NextAttemptAt = timestamp(message.NextAttemptAt)
+	outboxMessage.CreatedAt = timestamp(message.CreatedAt)
+	if !withMessage {
+		outboxMessage.Message = ""
+	}
+	return outboxMessage
+}
+
+// Must be called with the lock held.
+func (s *memoryOutboxStore) find(id string) (*memoryOutboxMessage, bool) {
+	for idx := range s.outbox {
+		if s.outbox[idx].Id == id {
+			return &s.outbox[idx], true
+		}
+	}
+	return nil, false
+}
+
+func (s *memoryOutboxStore) Enqueue(ctx context.Context, to []string, message []byte) error {
+	s.lock()
+	defer s.unlock()
+
+	now := s.now()
+	s.outbox = append(s.outbox, memoryOutboxMessage{
+		OutboxMessage: OutboxMessage{
+			Id:      uuid.New().String(),
+			To:      slices.Clone(to),
+			Message: string(message),
+			Status:  "pending",
+		},
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	})
+	return nil
+}
+
+func (s *memoryOutboxStore) Claim(ctx context.Context, limit int, lease time.Duration) ([]OutboxMessage, error) {
+	s.lock()
+	defer s.unlock()
+
+	now := s.now()
+	due := []*memoryOutboxMessage{}
+	for idx := range s.outbox {
+		if s.outbox[idx].Status == "pending" && !s.outbox[idx].NextAttemptAt.After(now) {
+			due = append(due, &s.outbox[idx])
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+
+	messages := []OutboxMessage{}
+	for _, message := range due[:min(limit, len(due))] {
+		message.NextAttemptAt = now.Add(lease)
+		messages = append(messages, message.outboxMessage(true))
+	}
+	return messages, nil
+}
+
+func (s *memoryOutboxStore) MarkSent(ctx context.Context, id string) error {
+	s.lock()
+	defer s.unlock()
+
+	if message, ok := s.find(id); ok {
+		sentAt := timestamp(s.now())
+		message.Status = "sent"
+		message.Attempts++
+		message.LastError = nil
+		message.SentAt = &sentAt
+	}
+	return nil
+}
+
+func (s *memoryOutboxStore) MarkFailed(ctx context.Context, id string, status string, attempts int, lastError string, retryIn time.Duration) error {
+	s.lock()
+	defer s.unlock()
+
+	if message, ok := s.find(id); ok {
+		message.Status = status
+		message.Attempts = attempts
+		message.LastError = &lastError
+		message.NextAttemptAt = s.now().Add(retryIn)
+	}
+	return nil
+}
+
+func (s *memoryOutboxStore) List(ctx context.Context, status string) ([]OutboxMessage, error) {
+	s.lock()
+	defer s.unlock()
+
+	messages := []OutboxMessage{}
+	for idx := len(s.outbox) - 1; idx >= 0 && len(messages) < 100; idx-- {
+		if s.outbox[idx].Status == status {
+			messages = append(messages, s.outbox[idx].outboxMessage(false))
+		}
+	}
+	return messages, nil
+}
+
+func (s *memoryOutboxStore) Retry(ctx context.Context, id string) error {
+	s.lock()
+	defer s.unlock()
+
+	message, ok := s.find(id)
+	if !ok || message.Status == "sent" {
+		return ErrNotFound
+	}
+	message.Status = "pending"
+	message.Attempts = 0
+	message.NextAttemptAt = s.now()
+	return nil
+}
+
+type memoryTokenStore struct {
+	*MemoryStore
+}
+
+func (token memoryAccessToken) accessToken() AccessToken {
+	accessToken := token.AccessToken
+	accessToken.Scopes = slices.Clone(token.Scopes)
+	accessToken.LastUsedAt = copyString(token.LastUsedAt)
+	accessToken.ExpiresAt = nil
+	if token.ExpiresAt != nil {
+		expiresAt := timestamp(*token.ExpiresAt)
+		accessToken.ExpiresAt = &expiresAt
+	}
+	accessToken.CreatedAt = timestamp(token.CreatedAt)
+	return accessToken
+}
+
+func (s *memoryTokenStore) ListForUser(ctx context.Context, userId string) ([]AccessToken, error) {
+	s.lock()
+	defer s.unlock()
+
+	stored := []memoryAccessToken{}
+	for _, token := range s.tokens {
+		if token.UserId == userId {
+			stored = append(stored, token)
+		}
+	}
+	sort.Slice(stored, func(i, j int) bool {
+		return stored[i].CreatedAt.After(stored[j].CreatedAt)
+	})
+
+	tokens := []AccessToken{}
+	for _, token := range stored {
+		tokens = append(tokens, token.accessToken())
+	}
+	return tokens, nil
+}
+
+func (s *memoryTokenStore) Create(ctx context.Context, token AccessToken, tokenHash string) error {
+	s.lock()
+	defer s.unlock()
+
+	if _, ok := s.tokens[token.Id]; ok {
+		return fmt.Errorf("token %s already exists", token.Id)
+	}
+	for _, other := range s.tokens {
+		if other.Hash == tokenHash {
+			return fmt.Errorf("a token with the same hash already exists")
+		}
+	}
+
+	stored := memoryAccessToken{AccessToken: token, Hash: tokenHash, CreatedAt: s.now()}
+	stored.Scopes = slices.Clone(token.Scopes)
+	stored.LastUsedAt = nil
+	if token.ExpiresAt != nil {
+		expiresAt, err := time.Parse(time.RFC3339, *token.ExpiresAt)
+		if err != nil {
+			return err
+		}
+		stored.ExpiresAt = &expiresAt
+	}
+	s.tokens[token.Id] = stored
+	return nil
+}
+
+func (s *memoryTokenStore) Find(ctx context.Context, tokenHash string) (AccessToken, error) {
+	s.lock()
+	defer s.unlock()
+
+	now := s.now()
+	for _, token := range s.tokens {
+		if token.Hash == tokenHash && (token.ExpiresAt == nil || token.ExpiresAt.After(now)) {
+			return token.accessToken(), nil
+		}
+	}
+	return AccessToken{}, ErrNotFound
+}
+
+func (s *memoryTokenStore) Touch(ctx context.Context, tokenHash string) error {
+	s.lock()
+	defer s.unlock()
+
+	for id, token := range s.tokens {
+		if token.Hash == tokenHash {
+			lastUsedAt := timestamp(s.now())
+			token.LastUsedAt = &lastUsedAt
+			s.tokens[id] = token
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *memoryTokenStore) Revoke(ctx context.Context, id string, userId string) (string, error) {
+	s.lock()
+	defer s.unlock()
+
+	token, ok := s.tokens[id]
+	if !ok || token.UserId != userId {
+		return "", ErrNotFound
+	}
+	delete(s.tokens, id)
+	return token.Hash, nil
+}
+
+func (s *memoryTokenStore) HashesForUser(ctx context.Context, userId string) ([]string, error) {
+	s.lock()
+	defer s.unlock()
+
+	hashes := []string{}
+	for _, token := range s.tokens {
+		if token.UserId == userId {
+			hashes = append(hashes, token.Hash)
+		}
+	}
+	return hashes, nil
+}
+
+type memoryUserStore struct {
+	*MemoryStore
+}
+
+func (s *memoryUserStore) Save(ctx context.Context, users ...User) error {
+	s.lock()
+	defer s.unlock()
+
+	for _, user := range users {
+		s.users[user.Id] = user
+	}
+	return nil
+}
+
+func (s *memoryUserStore) Get(ctx context.Context, id string) (User, error) {
+	s.lock()
+	defer s.unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (s *memoryUserStore) GetByEmail(ctx context.Context, email string) (User, error) {
+	s.lock()
+	defer s.unlock()
+
+	for _, user := range s.users {
+		if strings.EqualFold(user.Email, email) {
+			return user, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (s *memoryUserStore) List(ctx context.Context) ([]User, error) {
+	s.lock()
+	defer s.unlock()
+
+	users := slices.Collect(maps.Values(s.users))
+	if users == nil {
+		users = []User{}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Email < users[j].Email
+	})
+	return users, nil
+}
+
+func (s *memoryUserStore) Search(ctx context.Context, requesterId string, query string, limit int, offset int) ([]UserSearchResult, error) {
+	s.lock()
+	defer s.unlock()
+
+	collaborators := map[string]bool{requesterId: true}
+	for _, calendar := range s.calendars {
+		if slices.Contains(calendar.Members, requesterId) {
+			for _, member := range calendar.Members {
+				collaborators[member] = true
+			}
+		}
+	}
+
+	query = strings.ToLower(strings.TrimSpace(query))
+	matches := func(field string) bool {
+		return strings.HasPrefix(strings.ToLower(field), query)
+	}
+
+	results := []UserSearchResult{}
+	for _, user := range s.users {
+		collaborator := collaborators[user.Id]
+		byName := matches(user.Username) || matches(user.FirstName) || matches(user.LastName) ||
+			matches(user.FirstName+" "+user.LastName)
+		if (byName && (collaborator || utf8.RuneCountInString(query) >= minUserSearchLength)) || (collaborator && matches(user.Email)) || strings.ToLower(user.Email) == query {
+			results = append(results, UserSearchResult{
+				Id:           user.Id,
+				Email:        user.Email,
+				FirstName:    user.FirstName,
+				LastName:     user.LastName,
+				Username:     user.Username,
+				Avatar:       user.Avatar,
+				Collaborator: collaborator,
+			})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Collaborator != b.Collaborator {
+			return a.Collaborator
+		}
+		if !strings.EqualFold(a.FirstName, b.FirstName) {
+			return strings.ToLower(a.FirstName) < strings.ToLower(b.FirstName)
+		}
+		if !strings.EqualFold(a.LastName, b.LastName) {
+			return strings.ToLower(a.LastName) < strings.ToLower(b.LastName)
+		}
+		return a.Email < b.Email
+	})
+
+	if offset >= len(results) {
+		return []UserSearchResult{}, nil
+	}
+	return results[offset:min(offset+limit, len(results))], nil
+}
+
+func (s *memoryUserStore) DeleteExcept(ctx context.Context, ids []string) error {
+	s.lock()
+	defer s.unlock()
+
+	for id := range s.users {
+		if !slices.Contains(ids, id) {
+			delete(s.users, id)
+		}
+	}
+	return nil
+}
+
+func (s *memoryUserStore) Delete(ctx context.Context, id string) error {
+	s.lock()
+	defer s.unlock()
+
+	for calendarId, calendar := range s.calendars {
+		others := slices.DeleteFunc(slices.Clone(calendar.Members), func(member string) bool { return member == id })
+		if len(others) == 0 && slices.Contains(calendar.Members, id) {
+			s.deleteCalendar(calendarId)
+			continue
+		}
+		if calendar.OwnerId == id {
+			calendar.OwnerId = ""
+			if len(others) > 0 {
+				calendar.OwnerId = others[0]
+			}
+		}
+		calendar.Members = others
+	}
+
+	for eventId, ownerId := range s.eventOwners {
+		if ownerId == id {
+			s.eventOwners[eventId] = s.calendars[s.events[eventId].CalendarId].OwnerId
+		}
+	}
+
+	// Tasks take their labels, time entries and reminders with them
+	for taskId, task := range s.tasks {
+		if task.UserId == id {
+			s.deleteTask(taskId)
+		}
+	}
+	for labelId, label := range s.labels {
+		if label.UserId == id {
+			s.deleteLabel(labelId)
+		}
+	}
+	for reminderId, reminder := range s.reminders {
+		if reminder.UserId == id {
+			s.deleteReminder(reminderId)
+		}
+	}
+	s.notifications = slices.DeleteFunc(s.notifications, func(notification Notification) bool {
+		return notification.UserId == id
+	})
+	s.timeEntries = slices.DeleteFunc(s.timeEntries, func(entry memoryTimeEntry) bool {
+		return entry.UserId == id
+	})
+	for key := range s.digestDeliveries {
+		if strings.HasPrefix(key, id+"|") {
+			delete(s.digestDeliveries, key)
+		}
+	}
+	delete(s.settings, id)
+	for tokenId, token := range s.tokens {
+		if token.UserId == id {
+			delete(s.tokens, tokenId)
+		}
+	}
+	delete(s.users, id)
+	return nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+)
+
+func metricValue(metrics *expvar.Map, key string) int64 {
+	if value, ok := metrics.Get(key).(*expvar.Int); ok {
+		return value.Value()
+	}
+	return 0
+}
+
+// GET /admin/metrics
+// Every published expvar, including the memory stats the runtime adds.
+func getMetrics(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+	if !isAdmin(session) {
+		http.Error(w, `{"error": "Forbidden"}`, http.StatusForbidden)
+		return
+	}
+
+	expvar.Handler().ServeHTTP(w, r)
+}
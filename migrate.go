@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Migrations are numbered pairs of files, 0001_name.up.sql and
+// 0001_name.down.sql, applied in order. Each one runs in its own transaction
+// and is recorded in schema_migrations.
+//
+// 0001 is the schema as it was before migrations, and every later table or
+// column is added in a migration of its own. They all create tables and add
+// columns only if they don't exist, so databases set up from the old
+// schema.sql, at whatever point it was at, are adopted as they are. An applied
+// migration is never edited; changes go in a new one.
+//
+//go:embed migrations
+var migrationFS embed.FS
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Held for the whole run so replicas starting at the same time don't apply the
+// same migration twice. Any constant works as long as nothing else uses it.
+var migrationLockId int64 = 4_203_518_227
+
+func loadMigrations() ([]Migration, error) {
+	files, err := fs.ReadDir(migrationFS, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, file := range files {
+		name, direction, ok := strings.Cut(strings.TrimSuffix(file.Name(), ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("unexpected migration file %s", file.Name())
+		}
+		number, label, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(number)
+		if err != nil {
+			return nil, fmt.Errorf("migration file %s doesn't start with a version", file.Name())
+		}
+
+		contents, err := migrationFS.ReadFile(path.Join("migrations", file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration := byVersion[version]
+		if migration == nil {
+			migration = &Migration{Version: version, Name: label}
+			byVersion[version] = migration
+		} else if migration.Name != label {
+			return nil, fmt.Errorf("migration %d has files named both %s and %s", version, migration.Name, label)
+		}
+		if direction == "up" {
+			migration.Up = string(contents)
+		} else {
+			migration.Down = string(contents)
+		}
+	}
+
+	migrations := []Migration{}
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up file", migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Runs fn on a single connection holding the migration lock, after making sure
+// schema_migrations exists. Migrations aren't bound by the database timeout,
+// only by ctx.
+func withMigrationLock(ctx context.Context, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockId); err != nil {
+		return err
+	}
+	// Unlocks even if ctx was cancelled, so the connection goes back to the
+	// pool without the lock
+	defer conn.Exec(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", migrationLockId)
+
+	_, err = conn.Exec(ctx,
+		`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func appliedMigrations(ctx context.Context, conn *pgxpool.Conn) (map[int]time.Time, error) {
+	rows, err := conn.Query(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// Runs one direction of a migration and records it, all in one transaction.
+func runMigration(ctx context.Context, conn *pgxpool.Conn, migration Migration, up bool) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	statements := migration.Down
+	if up {
+		statements = migration.Up
+	}
+	if _, err := tx.Exec(ctx, statements); err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+
+	if up {
+		_, err = tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", migration.Version, migration.Name)
+	} else {
+		_, err = tx.Exec(ctx, "DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+	}
+	if err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Applies every pending migration, returning how many ran.
+func MigrateUp(ctx context.Context) (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = withMigrationLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			if err := runMigration(ctx, conn, migration, true); err != nil {
+				return fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+			log.Printf("Applied migration %04d_%s", migration.Version, migration.Name)
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Reverts the latest steps applied migrations.
+func MigrateDown(ctx context.Context, steps int) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	return withMigrationLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for idx := len(migrations) - 1; idx >= 0 && steps > 0; idx-- {
+			migration := migrations[idx]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %04d_%s can't be reverted", migration.Version, migration.Name)
+			}
+			if err := runMigration(ctx, conn, migration, false); err != nil {
+				return fmt.Errorf("reverting migration %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+			log.Printf("Reverted migration %04d_%s", migration.Version, migration.Name)
+			steps--
+		}
+		return nil
+	})
+}
+
+func GetMigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []MigrationStatus{}
+	err = withMigrationLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			status := MigrationStatus{Migration: migration}
+			if appliedAt, ok := applied[migration.Version]; ok {
+				status.AppliedAt = &appliedAt
+			}
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// Handles `calendar-backend migrate up|down [steps]|status`.
+func runMigrateCommand(args []string) {
+	config, err := LoadDatabaseConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := InitDatabase(ctx, config); err != nil {
+		log.Fatal("Error connecting to database: ", err)
+	}
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		count, err := MigrateUp(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Applied %d migrations", count)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				log.Fatal("Usage: migrate down [steps]")
+			}
+		}
+		if err := MigrateDown(ctx, steps); err != nil {
+			log.Fatal(err)
+		}
+	case "status":
+		statuses, err := GetMigrationStatus(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, applied)
+		}
+	default:
+		log.Fatal("Usage: migrate up|down [steps]|status")
+	}
+}
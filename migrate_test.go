@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestMigrationsAreNumberedInOrder(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	for idx, migration := range migrations {
+		if migration.Version != idx+1 {
+			t.Fatalf("migration %04d_%s follows version %d", migration.Version, migration.Name, idx)
+		}
+		if migration.Down == "" {
+			t.Errorf("migration %04d_%s has no down file", migration.Version, migration.Name)
+		}
+	}
+}
+
+// Databases from the old schema.sql may already have any table, column or
+// index, so migrations only create them if they don't exist.
+func TestMigrationsOnlyAddWhatIsMissing(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	creates := regexp.MustCompile(`(?i)(ADD COLUMN|CREATE TABLE|CREATE INDEX|CREATE UNIQUE INDEX)\s+(\w+)`)
+	for _, migration := range migrations {
+		for _, match := range creates.FindAllStringSubmatch(migration.Up, -1) {
+			if match[2] != "IF" {
+				t.Errorf("migration %04d_%s: %s %s doesn't check whether it exists", migration.Version, migration.Name, match[1], match[2])
+			}
+		}
+	}
+}
+
+// Connects to TEST_DATABASE_URL in a schema of its own, which is dropped when
+// the test ends. Skips the test if no database is configured.
+func testDatabase(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+
+	admin, err := pgx.Connect(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close(context.Background()) })
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		admin.Exec(context.Background(), "DROP SCHEMA "+schema+" CASCADE")
+	})
+
+	config, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ConnConfig.RuntimeParams["search_path"] = schema
+	database, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(database.Close)
+
+	_, err = database.Exec(ctx,
+		`
+		CREATE TABLE schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)
+		`,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return database
+}
+
+func TestOwnersMigrationKeepsExistingOwners(t *testing.T) {
+	ctx := context.Background()
+	conn, err := testDatabase(t).Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Release()
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runMigration(ctx, conn, migrations[0], true); err != nil {
+		t.Fatal(err)
+	}
+
+	// The schema as schema.sql left it, with owners already recorded
+	owned, unowned := uuid.New().String(), uuid.New().String()
+	ownedEvent, unownedEvent := uuid.New().String(), uuid.New().String()
+	_, err = conn.Exec(ctx,
+		`
+		ALTER TABLE calendars ADD COLUMN user_id VARCHAR(36);
+		ALTER TABLE events ADD COLUMN user_id VARCHAR(36);
+		CREATE INDEX calendars_user_idx ON calendars (user_id);
+		CREATE INDEX events_user_idx ON events (user_id);
+		`,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, statement := range []struct {
+		query string
+		args  []any
+	}{
+		{"INSERT INTO calendars (id, user_id, name, color, is_default) VALUES ($1, 'owner', 'Owned', '#000000', FALSE)", []any{owned}},
+		{"INSERT INTO calendars (id, name, color, is_default) VALUES ($1, 'Unowned', '#000000', FALSE)", []any{unowned}},
+		{"INSERT INTO calendar_members (calendar_id, user_id, created_at) VALUES ($1, 'first', '2020-01-01'), ($1, 'owner', '2021-01-01')", []any{owned}},
+		{"INSERT INTO calendar_members (calendar_id, user_id, created_at) VALUES ($1, 'first', '2020-01-01'), ($1, 'owner', '2021-01-01')", []any{unowned}},
+		{"INSERT INTO events (id, calendar_id, user_id, date, title, duration) VALUES ($1, $2, 'creator', CURRENT_TIMESTAMP, 'Owned', 30)", []any{ownedEvent, owned}},
+		{"INSERT INTO events (id, calendar_id, date, title, duration) VALUES ($1, $2, CURRENT_TIMESTAMP, 'Unowned', 30)", []any{unownedEvent, owned}},
+	} {
+		if _, err := conn.Exec(ctx, statement.query, statement.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := runMigration(ctx, conn, migrations[1], true); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []struct {
+		query string
+		id    string
+		owner string
+	}{
+		{"SELECT user_id FROM calendars WHERE id = $1", owned, "owner"},
+		{"SELECT user_id FROM calendars WHERE id = $1", unowned, "first"},
+		{"SELECT user_id FROM events WHERE id = $1", ownedEvent, "creator"},
+		{"SELECT user_id FROM events WHERE id = $1", unownedEvent, "owner"},
+	} {
+		var owner string
+		if err := conn.QueryRow(ctx, want.query, want.id).Scan(&owner); err != nil {
+			t.Fatal(err)
+		}
+		if owner != want.owner {
+			t.Errorf("%s with %s got owner %s, want %s", want.query, want.id, owner, want.owner)
+		}
+	}
+}
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS tasks;
+DROP TABLE IF EXISTS events;
+DROP TABLE IF EXISTS calendar_members;
+DROP TABLE IF EXISTS calendars;
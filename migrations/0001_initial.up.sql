@@ -1,4 +1,4 @@
-CREATE TABLE calendars (
+CREATE TABLE IF NOT EXISTS calendars (
     id UUID PRIMARY KEY,
     name VARCHAR(255) NOT NULL,
     color VARCHAR(7) NOT NULL,
@@ -7,7 +7,7 @@ CREATE TABLE calendars (
     updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
 );
 
-CREATE TABLE calendar_members (
+CREATE TABLE IF NOT EXISTS calendar_members (
     calendar_id UUID NOT NULL,
     user_id VARCHAR(36) NOT NULL,
     created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
@@ -16,7 +16,7 @@ CREATE TABLE calendar_members (
     FOREIGN KEY (calendar_id) REFERENCES calendars(id) ON DELETE CASCADE
 );
 
-CREATE TABLE events (
+CREATE TABLE IF NOT EXISTS events (
     id UUID PRIMARY KEY,
     calendar_id UUID NOT NULL,
     date TIMESTAMPTZ NOT NULL,
@@ -29,7 +29,7 @@ CREATE TABLE events (
     FOREIGN KEY (calendar_id) REFERENCES calendars(id) ON DELETE CASCADE
 );
 
-CREATE TABLE tasks (
+CREATE TABLE IF NOT EXISTS tasks (
     id VARCHAR(255) PRIMARY KEY,
     user_id VARCHAR(255) NOT NULL,
     calendar_id VARCHAR(255) NOT NULL,
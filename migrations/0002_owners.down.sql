@@ -0,0 +1,2 @@
+ALTER TABLE events DROP COLUMN user_id;
+ALTER TABLE calendars DROP COLUMN user_id;
@@ -0,0 +1,24 @@
+-- The user who created each calendar and event. Databases from before
+-- migrations already have these columns, so only calendars and events without
+-- an owner are filled in: calendars go to their earliest member, and events to
+-- the owner of their calendar.
+ALTER TABLE calendars ADD COLUMN IF NOT EXISTS user_id VARCHAR(36);
+ALTER TABLE events ADD COLUMN IF NOT EXISTS user_id VARCHAR(36);
+
+UPDATE calendars
+SET user_id = (
+    SELECT user_id FROM calendar_members
+    WHERE calendar_members.calendar_id = calendars.id
+    ORDER BY created_at, user_id
+    LIMIT 1
+)
+WHERE user_id IS NULL;
+
+UPDATE events
+SET user_id = calendars.user_id
+FROM calendars
+WHERE calendars.id = events.calendar_id
+AND events.user_id IS NULL;
+
+CREATE INDEX IF NOT EXISTS calendars_user_idx ON calendars (user_id);
+CREATE INDEX IF NOT EXISTS events_user_idx ON events (user_id);
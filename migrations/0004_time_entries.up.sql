@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS time_entries (
+    id UUID PRIMARY KEY,
+    task_id VARCHAR(255) NOT NULL,
+    user_id VARCHAR(36) NOT NULL,
+    started_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    stopped_at TIMESTAMPTZ,
+    FOREIGN KEY (task_id) REFERENCES tasks(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS time_entries_running_idx ON time_entries (user_id) WHERE stopped_at IS NULL;
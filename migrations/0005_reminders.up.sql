@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS reminders (
+    id UUID PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL,
+    event_id UUID,
+    recurrence_id UUID,
+    calendar_id UUID,
+    minutes_before INTEGER NOT NULL,
+    method VARCHAR(16) NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE,
+    FOREIGN KEY (calendar_id) REFERENCES calendars(id) ON DELETE CASCADE,
+    CONSTRAINT reminders_target_check CHECK (num_nonnulls(event_id, recurrence_id, calendar_id) = 1),
+    CHECK (minutes_before >= 0),
+    CHECK (method IN ('email', 'app'))
+);
+
+CREATE TABLE IF NOT EXISTS reminder_deliveries (
+    reminder_id UUID NOT NULL,
+    event_id UUID NOT NULL,
+    fire_at TIMESTAMPTZ NOT NULL,
+    delivered_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (reminder_id, event_id, fire_at),
+    FOREIGN KEY (reminder_id) REFERENCES reminders(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS notifications (
+    id UUID PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    body TEXT NOT NULL,
+    event_id UUID,
+    task_id VARCHAR(255),
+    read_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS notifications_user_idx ON notifications (user_id, created_at DESC);
@@ -0,0 +1,14 @@
+ALTER TABLE notifications DROP COLUMN IF EXISTS reminder_id;
+
+-- Their deliveries go with them
+DELETE FROM reminders WHERE task_id IS NOT NULL;
+
+ALTER TABLE reminder_deliveries RENAME COLUMN target_id TO event_id;
+ALTER TABLE reminder_deliveries ALTER COLUMN event_id TYPE UUID USING event_id::uuid;
+
+ALTER TABLE reminders DROP CONSTRAINT IF EXISTS reminders_overdue_check;
+ALTER TABLE reminders DROP CONSTRAINT IF EXISTS reminders_target_check;
+ALTER TABLE reminders DROP COLUMN IF EXISTS snoozed_until;
+ALTER TABLE reminders DROP COLUMN IF EXISTS overdue;
+ALTER TABLE reminders DROP COLUMN IF EXISTS task_id;
+ALTER TABLE reminders ADD CONSTRAINT reminders_target_check CHECK (num_nonnulls(event_id, recurrence_id, calendar_id) = 1);
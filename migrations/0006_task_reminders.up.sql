@@ -0,0 +1,28 @@
+-- Reminders can be set on a task's deadline, fire again once it is overdue,
+-- and be snoozed.
+ALTER TABLE reminders ADD COLUMN IF NOT EXISTS task_id VARCHAR(255) REFERENCES tasks(id) ON DELETE CASCADE;
+ALTER TABLE reminders ADD COLUMN IF NOT EXISTS overdue BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE reminders ADD COLUMN IF NOT EXISTS snoozed_until TIMESTAMPTZ;
+
+-- Databases set up from schema.sql have these checks under generated names
+ALTER TABLE reminders DROP CONSTRAINT IF EXISTS reminders_check;
+ALTER TABLE reminders DROP CONSTRAINT IF EXISTS reminders_check1;
+ALTER TABLE reminders DROP CONSTRAINT IF EXISTS reminders_target_check;
+ALTER TABLE reminders DROP CONSTRAINT IF EXISTS reminders_overdue_check;
+ALTER TABLE reminders ADD CONSTRAINT reminders_target_check CHECK (num_nonnulls(event_id, recurrence_id, calendar_id, task_id) = 1);
+ALTER TABLE reminders ADD CONSTRAINT reminders_overdue_check CHECK (NOT overdue OR task_id IS NOT NULL);
+
+-- Deliveries record a task id or an event id
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'reminder_deliveries' AND column_name = 'event_id'
+    ) THEN
+        ALTER TABLE reminder_deliveries RENAME COLUMN event_id TO target_id;
+        ALTER TABLE reminder_deliveries ALTER COLUMN target_id TYPE VARCHAR(255) USING target_id::text;
+    END IF;
+END
+$$;
+
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS reminder_id UUID;
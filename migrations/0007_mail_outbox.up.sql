@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS mail_outbox (
+    id UUID PRIMARY KEY,
+    recipients TEXT NOT NULL,
+    message TEXT NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMPTZ,
+    CHECK (status IN ('pending', 'sent', 'dead'))
+);
+
+CREATE INDEX IF NOT EXISTS mail_outbox_pending_idx ON mail_outbox (next_attempt_at) WHERE status = 'pending';
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS event_attendees;
+DROP TABLE IF EXISTS user_settings;
+ALTER TABLE events DROP COLUMN IF EXISTS location;
@@ -0,0 +1,20 @@
+ALTER TABLE events ADD COLUMN IF NOT EXISTS location TEXT;
+
+CREATE TABLE IF NOT EXISTS user_settings (
+    user_id VARCHAR(36) PRIMARY KEY,
+    locale VARCHAR(16) NOT NULL DEFAULT 'en',
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS event_attendees (
+    event_key UUID NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'needs-action',
+    token UUID NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (event_key, email),
+    CHECK (status IN ('needs-action', 'accepted', 'declined', 'tentative'))
+);
@@ -0,0 +1,2 @@
+-- Bumped on every change attendees are told about, as iTIP requires
+ALTER TABLE events ADD COLUMN IF NOT EXISTS sequence INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS digest_deliveries;
+ALTER TABLE user_settings DROP COLUMN IF EXISTS unsubscribe_token;
+ALTER TABLE user_settings DROP COLUMN IF EXISTS weekly_digest;
+ALTER TABLE user_settings DROP COLUMN IF EXISTS daily_digest;
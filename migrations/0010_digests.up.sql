@@ -0,0 +1,14 @@
+ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS daily_digest BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS weekly_digest BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Existing settings get a token of their own; new ones are given one by the app
+ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS unsubscribe_token UUID NOT NULL UNIQUE DEFAULT gen_random_uuid();
+ALTER TABLE user_settings ALTER COLUMN unsubscribe_token DROP DEFAULT;
+
+CREATE TABLE IF NOT EXISTS digest_deliveries (
+    user_id VARCHAR(36) NOT NULL,
+    kind VARCHAR(16) NOT NULL,
+    local_date DATE NOT NULL,
+    delivered_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, kind, local_date)
+);
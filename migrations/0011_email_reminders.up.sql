@@ -0,0 +1 @@
+ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS email_reminders BOOLEAN NOT NULL DEFAULT TRUE;
@@ -0,0 +1,4 @@
+-- Set on an occurrence of a series that was edited on its own, to the date it
+-- had in the series. It stays in the series so its attendees and reminders
+-- still apply, and emails about it name it by that date.
+ALTER TABLE events ADD COLUMN IF NOT EXISTS original_date TIMESTAMPTZ;
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// An email to be built into a MIME message. Text is required; HTML and the
+// calendar attachment are optional.
+type OutgoingMail struct {
+	To       []string
+	Subject  string
+	Text     string
+	HTML     string
+	Calendar string
+	// The iTIP method of the calendar attachment, e.g. "REQUEST" or "CANCEL"
+	Method string
+	// Every message with the same thread id is sent as a reply to the same
+	// root message, so mail clients group them into one conversation.
+	Thread string
+	// Set on the root message itself, which takes the thread's Message-ID
+	// instead of replying to it
+	StartsThread bool
+	// A link that unsubscribes the recipient with a single POST (RFC 8058),
+	// for mail they opted into
+	Unsubscribe string
+}
+
+func mailDomain() string {
+	if at := strings.LastIndex(fromEmail, "@"); at != -1 {
+		return fromEmail[at+1:]
+	}
+	return "localhost"
+}
+
+func threadMessageId(thread string) string {
+	return fmt.Sprintf("<%s@%s>", thread, mailDomain())
+}
+
+// Builds the message with CRLF line endings, random boundaries, an RFC 2047
+// encoded subject and quoted-printable bodies.
+func (m OutgoingMail) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+
+	from := mail.Address{Name: fromName, Address: fromEmail}
+	to := make([]string, len(m.To))
+	for i, recipient := range m.To {
+		to[i] = (&mail.Address{Address: recipient}).String()
+	}
+
+	writeHeader(&buf, "From", from.String())
+	writeHeader(&buf, "To", strings.Join(to, ", "))
+	writeHeader(&buf, "Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	writeHeader(&buf, "Date", time.Now().Format(time.RFC1123Z))
+	if m.Thread != "" && m.StartsThread {
+		writeHeader(&buf, "Message-ID", threadMessageId(m.Thread))
+	} else {
+		writeHeader(&buf, "Message-ID", fmt.Sprintf("<%s@%s>", uuid.New().String(), mailDomain()))
+	}
+	if m.Thread != "" && !m.StartsThread {
+		writeHeader(&buf, "In-Reply-To", threadMessageId(m.Thread))
+		writeHeader(&buf, "References", threadMessageId(m.Thread))
+	}
+	if m.Unsubscribe != "" {
+		writeHeader(&buf, "List-Unsubscribe", "<"+m.Unsubscribe+">")
+		writeHeader(&buf, "List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+	writeHeader(&buf, "MIME-Version", "1.0")
+
+	if m.HTML == "" && m.Calendar == "" {
+		writeHeader(&buf, "Content-Type", `text/plain; charset="utf-8"`)
+		writeHeader(&buf, "Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, m.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	mixed := multipart.NewWriter(&buf)
+	writeHeader(&buf, "Content-Type", fmt.Sprintf(`multipart/mixed; boundary="%s"`, mixed.Boundary()))
+	buf.WriteString("\r\n")
+
+	if m.HTML != "" {
+		// Only used to pick a random boundary before the part exists
+		boundary := multipart.NewWriter(nil).Boundary()
+		part, err := mixed.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {fmt.Sprintf(`multipart/alternative; boundary="%s"`, boundary)},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		alternative := multipart.NewWriter(part)
+		if err := alternative.SetBoundary(boundary); err != nil {
+			return nil, err
+		}
+		if err := writeTextPart(alternative, "text/plain", m.Text); err != nil {
+			return nil, err
+		}
+		if err := writeTextPart(alternative, "text/html", m.HTML); err != nil {
+			return nil, err
+		}
+		if err := alternative.Close(); err != nil {
+			return nil, err
+		}
+	} else if err := writeTextPart(mixed, "text/plain", m.Text); err != nil {
+		return nil, err
+	}
+
+	if m.Calendar != "" {
+		contentType := `text/calendar; charset="utf-8"`
+		if m.Method != "" {
+			contentType += "; method=" + m.Method
+		}
+		part, err := mixed.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Disposition":       {`attachment; filename="event.ics"`},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(part, m.Calendar); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeHeader(buf *bytes.Buffer, key string, value string) {
+	buf.WriteString(key + ": " + value + "\r\n")
+}
+
+func writeTextPart(writer *multipart.Writer, contentType string, body string) error {
+	part, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType + `; charset="utf-8"`},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	return writeQuotedPrintable(part, body)
+}
+
+// Quoted-printable keeps lines short and turns every line break into CRLF.
+func writeQuotedPrintable(w io.Writer, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
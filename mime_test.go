@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestThreadedMessageIds(t *testing.T) {
+	oldFrom := fromEmail
+	t.Cleanup(func() { fromEmail = oldFrom })
+	fromEmail = "calendar@example.com"
+
+	thread := "5d1f1a2e-7b9c-4f0e-8a63-2c4b5e6f7a80"
+	root := threadMessageId(thread)
+
+	headers := func(m OutgoingMail) mail.Header {
+		t.Helper()
+		message, err := m.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := mail.ReadMessage(strings.NewReader(string(message)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed.Header
+	}
+
+	invitation := headers(OutgoingMail{To: []string{"guest@example.com"}, Subject: "Invitation", Text: "Hi", Thread: thread, StartsThread: true})
+	if got := invitation.Get("Message-ID"); got != root {
+		t.Fatalf("invitation has Message-ID %s, want %s", got, root)
+	}
+	if invitation.Get("In-Reply-To") != "" || invitation.Get("References") != "" {
+		t.Fatal("invitation replies to itself")
+	}
+
+	update := headers(OutgoingMail{To: []string{"guest@example.com"}, Subject: "Updated", Text: "Hi", Thread: thread})
+	if got := update.Get("Message-ID"); got == root || got == "" {
+		t.Fatalf("update has Message-ID %q, want a new one", got)
+	}
+	if update.Get("In-Reply-To") != root || update.Get("References") != root {
+		t.Fatalf("update replies to %q and references %q, want %s", update.Get("In-Reply-To"), update.Get("References"), root)
+	}
+
+	plain := headers(OutgoingMail{To: []string{"guest@example.com"}, Subject: "Hello", Text: "Hi"})
+	if plain.Get("Message-ID") == "" || plain.Get("In-Reply-To") != "" {
+		t.Fatal("unthreaded message should have its own Message-ID and reply to nothing")
+	}
+}
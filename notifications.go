@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type Notification struct {
+	Id         string  `json:"id"`
+	UserId     string  `json:"userId"`
+	Title      string  `json:"title"`
+	Body       string  `json:"body"`
+	EventId    *string `json:"eventId"`
+	TaskId     *string `json:"taskId"`
+	ReminderId *string `json:"reminderId"`
+	ReadAt     *string `json:"readAt"`
+	CreatedAt  string  `json:"createdAt"`
+}
+
+// Stores an in-app notification for the user.
+func Notify(ctx context.Context, tx Store, notification Notification) error {
+	notification.Id = uuid.New().String()
+	return tx.Notifications().Create(ctx, notification)
+}
+
+// GET /notifications
+func getNotifications(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+	notifications, err := store.Notifications().ListForUser(r.Context(), session.Identity.Id, unreadOnly)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notifications)
+}
+
+// POST /notifications/{id}/read
+func readNotification(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+
+	vars := mux.Vars(r)
+	notificationId := vars["id"]
+
+	if _, err := uuid.Parse(notificationId); err != nil {
+		http.Error(w, `{"error": "Notification not found"}`, http.StatusNotFound)
+		return
+	}
+
+	if err := store.Notifications().MarkRead(r.Context(), notificationId, session.Identity.Id); err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
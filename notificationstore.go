@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type PostgresNotificationStore struct {
+	db Querier
+}
+
+func (s *PostgresNotificationStore) Create(ctx context.Context, notification Notification) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	_, err := s.db.Exec(ctx,
+		`
+		INSERT INTO notifications (id, user_id, title, body, event_id, task_id, reminder_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`,
+		notification.Id,
+		notification.UserId,
+		notification.Title,
+		notification.Body,
+		notification.EventId,
+		notification.TaskId,
+		notification.ReminderId,
+	)
+	return err
+}
+
+func (s *PostgresNotificationStore) ListForUser(ctx context.Context, userId string, unreadOnly bool) ([]Notification, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	query := `
+		SELECT id, user_id, title, body, event_id, task_id, reminder_id, read_at, created_at
+		FROM notifications
+		WHERE user_id = $1
+		`
+	if unreadOnly {
+		query += `AND read_at IS NULL
+		`
+	}
+	query += `ORDER BY created_at DESC
+		LIMIT 100`
+
+	rows, err := s.db.Query(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var notification Notification
+		var eventId, taskId, reminderId sql.NullString
+		var readAt sql.NullTime
+		var createdAt time.Time
+		err := rows.Scan(
+			&notification.Id,
+			&notification.UserId,
+			&notification.Title,
+			&notification.Body,
+			&eventId,
+			&taskId,
+			&reminderId,
+			&readAt,
+			&createdAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		notification.EventId = nullString(eventId)
+		notification.TaskId = nullString(taskId)
+		notification.ReminderId = nullString(reminderId)
+		notification.ReadAt = nullTimestamp(readAt)
+		notification.CreatedAt = timestamp(createdAt)
+		notifications = append(notifications, notification)
+	}
+	return notifications, rows.Err()
+}
+
+func (s *PostgresNotificationStore) MarkRead(ctx context.Context, id string, userId string) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return nil
+	}
+
+	_, err := s.db.Exec(ctx,
+		`
+		UPDATE notifications
+		SET read_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2 AND read_at IS NULL
+		`,
+		id,
+		userId,
+	)
+	return err
+}
+
+func (s *PostgresNotificationStore) MarkTaskRead(ctx context.Context, taskId string, userId string) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	_, err := s.db.Exec(ctx,
+		`
+		UPDATE notifications
+		SET read_at = CURRENT_TIMESTAMP
+		WHERE task_id = $1 AND user_id = $2 AND read_at IS NULL
+		`,
+		taskId,
+		userId,
+	)
+	return err
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Accepts RS256 JWTs from an OIDC issuer as bearer tokens, verified against a
+// JWKS file on disk so no request waits on the issuer. OIDC has no way to list
+// users, so everyone who signs in is remembered in the users table.
+type OIDCProvider struct {
+	Issuer   string
+	Audience string
+	keys     map[string]*rsa.PublicKey
+}
+
+// Tolerated clock skew between us and the issuer.
+var jwtLeeway = time.Minute
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyId     string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	Expiry            int64    `json:"exp"`
+	NotBefore         int64    `json:"nbf"`
+	Email             string   `json:"email"`
+	GivenName         string   `json:"given_name"`
+	FamilyName        string   `json:"family_name"`
+	PreferredUsername string   `json:"preferred_username"`
+	Picture           string   `json:"picture"`
+}
+
+// The aud claim is either a single string or a list of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func NewOIDCProvider(issuer string, audience string, jwksPath string) (*OIDCProvider, error) {
+	if issuer == "" || audience == "" || jwksPath == "" {
+		return nil, fmt.Errorf("OIDC needs an issuer, audience and JWKS file")
+	}
+	data, err := os.ReadFile(jwksPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var jwks struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyId   string `json:"kid"`
+			Use     string `json:"use"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", jwksPath, err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, key := range jwks.Keys {
+		if key.KeyType != "RSA" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, fmt.Errorf("key %s in %s: %w", key.KeyId, jwksPath, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return nil, fmt.Errorf("key %s in %s: %w", key.KeyId, jwksPath, err)
+		}
+		keys[key.KeyId] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no RSA signing keys in %s", jwksPath)
+	}
+
+	return &OIDCProvider{Issuer: issuer, Audience: audience, keys: keys}, nil
+}
+
+func (o *OIDCProvider) Session(r *http.Request) (*Session, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil, nil
+	}
+	return sessionCache.Get(r.Context(), token, o.verify)
+}
+
+// Returns the session for a valid token, or nil for anything else.
+func (o *OIDCProvider) verify(ctx context.Context, token string) (*Session, error) {
+	claims, err := o.parse(token)
+	if err != nil {
+		return nil, nil
+	}
+
+	user := User{
+		Id:        claims.Subject,
+		Email:     claims.Email,
+		FirstName: claims.GivenName,
+		LastName:  claims.FamilyName,
+		Username:  claims.PreferredUsername,
+		Avatar:    claims.Picture,
+	}
+	if err := rememberUser(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return &Session{
+		Id:        claims.Subject,
+		Active:    true,
+		ExpiresAt: time.Unix(claims.Expiry, 0),
+		Identity:  user.Identity(),
+	}, nil
+}
+
+// Checks the signature, issuer, audience and validity period of a JWT.
+func (o *OIDCProvider) parse(token string) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	// Only RS256, so a token can't pick a weaker algorithm or "none"
+	if header.Algorithm != "RS256" {
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Algorithm)
+	}
+	key, ok := o.keys[header.KeyId]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", header.KeyId)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature); err != nil {
+		return nil, err
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if claims.Issuer != o.Issuer {
+		return nil, fmt.Errorf("wrong issuer %q", claims.Issuer)
+	}
+	if !slices.Contains(claims.Audience, o.Audience) {
+		return nil, fmt.Errorf("wrong audience")
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("missing subject")
+	}
+	if claims.Expiry == 0 || now.After(time.Unix(claims.Expiry, 0).Add(jwtLeeway)) {
+		return nil, fmt.Errorf("token expired")
+	}
+	if claims.NotBefore != 0 && now.Add(jwtLeeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return nil, fmt.Errorf("token not valid yet")
+	}
+	return &claims, nil
+}
+
+func decodeJWTPart(part string, value any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+// Stores or refreshes a user seen in a token.
+func rememberUser(ctx context.Context, user User) error {
+	return store.Users().Save(ctx, user)
+}
+
+func (o *OIDCProvider) Users(ctx context.Context) ([]User, error) {
+	return store.Users().List(ctx)
+}
+
+func (o *OIDCProvider) SearchUsers(ctx context.Context, query string) ([]User, error) {
+	users, err := o.Users(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return searchUsers(users, query), nil
+}
+
+func (o *OIDCProvider) User(ctx context.Context, id string) (*User, error) {
+	user, err := store.Users().Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (o *OIDCProvider) UserByEmail(ctx context.Context, email string) (*User, error) {
+	user, err := store.Users().GetByEmail(ctx, email)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type OutboxMessage struct {
+	Id            string   `json:"id"`
+	To            []string `json:"to"`
+	Message       string   `json:"-"`
+	Status        string   `json:"status"`
+	Attempts      int      `json:"attempts"`
+	LastError     *string  `json:"lastError"`
+	NextAttemptAt string   `json:"nextAttemptAt"`
+	CreatedAt     string   `json:"createdAt"`
+	SentAt        *string  `json:"sentAt"`
+}
+
+// Messages that still fail after this many attempts are dead-lettered.
+var maxMailAttempts = 8
+
+// How long a worker holds a message before another one may pick it up again,
+// in case the process dies mid-send.
+var mailLease = 5 * time.Minute
+
+var mailBatchSize = 10
+
+// Exponential backoff between attempts, starting at 30 seconds and capped at an hour.
+func mailBackoff(attempts int) time.Duration {
+	backoff := 30 * time.Second
+	for i := 1; i < attempts && backoff < time.Hour; i++ {
+		backoff *= 2
+	}
+	return min(backoff, time.Hour)
+}
+
+// Stores a fully built message in the outbox for the mail worker to send.
+func EnqueueMessage(ctx context.Context, tx Store, to []string, message []byte) error {
+	return tx.Outbox().Enqueue(ctx, to, message)
+}
+
+func deliverOutboxMessage(ctx context.Context, message OutboxMessage) {
+	sendErr := sendMessage(message.To, []byte(message.Message))
+	if sendErr == nil {
+		if err := store.Outbox().MarkSent(ctx, message.Id); err != nil {
+			log.Println("Error marking email as sent:", err)
+		}
+		return
+	}
+
+	attempts := message.Attempts + 1
+	status := "pending"
+	if attempts >= maxMailAttempts {
+		status = "dead"
+		log.Printf("Giving up on email %s after %d attempts: %v", message.Id, attempts, sendErr)
+	} else {
+		log.Printf("Error sending email %s (attempt %d): %v", message.Id, attempts, sendErr)
+	}
+
+	err := store.Outbox().MarkFailed(ctx, message.Id, status, attempts, sendErr.Error(), mailBackoff(attempts))
+	if err != nil {
+		log.Println("Error rescheduling email:", err)
+	}
+}
+
+// Sends every due message, a batch at a time. Each message is leased while it
+// is sent, so the worker on another replica won't send it too.
+func DeliverOutbox(ctx context.Context) {
+	for {
+		messages, err := store.Outbox().Claim(ctx, mailBatchSize, mailLease)
+		if err != nil {
+			log.Println("Error claiming outbox messages:", err)
+			return
+		}
+		for _, message := range messages {
+			deliverOutboxMessage(ctx, message)
+		}
+		if len(messages) < mailBatchSize {
+			return
+		}
+	}
+}
+
+// Runs until the process exits, sending queued mail every interval.
+func RunMailWorker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		DeliverOutbox(context.Background())
+		<-ticker.C
+	}
+}
+
+// GET /admin/mail
+func getOutbox(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+	if !isAdmin(session) {
+		http.Error(w, `{"error": "Forbidden"}`, http.StatusForbidden)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "dead"
+	}
+
+	messages, err := store.Outbox().List(r.Context(), status)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
+
+// POST /admin/mail/{id}/retry
+func retryOutboxMessage(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+	if !isAdmin(session) {
+		http.Error(w, `{"error": "Forbidden"}`, http.StatusForbidden)
+		return
+	}
+
+	vars := mux.Vars(r)
+	messageId := vars["id"]
+
+	err := store.Outbox().Retry(r.Context(), messageId)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, `{"error": "Message not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
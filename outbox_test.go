@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Points the outbox at a fresh MemoryStore and MemoryMailer. The returned
+// function moves the store's clock forward.
+func setupOutboxTest(t *testing.T) (*MemoryMailer, func(time.Duration)) {
+	t.Helper()
+
+	oldStore, oldMailer, oldSigner, oldFrom := store, mailer, dkimSigner, fromEmail
+	t.Cleanup(func() {
+		store, mailer, dkimSigner, fromEmail = oldStore, oldMailer, oldSigner, oldFrom
+	})
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	memory := NewMemoryStore()
+	memory.now = func() time.Time { return now }
+
+	memoryMailer := &MemoryMailer{}
+	store = memory
+	mailer = memoryMailer
+	dkimSigner = nil
+	fromEmail = "calendar@example.com"
+
+	return memoryMailer, func(d time.Duration) { now = now.Add(d) }
+}
+
+func outboxMessages(t *testing.T, status string) []OutboxMessage {
+	t.Helper()
+
+	messages, err := store.Outbox().List(context.Background(), status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return messages
+}
+
+func TestDeliverOutboxSendsQueuedMail(t *testing.T) {
+	memoryMailer, _ := setupOutboxTest(t)
+	ctx := context.Background()
+
+	// More than a batch, so the worker has to come back for the rest
+	count := mailBatchSize*2 + 1
+	for i := range count {
+		to := []string{fmt.Sprintf("user%d@example.com", i)}
+		if err := EnqueueMessage(ctx, store, to, []byte("Subject: Hello\r\n\r\nHi\r\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	DeliverOutbox(ctx)
+
+	if sent := memoryMailer.Messages(); len(sent) != count {
+		t.Fatalf("sent %d messages, want %d", len(sent), count)
+	}
+	sent := outboxMessages(t, "sent")
+	if len(sent) != count {
+		t.Fatalf("got %d sent messages in the outbox, want %d", len(sent), count)
+	}
+	for _, message := range sent {
+		if message.Attempts != 1 || message.SentAt == nil || message.LastError != nil {
+			t.Fatalf("got %+v, want one successful attempt", message)
+		}
+	}
+
+	// Sent messages aren't sent again
+	memoryMailer.Reset()
+	DeliverOutbox(ctx)
+	if sent := memoryMailer.Messages(); len(sent) != 0 {
+		t.Fatalf("sent %d messages again, want 0", len(sent))
+	}
+}
+
+func TestDeliverOutboxRetriesWithBackoff(t *testing.T) {
+	memoryMailer, advance := setupOutboxTest(t)
+	ctx := context.Background()
+
+	if err := EnqueueMessage(ctx, store, []string{"user@example.com"}, []byte("Subject: Hello\r\n\r\nHi\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	memoryMailer.Fail(errors.New("connection refused"))
+	DeliverOutbox(ctx)
+
+	pending := outboxMessages(t, "pending")
+	if len(pending) != 1 {
+		t.Fatalf("got %d pending messages, want 1", len(pending))
+	}
+	message := pending[0]
+	if message.Attempts != 1 || message.LastError == nil || *message.LastError != "connection refused" {
+		t.Fatalf("got %+v, want one failed attempt", message)
+	}
+
+	// Each failure doubles the wait before the next attempt
+	for attempts := 1; attempts < 4; attempts++ {
+		backoff := mailBackoff(attempts)
+		if backoff != 30*time.Second<<(attempts-1) {
+			t.Fatalf("backoff after %d attempts is %v", attempts, backoff)
+		}
+
+		advance(backoff - time.Second)
+		DeliverOutbox(ctx)
+		if got := outboxMessages(t, "pending")[0].Attempts; got != attempts {
+			t.Fatalf("retried before the backoff passed: %d attempts, want %d", got, attempts)
+		}
+
+		advance(time.Second)
+		DeliverOutbox(ctx)
+		if got := outboxMessages(t, "pending")[0].Attempts; got != attempts+1 {
+			t.Fatalf("got %d attempts once the backoff passed, want %d", got, attempts+1)
+		}
+	}
+
+	memoryMailer.Fail(nil)
+	advance(mailBackoff(4))
+	DeliverOutbox(ctx)
+
+	if sent := memoryMailer.Messages(); len(sent) != 1 {
+		t.Fatalf("sent %d messages after recovering, want 1", len(sent))
+	}
+	sent := outboxMessages(t, "sent")
+	if len(sent) != 1 || sent[0].Attempts != 5 {
+		t.Fatalf("got %+v, want the message sent on the fifth attempt", sent)
+	}
+}
+
+func TestDeliverOutboxDeadLettersAfterMaxAttempts(t *testing.T) {
+	memoryMailer, advance := setupOutboxTest(t)
+	ctx := context.Background()
+
+	if err := EnqueueMessage(ctx, store, []string{"user@example.com"}, []byte("Subject: Hello\r\n\r\nHi\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	memoryMailer.Fail(errors.New("mailbox unavailable"))
+	for attempts := 1; attempts <= maxMailAttempts; attempts++ {
+		DeliverOutbox(ctx)
+		advance(mailBackoff(attempts))
+	}
+
+	if pending := outboxMessages(t, "pending"); len(pending) != 0 {
+		t.Fatalf("got %d pending messages, want 0", len(pending))
+	}
+	dead := outboxMessages(t, "dead")
+	if len(dead) != 1 || dead[0].Attempts != maxMailAttempts {
+		t.Fatalf("got %+v, want the message dead-lettered after %d attempts", dead, maxMailAttempts)
+	}
+
+	// Dead messages stay put until an admin retries them
+	memoryMailer.Fail(nil)
+	advance(24 * time.Hour)
+	DeliverOutbox(ctx)
+	if sent := memoryMailer.Messages(); len(sent) != 0 {
+		t.Fatalf("sent %d dead messages, want 0", len(sent))
+	}
+
+	if err := store.Outbox().Retry(ctx, dead[0].Id); err != nil {
+		t.Fatal(err)
+	}
+	DeliverOutbox(ctx)
+	if sent := memoryMailer.Messages(); len(sent) != 1 {
+		t.Fatalf("sent %d messages after retrying, want 1", len(sent))
+	}
+}
+
+func TestMailBackoffIsCapped(t *testing.T) {
+	if backoff := mailBackoff(maxMailAttempts * 10); backoff != time.Hour {
+		t.Fatalf("got %v, want the one hour cap", backoff)
+	}
+}
+
+func TestPostgresOutboxClaimSkipsLockedMessages(t *testing.T) {
+	database := testDatabase(t)
+	oldPool := pool
+	t.Cleanup(func() { pool = oldPool })
+	pool = database
+	ctx := context.Background()
+	if _, err := MigrateUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	outbox := &PostgresOutboxStore{db: database}
+	for i := range 3 {
+		to := []string{fmt.Sprintf("user%d@example.com", i)}
+		if err := outbox.Enqueue(ctx, to, []byte("Subject: Hello\r\n\r\nHi\r\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Another worker's claim that hasn't committed yet
+	tx, err := database.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	first, err := (&PostgresOutboxStore{db: tx}).Claim(ctx, 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 2 {
+		t.Fatalf("claimed %d messages, want 2", len(first))
+	}
+
+	second, err := outbox.Claim(ctx, 10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second) != 1 || second[0].Id == first[0].Id || second[0].Id == first[1].Id {
+		t.Fatalf("got %+v, want only the message the other worker didn't lock", second)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Leased messages aren't claimed again until the lease runs out
+	third, err := outbox.Claim(ctx, 10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(third) != 0 {
+		t.Fatalf("claimed %d leased messages, want 0", len(third))
+	}
+}
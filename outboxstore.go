@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type PostgresOutboxStore struct {
+	db Querier
+}
+
+// Reads the columns listed in outboxColumns, and the message if withMessage.
+func scanOutboxMessage(row scanner, withMessage bool) (OutboxMessage, error) {
+	var message OutboxMessage
+	var recipients string
+	var lastError sql.NullString
+	var nextAttemptAt, createdAt time.Time
+	var sentAt sql.NullTime
+	dest := []any{&message.Id, &recipients, &message.Status, &message.Attempts, &lastError, &nextAttemptAt, &createdAt, &sentAt}
+	if withMessage {
+		dest = append(dest, &message.Message)
+	}
+	if err := row.Scan(dest...); err != nil {
+		return message, err
+	}
+
+	message.LastError = nullString(lastError)
+	message.NextAttemptAt = timestamp(nextAttemptAt)
+	message.CreatedAt = timestamp(createdAt)
+	message.SentAt = nullTimestamp(sentAt)
+	err := json.Unmarshal([]byte(recipients), &message.To)
+	return message, err
+}
+
+var outboxColumns = "id, recipients, status, attempts, last_error, next_attempt_at, created_at, sent_at"
+
+func (s *PostgresOutboxStore) Enqueue(ctx context.Context, to []string, message []byte) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	recipients, err := json.Marshal(to)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(ctx,
+		`
+		INSERT INTO mail_outbox (id, recipients, message)
+		VALUES ($1, $2, $3)
+		`,
+		uuid.New().String(),
+		string(recipients),
+		string(message),
+	)
+	return err
+}
+
+// SKIP LOCKED lets several replicas run workers without picking up the same
+// message.
+func (s *PostgresOutboxStore) Claim(ctx context.Context, limit int, lease time.Duration) ([]OutboxMessage, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	rows, err := s.db.Query(ctx,
+		`
+		UPDATE mail_outbox
+		SET next_attempt_at = CURRENT_TIMESTAMP + $2 * INTERVAL '1 second'
+		WHERE id IN (
+			SELECT id FROM mail_outbox
+			WHERE status = 'pending' AND next_attempt_at <= CURRENT_TIMESTAMP
+			ORDER BY next_attempt_at ASC
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+outboxColumns+`, message
+		`,
+		limit,
+		lease.Seconds(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []OutboxMessage{}
+	for rows.Next() {
+		message, err := scanOutboxMessage(rows, true)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+func (s *PostgresOutboxStore) MarkSent(ctx context.Context, id string) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	_, err := s.db.Exec(ctx,
+		`
+		UPDATE mail_outbox
+		SET status = 'sent', attempts = attempts + 1, last_error = NULL, sent_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		`,
+		id,
+	)
+	return err
+}
+
+func (s *PostgresOutboxStore) MarkFailed(ctx context.Context, id string, status string, attempts int, lastError string, retryIn time.Duration) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	_, err := s.db.Exec(ctx,
+		`
+		UPDATE mail_outbox
+		SET status = $2, attempts = $3, last_error = $4, next_attempt_at = CURRENT_TIMESTAMP + $5 * INTERVAL '1 second'
+		WHERE id = $1
+		`,
+		id,
+		status,
+		attempts,
+		lastError,
+		retryIn.Seconds(),
+	)
+	return err
+}
+
+func (s *PostgresOutboxStore) List(ctx context.Context, status string) ([]OutboxMessage, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	rows, err := s.db.Query(ctx,
+		`
+		SELECT `+outboxColumns+`
+		FROM mail_outbox
+		WHERE status = $1
+		ORDER BY created_at DESC
+		LIMIT 100
+		`,
+		status,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []OutboxMessage{}
+	for rows.Next() {
+		message, err := scanOutboxMessage(rows, false)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+func (s *PostgresOutboxStore) Retry(ctx context.Context, id string) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrNotFound
+	}
+
+	return expectRows(s.db.Exec(ctx,
+		`
+		UPDATE mail_outbox
+		SET status = 'pending', attempts = 0, next_attempt_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status != 'sent'
+		`,
+		id,
+	))
+}
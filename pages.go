@@ -0,0 +1,32 @@
+package main
+
+import (
+	"embed"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+//go:embed templates/pages
+var pageTemplateFS embed.FS
+
+var confirmationTemplate = template.Must(template.ParseFS(pageTemplateFS, "templates/pages/confirm.html"))
+
+// A page asking the user to confirm what a link from an email does, already
+// translated into their locale.
+type Confirmation struct {
+	Locale  string
+	Title   string
+	Message string
+	Button  string
+}
+
+// Links in emails only show a confirmation, and the change is made by the POST
+// its form sends back to the same URL. Mail scanners and link previews follow
+// links, and would otherwise unsubscribe people or answer invitations for them.
+func renderConfirmation(w http.ResponseWriter, confirmation Confirmation) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := confirmationTemplate.Execute(w, confirmation); err != nil {
+		log.Println("Error rendering confirmation page:", err)
+	}
+}
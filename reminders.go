@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type Reminder struct {
+	Id            string  `json:"id"`
+	UserId        string  `json:"userId"`
+	EventId       *string `json:"eventId"`
+	RecurrenceId  *string `json:"recurrenceId"`
+	CalendarId    *string `json:"calendarId"`
+	TaskId        *string `json:"taskId"`
+	MinutesBefore int     `json:"minutesBefore"`
+	Method        string  `json:"method"`
+	Overdue       bool    `json:"overdue"`
+	SnoozedUntil  *string `json:"snoozedUntil"`
+}
+
+// Reminders for events that started longer ago than this are never fired, so
+// a server that was down for a while doesn't flood people with stale reminders.
+var reminderGracePeriod = 15
+
+func validReminder(reminder Reminder) bool {
+	return reminder.MinutesBefore >= 0 && (reminder.Method == "email" || reminder.Method == "app")
+}
+
+// Stores the reminders requested while creating an event. Reminders on a
+// recurring event apply to the whole series.
+func createReminders(ctx context.Context, tx Store, userId string, event Event, reminders []Reminder) ([]Reminder, error) {
+	created := []Reminder{}
+	for _, reminder := range reminders {
+		if !validReminder(reminder) {
+			continue
+		}
+
+		reminder.Id = uuid.New().String()
+		reminder.UserId = userId
+		reminder.CalendarId = nil
+		if event.RecurrenceId != "" {
+			reminder.EventId = nil
+			reminder.RecurrenceId = &event.RecurrenceId
+		} else {
+			reminder.EventId = &event.Id
+			reminder.RecurrenceId = nil
+		}
+
+		reminder.TaskId = nil
+		reminder.Overdue = false
+		reminder.SnoozedUntil = nil
+		created = append(created, reminder)
+	}
+
+	if err := tx.Reminders().Create(ctx, created...); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// GET /events/{id}/reminders
+func getEventReminders(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+
+	vars := mux.Vars(r)
+	eventId := vars["id"]
+
+	if !canAccessEvent(r.Context(), session.Identity.Id, eventId) {
+		http.Error(w, `{"error": "Event not found"}`, http.StatusNotFound)
+		return
+	}
+
+	event, err := store.Events().Get(r.Context(), eventId)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, `{"error": "Event not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	reminders, err := store.Reminders().ListForEvent(r.Context(), session.Identity.Id, event)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reminders)
+}
+
+// POST /events/{id}/reminders
+func createEventReminder(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+
+	vars := mux.Vars(r)
+	eventId := vars["id"]
+	recurring := r.URL.Query().Get("recurring")
+
+	if !canAccessEvent(r.Context(), session.Identity.Id, eventId) {
+		http.Error(w, `{"error": "Event not found"}`, http.StatusNotFound)
+		return
+	}
+
+	var reminder Reminder
+	if err := json.NewDecoder(r.Body).Decode(&reminder); err != nil {
+		http.Error(w, `{"error": "Invalid request"}`, http.StatusBadRequest)
+		return
+	}
+	if !validReminder(reminder) {
+		http.Error(w, `{"error": "Invalid reminder"}`, http.StatusBadRequest)
+		return
+	}
+
+	event, err := store.Events().Get(r.Context(), eventId)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, `{"error": "Event not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// Only attach the reminder to the whole series when asked to
+	if recurring != "true" {
+		event.RecurrenceId = ""
+	}
+
+	created, err := createReminders(r.Context(), store, session.Identity.Id, event, []Reminder{reminder})
+	if err != nil || len(created) == 0 {
+		log.Println(err)
+		http.Error(w, `{"error": "Error creating reminder"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(created[0])
+}
+
+// GET /calendars/{id}/reminders
+func getCalendarReminders(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+
+	vars := mux.Vars(r)
+	calendarId := vars["id"]
+
+	if !isCalendarMember(r.Context(), session.Identity.Id, calendarId) {
+		http.Error(w, `{"error": "Calendar not found"}`, http.StatusNotFound)
+		return
+	}
+
+	reminders, err := store.Reminders().ListForCalendar(r.Context(), session.Identity.Id, calendarId)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reminders)
+}
+
+// POST /calendars/{id}/reminders
+func createCalendarReminder(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+
+	vars := mux.Vars(r)
+	calendarId := vars["id"]
+
+	if !isCalendarMember(r.Context(), session.Identity.Id, calendarId) {
+		http.Error(w, `{"error": "Calendar not found"}`, http.StatusNotFound)
+		return
+	}
+
+	var reminder Reminder
+	if err := json.NewDecoder(r.Body).Decode(&reminder); err != nil {
+		http.Error(w, `{"error": "Invalid request"}`, http.StatusBadRequest)
+		return
+	}
+	if !validReminder(reminder) {
+		http.Error(w, `{"error": "Invalid reminder"}`, http.StatusBadRequest)
+		return
+	}
+
+	reminder.Id = uuid.New().String()
+	reminder.UserId = session.Identity.Id
+	reminder.EventId = nil
+	reminder.RecurrenceId = nil
+	reminder.CalendarId = &calendarId
+	reminder.TaskId = nil
+	reminder.Overdue = false
+	reminder.SnoozedUntil = nil
+
+	if err := store.Reminders().Create(r.Context(), reminder); err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Error creating reminder"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reminder)
+}
+
+// DELETE /reminders/{id}
+func deleteReminder(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+
+	vars := mux.Vars(r)
+	reminderId := vars["id"]
+
+	err := store.Reminders().Delete(r.Context(), reminderId, session.Identity.Id)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, `{"error": "Reminder not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Error deleting reminder"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// GET /tasks/{id}/reminders
+func getTaskReminders(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+
+	vars := mux.Vars(r)
+	taskId := vars["id"]
+
+	reminders, err := store.Reminders().ListForTask(r.Context(), session.Identity.Id, taskId)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reminders)
+}
+
+// POST /tasks/{id}/reminders
+func createTaskReminder(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+
+	vars := mux.Vars(r)
+	taskId := vars["id"]
+
+	if !ownsTask(r.Context(), session.Identity.Id, taskId) {
+		http.Error(w, `{"error": "Task not found"}`, http.StatusNotFound)
+		return
+	}
+
+	var reminder Reminder
+	if err := json.NewDecoder(r.Body).Decode(&reminder); err != nil {
+		http.Error(w, `{"error": "Invalid request"}`, http.StatusBadRequest)
+		return
+	}
+	if !validReminder(reminder) {
+		http.Error(w, `{"error": "Invalid reminder"}`, http.StatusBadRequest)
+		return
+	}
+
+	reminder.Id = uuid.New().String()
+	reminder.UserId = session.Identity.Id
+	reminder.EventId = nil
+	reminder.RecurrenceId = nil
+	reminder.CalendarId = nil
+	reminder.TaskId = &taskId
+	reminder.SnoozedUntil = nil
+	// Overdue notices fire once the deadline passes
+	if reminder.Overdue {
+		reminder.MinutesBefore = 0
+	}
+
+	if err := store.Reminders().Create(r.Context(), reminder); err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Error creating reminder"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reminder)
+}
+
+// POST /reminders/{id}/snooze
+func snoozeReminder(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+
+	vars := mux.Vars(r)
+	reminderId := vars["id"]
+
+	var body struct {
+		Minutes int `json:"minutes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, `{"error": "Invalid request"}`, http.StatusBadRequest)
+		return
+	}
+	if body.Minutes <= 0 {
+		http.Error(w, `{"error": "Snooze must be at least one minute"}`, http.StatusBadRequest)
+		return
+	}
+
+	reminder, err := store.Reminders().Snooze(r.Context(), reminderId, session.Identity.Id, body.Minutes)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, `{"error": "Reminder not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reminder)
+}
+
+// Clears outstanding snoozes and unread reminder notifications for a task once it is completed.
+func suppressTaskReminders(ctx context.Context, tx Store, userId string, taskId string) error {
+	if err := tx.Reminders().ClearSnoozes(ctx, taskId, userId); err != nil {
+		return err
+	}
+	return tx.Notifications().MarkTaskRead(ctx, taskId, userId)
+}
+
+// A reminder to deliver for one event or task. Claiming it records the
+// delivery before it is sent, so it fires at most once even with several
+// replicas or a restart mid-tick.
+type dueReminder struct {
+	ReminderId string
+	TargetId   string
+	UserId     string
+	Method     string
+	Overdue    bool
+	Title      string
+	Date       time.Time
+	FireAt     time.Time
+	IsTask     bool
+}
+
+// Stores the notification or queues the email in the same transaction as the
+// claim, so a delivered reminder is never lost or repeated.
+func deliverReminder(ctx context.Context, tx Store, reminder dueReminder) error {
+	settings, err := tx.Settings().Get(ctx, reminder.UserId)
+	if errors.Is(err, ErrNotFound) {
+		settings = defaultSettings(reminder.UserId)
+	} else if err != nil {
+		return err
+	}
+
+	// In the recipient's time zone, which the zone abbreviation names
+	date := reminder.Date.In(settings.Location()).Format("Mon, 02 Jan 2006 3:04 PM MST")
+	notification := Notification{
+		UserId:     reminder.UserId,
+		ReminderId: &reminder.ReminderId,
+	}
+
+	if !reminder.IsTask {
+		notification.Title = fmt.Sprintf("Reminder: %s", reminder.Title)
+		notification.Body = fmt.Sprintf("%s starts at %s", reminder.Title, date)
+		notification.EventId = &reminder.TargetId
+	} else if reminder.Overdue {
+		notification.Title = fmt.Sprintf("Overdue: %s", reminder.Title)
+		notification.Body = fmt.Sprintf("%s was due at %s", reminder.Title, date)
+		notification.TaskId = &reminder.TargetId
+	} else {
+		notification.Title = fmt.Sprintf("Reminder: %s", reminder.Title)
+		notification.Body = fmt.Sprintf("%s is due at %s", reminder.Title, date)
+		notification.TaskId = &reminder.TargetId
+	}
+
+	if reminder.Method == "app" || !settings.EmailReminders {
+		return Notify(ctx, tx, notification)
+	}
+
+	// Emails need an unsubscribe token, which only stored settings have
+	if settings.UnsubscribeToken == "" {
+		settings, err = tx.Settings().Ensure(ctx, reminder.UserId)
+		if err != nil {
+			return err
+		}
+	}
+
+	user := GetUser(ctx, reminder.UserId)
+	if user == nil || user.Email == "" {
+		log.Printf("No email address for user %s, skipping reminder %s", reminder.UserId, reminder.ReminderId)
+		return nil
+	}
+
+	unsubscribe := unsubscribeUrl(settings, "reminders")
+	message, err := OutgoingMail{
+		To:          []string{user.Email},
+		Subject:     notification.Title,
+		Text:        fmt.Sprintf("%s\n\n--\nStop reminder emails: %s", notification.Body, unsubscribe),
+		Unsubscribe: unsubscribe,
+	}.Bytes()
+	if err != nil {
+		return err
+	}
+	return EnqueueMessage(ctx, tx, []string{user.Email}, message)
+}
+
+func DeliverReminders(ctx context.Context) {
+	due, err := store.Reminders().DueForEvents(ctx)
+	if err != nil {
+		log.Println("Error finding due event reminders:", err)
+	}
+	dueTasks, err := store.Reminders().DueForTasks(ctx)
+	if err != nil {
+		log.Println("Error finding due task reminders:", err)
+	}
+
+	for _, reminder := range append(due, dueTasks...) {
+		err := store.Transaction(ctx, func(tx Store) error {
+			claimed, err := tx.Reminders().Claim(ctx, reminder)
+			if err != nil || !claimed {
+				return err
+			}
+			return deliverReminder(ctx, tx, reminder)
+		})
+		if err != nil {
+			log.Printf("Error delivering reminder %s: %v", reminder.ReminderId, err)
+		}
+	}
+}
+
+// Runs until the process exits, checking for due reminders every interval.
+func RunReminderScheduler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		DeliverReminders(context.Background())
+		<-ticker.C
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemindersShowTimesInTheUsersTimezone(t *testing.T) {
+	setupTestServer(t)
+	ctx := context.Background()
+
+	settings := defaultSettings(devUser.Id)
+	settings.Timezone = "America/New_York"
+	if err := store.Settings().Save(ctx, settings); err != nil {
+		t.Fatal(err)
+	}
+
+	reminder := dueReminder{
+		ReminderId: "reminder",
+		TargetId:   "event",
+		UserId:     devUser.Id,
+		Method:     "app",
+		Title:      "Lunch",
+		Date:       time.Date(2024, 3, 1, 17, 0, 0, 0, time.UTC),
+	}
+	want := "Lunch starts at Fri, 01 Mar 2024 12:00 PM EST"
+
+	if err := deliverReminder(ctx, store, reminder); err != nil {
+		t.Fatal(err)
+	}
+	notifications, err := store.Notifications().ListForUser(ctx, devUser.Id, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notifications) != 1 || notifications[0].Body != want {
+		t.Fatalf("got %+v, want one notification saying %q", notifications, want)
+	}
+
+	reminder.Method = "email"
+	if err := deliverReminder(ctx, store, reminder); err != nil {
+		t.Fatal(err)
+	}
+	DeliverOutbox(ctx)
+	sent := mailer.(*MemoryMailer).Messages()
+	if len(sent) != 1 || !strings.Contains(string(sent[0].Message), want) {
+		t.Fatalf("got %+v, want one email saying %q", sent, want)
+	}
+}
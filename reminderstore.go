@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+type PostgresReminderStore struct {
+	db Querier
+}
+
+var reminderColumns = "id, user_id, event_id, recurrence_id, calendar_id, task_id, minutes_before, method, overdue, snoozed_until"
+
+func scanReminder(row scanner) (Reminder, error) {
+	var reminder Reminder
+	var eventId, recurrenceId, calendarId, taskId sql.NullString
+	var snoozedUntil sql.NullTime
+	err := row.Scan(
+		&reminder.Id,
+		&reminder.UserId,
+		&eventId,
+		&recurrenceId,
+		&calendarId,
+		&taskId,
+		&reminder.MinutesBefore,
+		&reminder.Method,
+		&reminder.Overdue,
+		&snoozedUntil,
+	)
+	reminder.EventId = nullString(eventId)
+	reminder.RecurrenceId = nullString(recurrenceId)
+	reminder.CalendarId = nullString(calendarId)
+	reminder.TaskId = nullString(taskId)
+	reminder.SnoozedUntil = nullTimestamp(snoozedUntil)
+	return reminder, err
+}
+
+func (s *PostgresReminderStore) list(ctx context.Context, query string, args ...any) ([]Reminder, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reminders := []Reminder{}
+	for rows.Next() {
+		reminder, err := scanReminder(rows)
+		if err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, reminder)
+	}
+	return reminders, rows.Err()
+}
+
+func (s *PostgresReminderStore) ListForEvent(ctx context.Context, userId string, event Event) ([]Reminder, error) {
+	return s.list(ctx,
+		`
+		SELECT `+reminderColumns+`
+		FROM reminders
+		WHERE user_id = $1
+		AND (event_id::text = $2 OR recurrence_id::text = $3)
+		ORDER BY minutes_before DESC
+		`,
+		userId,
+		event.Id,
+		event.RecurrenceId,
+	)
+}
+
+func (s *PostgresReminderStore) ListForCalendar(ctx context.Context, userId string, calendarId string) ([]Reminder, error) {
+	return s.list(ctx,
+		`
+		SELECT `+reminderColumns+`
+		FROM reminders
+		WHERE user_id = $1 AND calendar_id::text = $2
+		ORDER BY minutes_before DESC
+		`,
+		userId,
+		calendarId,
+	)
+}
+
+func (s *PostgresReminderStore) ListForTask(ctx context.Context, userId string, taskId string) ([]Reminder, error) {
+	return s.list(ctx,
+		`
+		SELECT `+reminderColumns+`
+		FROM reminders
+		WHERE user_id = $1 AND task_id::text = $2
+		ORDER BY overdue ASC, minutes_before DESC
+		`,
+		userId,
+		taskId,
+	)
+}
+
+func (s *PostgresReminderStore) Create(ctx context.Context, reminders ...Reminder) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	rows := make([][]any, 0, len(reminders))
+	for _, reminder := range reminders {
+		rows = append(rows, []any{
+			reminder.Id,
+			reminder.UserId,
+			reminder.EventId,
+			reminder.RecurrenceId,
+			reminder.CalendarId,
+			reminder.TaskId,
+			reminder.MinutesBefore,
+			reminder.Method,
+			reminder.Overdue,
+		})
+	}
+
+	columns := []string{"id", "user_id", "event_id", "recurrence_id", "calendar_id", "task_id", "minutes_before", "method", "overdue"}
+	return withTransaction(ctx, s.db, func(q Querier) error {
+		return insertRows(ctx, q, "reminders", columns, rows, "")
+	})
+}
+
+func (s *PostgresReminderStore) Delete(ctx context.Context, id string, userId string) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrNotFound
+	}
+
+	return expectRows(s.db.Exec(ctx,
+		`
+		DELETE FROM reminders
+		WHERE id = $1 AND user_id = $2
+		`,
+		id,
+		userId,
+	))
+}
+
+// Only task reminders can be snoozed, since a series reminder has a different
+// fire time for every occurrence.
+func (s *PostgresReminderStore) Snooze(ctx context.Context, id string, userId string, minutes int) (Reminder, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return Reminder{}, ErrNotFound
+	}
+
+	reminder, err := scanReminder(s.db.QueryRow(ctx,
+		`
+		UPDATE reminders
+		SET snoozed_until = CURRENT_TIMESTAMP + $3 * INTERVAL '1 minute'
+		WHERE id = $1 AND user_id = $2 AND task_id IS NOT NULL
+		RETURNING `+reminderColumns,
+		id,
+		userId,
+		minutes,
+	))
+	if err == pgx.ErrNoRows {
+		return Reminder{}, ErrNotFound
+	}
+	return reminder, err
+}
+
+func (s *PostgresReminderStore) ClearSnoozes(ctx context.Context, taskId string, userId string) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	_, err := s.db.Exec(ctx,
+		`
+		UPDATE reminders
+		SET snoozed_until = NULL
+		WHERE task_id = $1 AND user_id = $2
+		`,
+		taskId,
+		userId,
+	)
+	return err
+}
+
+func (s *PostgresReminderStore) due(ctx context.Context, query string) ([]dueReminder, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	rows, err := s.db.Query(ctx, query, reminderGracePeriod)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	due := []dueReminder{}
+	for rows.Next() {
+		var reminder dueReminder
+		err := rows.Scan(
+			&reminder.ReminderId,
+			&reminder.TargetId,
+			&reminder.UserId,
+			&reminder.Method,
+			&reminder.Overdue,
+			&reminder.Title,
+			&reminder.Date,
+			&reminder.FireAt,
+			&reminder.IsTask,
+		)
+		if err != nil {
+			return nil, err
+		}
+		due = append(due, reminder)
+	}
+	return due, rows.Err()
+}
+
+// Event reminders match their event or every occurrence of their series, and
+// calendar defaults only apply to events the user hasn't set reminders on.
+func (s *PostgresReminderStore) DueForEvents(ctx context.Context) ([]dueReminder, error) {
+	return s.due(ctx,
+		`
+		SELECT reminders.id, events.id::text, reminders.user_id, reminders.method, FALSE,
+			events.title, events.date, events.date - reminders.minutes_before * INTERVAL '1 minute', FALSE
+		FROM reminders
+		JOIN events ON events.id = reminders.event_id
+			OR events.recurrence_id = reminders.recurrence_id
+			OR (
+				events.calendar_id = reminders.calendar_id
+				AND NOT EXISTS (
+					SELECT 1 FROM reminders AS overrides
+					WHERE overrides.user_id = reminders.user_id
+					AND (overrides.event_id = events.id OR overrides.recurrence_id = events.recurrence_id)
+				)
+			)
+		WHERE events.date - reminders.minutes_before * INTERVAL '1 minute' <= CURRENT_TIMESTAMP
+		AND events.date > CURRENT_TIMESTAMP - $1 * INTERVAL '1 minute'
+		AND events.calendar_id IN (SELECT calendar_id FROM calendar_members WHERE user_id = reminders.user_id)
+		AND NOT EXISTS (
+			SELECT 1 FROM reminder_deliveries
+			WHERE reminder_deliveries.reminder_id = reminders.id
+			AND reminder_deliveries.target_id = events.id::text
+			AND reminder_deliveries.fire_at = events.date - reminders.minutes_before * INTERVAL '1 minute'
+		)
+		`,
+	)
+}
+
+// A snoozed reminder fires at the snooze time instead, and reminders stop
+// firing as soon as the task is completed.
+func (s *PostgresReminderStore) DueForTasks(ctx context.Context) ([]dueReminder, error) {
+	return s.due(ctx,
+		`
+		SELECT reminder_id, target_id, user_id, method, overdue, title, date, fire_at, TRUE FROM (
+			SELECT reminders.id AS reminder_id, tasks.id::text AS target_id, reminders.user_id AS user_id, reminders.method AS method,
+				reminders.overdue AS overdue, tasks.title AS title, tasks.deadline AS date,
+				COALESCE(reminders.snoozed_until, tasks.deadline - reminders.minutes_before * INTERVAL '1 minute') AS fire_at,
+				reminders.snoozed_until IS NOT NULL AS snoozed
+			FROM reminders
+			JOIN tasks ON tasks.id = reminders.task_id AND tasks.user_id = reminders.user_id
+			WHERE NOT tasks.completed AND tasks.deadline IS NOT NULL
+		) AS due
+		WHERE fire_at <= CURRENT_TIMESTAMP
+		AND (overdue OR snoozed OR date > CURRENT_TIMESTAMP - $1 * INTERVAL '1 minute')
+		AND NOT EXISTS (
+			SELECT 1 FROM reminder_deliveries
+			WHERE reminder_deliveries.reminder_id = due.reminder_id
+			AND reminder_deliveries.target_id = due.target_id
+			AND reminder_deliveries.fire_at = due.fire_at
+		)
+		`,
+	)
+}
+
+// Moving the event or deadline, or snoozing, changes the fire time, which lets
+// the reminder be claimed again.
+func (s *PostgresReminderStore) Claim(ctx context.Context, reminder dueReminder) (bool, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	result, err := s.db.Exec(ctx,
+		`
+		INSERT INTO reminder_deliveries (reminder_id, target_id, fire_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+		`,
+		reminder.ReminderId,
+		reminder.TargetId,
+		reminder.FireAt,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return result.RowsAffected() == 1, nil
+}
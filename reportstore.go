@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+type PostgresReportStore struct {
+	db Querier
+}
+
+func (s *PostgresReportStore) entries(ctx context.Context, query string, args ...any) ([]TimeReportEntry, error) {
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []TimeReportEntry{}
+	for rows.Next() {
+		var entry TimeReportEntry
+		if err := rows.Scan(&entry.Id, &entry.Name, &entry.Minutes); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s *PostgresReportStore) TimeReport(ctx context.Context, userId string, from time.Time, to time.Time) (TimeReport, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	report := TimeReport{
+		From: from.Format(time.RFC3339),
+		To:   to.Format(time.RFC3339),
+	}
+
+	// Entries that straddle the range only count the part inside it
+	clipped := `
+		SELECT time_entries.task_id AS task_id,
+			(EXTRACT(EPOCH FROM LEAST(COALESCE(stopped_at, CURRENT_TIMESTAMP), $3) - GREATEST(started_at, $2)) / 60) AS minutes
+		FROM time_entries
+		WHERE user_id = $1
+		AND started_at < $3
+		AND COALESCE(stopped_at, CURRENT_TIMESTAMP) > $2
+		`
+
+	var err error
+	report.Calendars, err = s.entries(ctx,
+		`
+		SELECT calendars.id::text, calendars.name, SUM(entries.minutes)::int AS minutes
+		FROM (`+clipped+`) AS entries
+		JOIN tasks ON tasks.id = entries.task_id
+		JOIN calendars ON calendars.id::text = tasks.calendar_id
+		GROUP BY calendars.id, calendars.name
+		ORDER BY minutes DESC
+		`,
+		userId, from, to,
+	)
+	if err != nil {
+		return report, err
+	}
+
+	report.Labels, err = s.entries(ctx,
+		`
+		SELECT labels.id::text, labels.name, SUM(entries.minutes)::int AS minutes
+		FROM (`+clipped+`) AS entries
+		JOIN task_labels ON task_labels.task_id = entries.task_id
+		JOIN labels ON labels.id = task_labels.label_id
+		WHERE labels.user_id = $1
+		GROUP BY labels.id, labels.name
+		ORDER BY minutes DESC
+		`,
+		userId, from, to,
+	)
+	if err != nil {
+		return report, err
+	}
+
+	err = s.db.QueryRow(ctx,
+		`SELECT COALESCE(SUM(entries.minutes), 0)::int FROM (`+clipped+`) AS entries`,
+		userId, from, to,
+	).Scan(&report.TotalMinutes)
+	return report, err
+}
+
+func (s *PostgresReportStore) Analytics(ctx context.Context, userId string, from time.Time, to time.Time, location *time.Location) (Analytics, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	analytics := Analytics{
+		From:     from.Format(time.RFC3339),
+		To:       to.Format(time.RFC3339),
+		Timezone: location.String(),
+	}
+
+	events := `
+		SELECT events.id AS id, events.calendar_id AS calendar_id, events.date AS date, events.duration AS duration
+		FROM events
+		WHERE calendar_id IN (SELECT calendar_id FROM calendar_members WHERE user_id = $1)
+		AND date >= $2 AND date < $3
+		`
+
+	var err error
+	analytics.Calendars, err = s.entries(ctx,
+		`
+		SELECT calendars.id::text, calendars.name, SUM(events.duration)::int AS minutes
+		FROM (`+events+`) AS events
+		JOIN calendars ON calendars.id = events.calendar_id
+		GROUP BY calendars.id, calendars.name
+		ORDER BY minutes DESC
+		`,
+		userId, from, to,
+	)
+	if err != nil {
+		return analytics, err
+	}
+
+	analytics.Labels, err = s.entries(ctx,
+		`
+		SELECT labels.id::text, labels.name, SUM(events.duration)::int AS minutes
+		FROM (`+events+`) AS events
+		JOIN event_labels ON event_labels.event_id = events.id
+		JOIN labels ON labels.id = event_labels.label_id
+		WHERE labels.user_id = $1
+		GROUP BY labels.id, labels.name
+		ORDER BY minutes DESC
+		`,
+		userId, from, to,
+	)
+	if err != nil {
+		return analytics, err
+	}
+
+	analytics.Weekdays = emptyWeekdays()
+	rows, err := s.db.Query(ctx,
+		`
+		SELECT EXTRACT(ISODOW FROM events.date AT TIME ZONE $4)::int AS day, COUNT(*), SUM(events.duration)::int
+		FROM (`+events+`) AS events
+		GROUP BY day
+		`,
+		userId, from, to, location.String(),
+	)
+	if err != nil {
+		return analytics, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var day, count, minutes int
+		if err := rows.Scan(&day, &count, &minutes); err != nil {
+			return analytics, err
+		}
+		// ISO weekdays run Monday (1) through Sunday (7)
+		if day >= 1 && day <= 7 {
+			analytics.Weekdays[day-1].Events = count
+			analytics.Weekdays[day-1].Minutes = minutes
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return analytics, err
+	}
+
+	err = s.db.QueryRow(ctx,
+		`
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE completed)
+		FROM tasks
+		WHERE user_id = $1 AND deadline >= $2 AND deadline < $3
+		`,
+		userId, from, to,
+	).Scan(&analytics.Tasks.Due, &analytics.Tasks.Completed)
+	if err != nil {
+		return analytics, err
+	}
+
+	err = s.db.QueryRow(ctx,
+		`
+		SELECT COUNT(*) FROM tasks
+		WHERE user_id = $1 AND NOT completed AND deadline < CURRENT_TIMESTAMP
+		`,
+		userId,
+	).Scan(&analytics.Tasks.Overdue)
+	if err != nil {
+		return analytics, err
+	}
+
+	// Average error is the mean of |actual - estimate| / estimate over completed tasks
+	accuracy := &analytics.EstimateAccuracy
+	err = s.db.QueryRow(ctx,
+		`
+		WITH tracked AS (
+			SELECT task_id, SUM(`+timeEntryMinutes+`) AS minutes
+			FROM time_entries
+			WHERE user_id = $1
+			GROUP BY task_id
+		)
+		SELECT COUNT(*),
+			COALESCE(SUM(tasks.duration), 0)::int,
+			COALESCE(SUM(tracked.minutes), 0)::int,
+			COALESCE(AVG(ABS(tracked.minutes - tasks.duration)::float / tasks.duration), 0)
+		FROM tasks
+		JOIN tracked ON tracked.task_id = tasks.id
+		WHERE tasks.user_id = $1 AND tasks.completed AND tasks.duration > 0
+		AND tasks.deadline >= $2 AND tasks.deadline < $3
+		`,
+		userId, from, to,
+	).Scan(&accuracy.Tasks, &accuracy.EstimatedMinutes, &accuracy.ActualMinutes, &accuracy.AverageError)
+	return analytics, err
+}
@@ -0,0 +1,627 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+var (
+	devUser  = developmentUsers[0].User
+	testUser = developmentUsers[1].User
+)
+
+// Swaps the globals the handlers use for in-memory stand-ins, restoring them
+// when the test ends.
+func setupTestServer(t *testing.T) *mux.Router {
+	t.Helper()
+
+	provider, err := NewDevProvider("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldEnvironment, oldProvider, oldStore, oldMailer := environment, identityProvider, store, mailer
+	oldCache, oldSecret, oldAdmins, oldFrom := sessionCache, webhookSecret, adminUserIds, fromEmail
+	oldTokenCache, oldTokenUseInterval := tokenSessionCache, tokenUseInterval
+	t.Cleanup(func() {
+		environment, identityProvider, store, mailer = oldEnvironment, oldProvider, oldStore, oldMailer
+		sessionCache, webhookSecret, adminUserIds, fromEmail = oldCache, oldSecret, oldAdmins, oldFrom
+		tokenSessionCache, tokenUseInterval = oldTokenCache, oldTokenUseInterval
+	})
+
+	environment = "development"
+	identityProvider = provider
+	store = NewMemoryStore()
+	mailer = &MemoryMailer{}
+	sessionCache = NewSessionCache(time.Minute, time.Second, 100)
+	tokenSessionCache = NewSessionCache(time.Minute, time.Second, 100)
+	webhookSecret = "secret"
+	adminUserIds = []string{devUser.Id}
+	fromEmail = "calendar@example.com"
+
+	return newRouter()
+}
+
+// Records the method and path template of every route that handles a request.
+type routeRecorder struct {
+	router *mux.Router
+	hits   map[string]bool
+}
+
+func newRouteRecorder(router *mux.Router) *routeRecorder {
+	recorder := &routeRecorder{router: router, hits: make(map[string]bool)}
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if template, err := mux.CurrentRoute(r).GetPathTemplate(); err == nil {
+				recorder.hits[r.Method+" "+template] = true
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	return recorder
+}
+
+// Fails the test for every route that never handled a request.
+func (recorder *routeRecorder) checkCoverage(t *testing.T) {
+	t.Helper()
+
+	recorder.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			if !recorder.hits[method+" "+template] {
+				t.Errorf("%s %s is not covered", method, template)
+			}
+		}
+		return nil
+	})
+}
+
+type testRequest struct {
+	method string
+	path   string
+	// The development user to act as, by id, email or username
+	user   string
+	token  string
+	header http.Header
+	body   any
+}
+
+func (recorder *routeRecorder) do(t *testing.T, request testRequest) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var body bytes.Buffer
+	switch value := request.body.(type) {
+	case nil:
+	case string:
+		body.WriteString(value)
+	default:
+		if err := json.NewEncoder(&body).Encode(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := httptest.NewRequest(request.method, request.path, &body)
+	for key, values := range request.header {
+		r.Header[key] = values
+	}
+	if request.user != "" {
+		r.Header.Set(devUserHeader, request.user)
+	}
+	if request.token != "" {
+		r.Header.Set("Authorization", "Bearer "+request.token)
+	}
+
+	w := httptest.NewRecorder()
+	recorder.router.ServeHTTP(w, r)
+	return w
+}
+
+// Makes the request and checks its status, decoding a JSON response into out
+// if it isn't nil.
+func (recorder *routeRecorder) expect(t *testing.T, request testRequest, status int, out any) {
+	t.Helper()
+
+	w := recorder.do(t, request)
+	if w.Code != status {
+		t.Fatalf("%s %s returned %d, want %d: %s", request.method, request.path, w.Code, status, w.Body.String())
+	}
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s returned invalid JSON: %v", request.method, request.path, err)
+		}
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	server := newRouteRecorder(setupTestServer(t))
+	ctx := context.Background()
+
+	t.Run("webhooks", func(t *testing.T) {
+		for _, user := range []User{devUser, testUser} {
+			server.expect(t, testRequest{
+				method: "POST",
+				path:   "/webhooks/kratos/registration",
+				header: http.Header{"X-Webhook-Secret": {"secret"}},
+				body:   IdentityWebhook{Identity: user.Identity()},
+			}, http.StatusOK, nil)
+		}
+		server.expect(t, testRequest{
+			method: "POST",
+			path:   "/webhooks/kratos/registration",
+			header: http.Header{"X-Webhook-Secret": {"wrong"}},
+			body:   IdentityWebhook{Identity: devUser.Identity()},
+		}, http.StatusUnauthorized, nil)
+	})
+
+	t.Run("unknown users are rejected", func(t *testing.T) {
+		server.expect(t, testRequest{method: "GET", path: "/calendars", user: "nobody"}, http.StatusUnauthorized, nil)
+	})
+
+	var calendar Calendar
+	t.Run("calendars", func(t *testing.T) {
+		var calendars []Calendar
+		server.expect(t, testRequest{method: "GET", path: "/calendars"}, http.StatusOK, &calendars)
+		if len(calendars) != 1 || !calendars[0].IsDefault {
+			t.Fatalf("got %+v, want the default calendar from registration", calendars)
+		}
+
+		server.expect(t, testRequest{method: "POST", path: "/calendars", body: Calendar{Name: "Work", Color: "#ffffff"}}, http.StatusOK, &calendar)
+		server.expect(t, testRequest{
+			method: "PUT",
+			path:   "/calendars/" + calendar.Id,
+			body:   Calendar{Name: "Office", Color: "#000000"},
+		}, http.StatusOK, nil)
+
+		// Only the owner can change or delete a calendar
+		server.expect(t, testRequest{
+			method: "PUT",
+			path:   "/calendars/" + calendar.Id,
+			user:   testUser.Username,
+			body:   Calendar{Name: "Mine now", Color: "#000000"},
+		}, http.StatusNotFound, nil)
+		server.expect(t, testRequest{method: "DELETE", path: "/calendars/" + calendar.Id, user: testUser.Username}, http.StatusNotFound, nil)
+
+		server.expect(t, testRequest{
+			method: "POST",
+			path:   "/calendars/" + calendar.Id + "/members",
+			body:   map[string]string{"userId": testUser.Id},
+		}, http.StatusOK, nil)
+
+		server.expect(t, testRequest{method: "GET", path: "/calendars", user: testUser.Username}, http.StatusOK, &calendars)
+		if len(calendars) != 2 {
+			t.Fatalf("got %d calendars for the new member, want 2", len(calendars))
+		}
+
+		// Members can't add people to calendars they don't own
+		server.expect(t, testRequest{
+			method: "POST",
+			path:   "/calendars/" + calendar.Id + "/members",
+			user:   testUser.Username,
+			body:   map[string]string{"userId": uuid.New().String()},
+		}, http.StatusNotFound, nil)
+
+		var reminder Reminder
+		server.expect(t, testRequest{
+			method: "POST",
+			path:   "/calendars/" + calendar.Id + "/reminders",
+			body:   Reminder{MinutesBefore: 30, Method: "app"},
+		}, http.StatusOK, &reminder)
+		var reminders []Reminder
+		server.expect(t, testRequest{method: "GET", path: "/calendars/" + calendar.Id + "/reminders"}, http.StatusOK, &reminders)
+		if len(reminders) != 1 || reminders[0].Id != reminder.Id {
+			t.Fatalf("got %+v, want the calendar reminder", reminders)
+		}
+	})
+
+	t.Run("users", func(t *testing.T) {
+		var users []User
+		server.expect(t, testRequest{method: "GET", path: "/users?q=test"}, http.StatusOK, &users)
+		if len(users) != 1 || users[0].Id != testUser.Id || users[0].Email != testUser.Email {
+			t.Fatalf("got %+v, want the collaborator with their email", users)
+		}
+		server.expect(t, testRequest{method: "GET", path: "/users?page=0"}, http.StatusBadRequest, nil)
+
+		stranger := User{Id: uuid.New().String(), Email: "sam@example.com", FirstName: "Sam", LastName: "Stranger", Username: "sam"}
+		if err := store.Users().Save(context.Background(), stranger); err != nil {
+			t.Fatal(err)
+		}
+		server.expect(t, testRequest{method: "GET", path: "/users?q=sa"}, http.StatusOK, &users)
+		if len(users) != 0 {
+			t.Fatalf("got %+v, want no strangers for a short query", users)
+		}
+		server.expect(t, testRequest{method: "GET", path: "/users?q=sam"}, http.StatusOK, &users)
+		if len(users) != 1 || users[0].Id != stranger.Id || users[0].Email != "" {
+			t.Fatalf("got %+v, want the stranger without their email", users)
+		}
+	})
+
+	var label Label
+	t.Run("labels", func(t *testing.T) {
+		server.expect(t, testRequest{method: "POST", path: "/labels", body: Label{}}, http.StatusBadRequest, nil)
+		server.expect(t, testRequest{method: "POST", path: "/labels", body: Label{Name: "Focus"}}, http.StatusOK, &label)
+		server.expect(t, testRequest{method: "PUT", path: "/labels/" + label.Id, body: Label{Name: "Deep work", Color: "#ff0000"}}, http.StatusOK, nil)
+		server.expect(t, testRequest{method: "PUT", path: "/labels/" + label.Id, user: testUser.Id, body: Label{Name: "Mine"}}, http.StatusNotFound, nil)
+
+		server.expect(t, testRequest{method: "POST", path: "/labels", body: Label{Name: "Red", Color: "red"}}, http.StatusBadRequest, nil)
+		server.expect(t, testRequest{method: "PUT", path: "/labels/" + label.Id, body: Label{Name: "Deep work", Color: "#ff00"}}, http.StatusBadRequest, nil)
+		server.expect(t, testRequest{method: "POST", path: "/labels", body: Label{Name: "Deep work"}}, http.StatusConflict, nil)
+		var other Label
+		server.expect(t, testRequest{method: "POST", path: "/labels", body: Label{Name: "Other"}}, http.StatusOK, &other)
+		server.expect(t, testRequest{method: "PUT", path: "/labels/" + other.Id, body: Label{Name: "Deep work"}}, http.StatusConflict, nil)
+		server.expect(t, testRequest{method: "DELETE", path: "/labels/" + other.Id}, http.StatusOK, nil)
+
+		var labels []Label
+		server.expect(t, testRequest{method: "GET", path: "/labels"}, http.StatusOK, &labels)
+		if len(labels) != 1 || labels[0].Name != "Deep work" {
+			t.Fatalf("got %+v, want the renamed label", labels)
+		}
+	})
+
+	var event Event
+	t.Run("events", func(t *testing.T) {
+		date := time.Now().UTC().Add(24 * time.Hour).Truncate(time.Minute)
+		server.expect(t, testRequest{
+			method: "POST",
+			path:   "/events",
+			body: CreateEventRequest{
+				CalendarId: calendar.Id,
+				Title:      "Planning",
+				Duration:   60,
+				Date:       date,
+				Invitees:   []string{"guest@example.com"},
+				Reminders:  []Reminder{{MinutesBefore: 10, Method: "app"}},
+			},
+		}, http.StatusOK, &event)
+
+		var events []Event
+		server.expect(t, testRequest{method: "GET", path: "/events", user: testUser.Email}, http.StatusOK, &events)
+		if len(events) != 1 || events[0].Id != event.Id {
+			t.Fatalf("got %+v, want the event in the shared calendar", events)
+		}
+
+		var fetched Event
+		server.expect(t, testRequest{method: "GET", path: "/events/" + event.Id}, http.StatusOK, &fetched)
+		if len(fetched.Attendees) != 1 || len(fetched.Reminders) != 1 {
+			t.Fatalf("got %+v, want the attendee and reminder", fetched)
+		}
+
+		server.expect(t, testRequest{method: "POST", path: "/events/" + event.Id + "/labels", body: map[string]string{"labelId": label.Id}}, http.StatusOK, nil)
+		server.expect(t, testRequest{method: "GET", path: "/events?label=" + label.Id}, http.StatusOK, &events)
+		if len(events) != 1 {
+			t.Fatalf("got %d labelled events, want 1", len(events))
+		}
+		server.expect(t, testRequest{method: "DELETE", path: "/events/" + event.Id + "/labels/" + label.Id}, http.StatusOK, nil)
+
+		server.expect(t, testRequest{method: "POST", path: "/events/" + event.Id + "/reminders", body: Reminder{MinutesBefore: 60, Method: "email"}}, http.StatusOK, nil)
+		var reminders []Reminder
+		server.expect(t, testRequest{method: "GET", path: "/events/" + event.Id + "/reminders"}, http.StatusOK, &reminders)
+		if len(reminders) != 2 {
+			t.Fatalf("got %d event reminders, want 2", len(reminders))
+		}
+
+		updated := event
+		updated.Title = "Quarterly planning"
+		server.expect(t, testRequest{method: "PUT", path: "/events/" + event.Id, body: updated}, http.StatusOK, nil)
+		server.expect(t, testRequest{method: "POST", path: "/events/" + event.Id + "/share", body: map[string][]string{"emails": {"friend@example.com"}}}, http.StatusOK, nil)
+		server.expect(t, testRequest{method: "POST", path: "/events/generate", body: "not json"}, http.StatusBadRequest, nil)
+
+		// The guest and the creator each get the invitation and the update
+		messages, err := store.Outbox().List(ctx, "pending")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(messages) != 5 {
+			t.Fatalf("got %d queued emails, want 5", len(messages))
+		}
+	})
+
+	t.Run("rsvp", func(t *testing.T) {
+		attendees, err := store.Attendees().List(ctx, eventKey(event))
+		if err != nil || len(attendees) != 1 {
+			t.Fatalf("got %v, %v; want one attendee", attendees, err)
+		}
+
+		path := "/rsvp/" + attendees[0].Token + "?response=accepted"
+		server.expect(t, testRequest{method: "GET", path: "/rsvp/" + attendees[0].Token + "?response=maybe"}, http.StatusBadRequest, nil)
+
+		// Following the link only asks to confirm
+		server.expect(t, testRequest{method: "GET", path: path}, http.StatusOK, nil)
+		attendees, _ = store.Attendees().List(ctx, eventKey(event))
+		if attendees[0].Status == "accepted" {
+			t.Fatal("GET recorded the response")
+		}
+
+		server.expect(t, testRequest{method: "POST", path: path}, http.StatusOK, nil)
+		server.expect(t, testRequest{method: "POST", path: "/rsvp/unknown?response=accepted"}, http.StatusNotFound, nil)
+		attendees, _ = store.Attendees().List(ctx, eventKey(event))
+		if attendees[0].Status != "accepted" {
+			t.Fatalf("got status %q, want accepted", attendees[0].Status)
+		}
+	})
+
+	t.Run("tasks", func(t *testing.T) {
+		var task Task
+		deadline := time.Now().UTC().Add(48 * time.Hour).Format(dateFormat)
+		server.expect(t, testRequest{method: "POST", path: "/tasks", body: Task{CalendarId: calendar.Id, Title: "Write report", Duration: 30, Deadline: deadline}}, http.StatusOK, &task)
+
+		task.Priority = 2
+		server.expect(t, testRequest{method: "PUT", path: "/tasks/" + task.Id, body: task}, http.StatusOK, nil)
+		server.expect(t, testRequest{method: "PUT", path: "/tasks/" + task.Id, user: testUser.Id, body: task}, http.StatusNotFound, nil)
+
+		server.expect(t, testRequest{method: "POST", path: "/tasks/" + task.Id + "/labels", body: map[string]string{"labelId": label.Id}}, http.StatusOK, nil)
+		var tasks []Task
+		server.expect(t, testRequest{method: "GET", path: "/tasks"}, http.StatusOK, &tasks)
+		if len(tasks) != 1 || len(tasks[0].Labels) != 1 || tasks[0].Priority != 2 {
+			t.Fatalf("got %+v, want the updated, labelled task", tasks)
+		}
+		server.expect(t, testRequest{method: "DELETE", path: "/tasks/" + task.Id + "/labels/" + label.Id}, http.StatusOK, nil)
+
+		server.expect(t, testRequest{method: "POST", path: "/tasks/" + task.Id + "/timer/start"}, http.StatusOK, nil)
+		var running TimeEntry
+		server.expect(t, testRequest{method: "GET", path: "/timer"}, http.StatusOK, &running)
+		if running.TaskId != task.Id {
+			t.Fatalf("got %+v, want the running timer", running)
+		}
+		server.expect(t, testRequest{method: "POST", path: "/tasks/" + task.Id + "/timer/stop"}, http.StatusOK, nil)
+		var entries []TimeEntry
+		server.expect(t, testRequest{method: "GET", path: "/tasks/" + task.Id + "/time-entries"}, http.StatusOK, &entries)
+		if len(entries) != 1 || entries[0].StoppedAt == nil {
+			t.Fatalf("got %+v, want one stopped entry", entries)
+		}
+
+		var reminder Reminder
+		server.expect(t, testRequest{method: "POST", path: "/tasks/" + task.Id + "/reminders", body: Reminder{MinutesBefore: 60, Method: "app"}}, http.StatusOK, &reminder)
+		var reminders []Reminder
+		server.expect(t, testRequest{method: "GET", path: "/tasks/" + task.Id + "/reminders"}, http.StatusOK, &reminders)
+		if len(reminders) != 1 {
+			t.Fatalf("got %d task reminders, want 1", len(reminders))
+		}
+
+		server.expect(t, testRequest{method: "POST", path: "/reminders/" + reminder.Id + "/snooze", body: map[string]int{"minutes": 0}}, http.StatusBadRequest, nil)
+		server.expect(t, testRequest{method: "POST", path: "/reminders/" + reminder.Id + "/snooze", body: map[string]int{"minutes": 10}}, http.StatusOK, &reminder)
+		if reminder.SnoozedUntil == nil {
+			t.Fatal("snoozed reminder has no snoozedUntil")
+		}
+		server.expect(t, testRequest{method: "DELETE", path: "/reminders/" + reminder.Id, user: testUser.Id}, http.StatusNotFound, nil)
+		server.expect(t, testRequest{method: "DELETE", path: "/reminders/" + reminder.Id}, http.StatusOK, nil)
+
+		server.expect(t, testRequest{method: "DELETE", path: "/tasks/" + task.Id}, http.StatusOK, nil)
+		server.expect(t, testRequest{method: "GET", path: "/tasks"}, http.StatusOK, &tasks)
+		if len(tasks) != 0 {
+			t.Fatalf("got %d tasks after deleting, want 0", len(tasks))
+		}
+	})
+
+	t.Run("reports", func(t *testing.T) {
+		var report TimeReport
+		server.expect(t, testRequest{method: "GET", path: "/reports/time"}, http.StatusOK, &report)
+		server.expect(t, testRequest{method: "GET", path: "/reports/time?from=bad"}, http.StatusBadRequest, nil)
+
+		from := time.Now().UTC().Format(time.RFC3339)
+		to := time.Now().UTC().AddDate(0, 0, 7).Format(time.RFC3339)
+		var analytics Analytics
+		server.expect(t, testRequest{method: "GET", path: fmt.Sprintf("/analytics?from=%s&to=%s", from, to)}, http.StatusOK, &analytics)
+		if len(analytics.Calendars) != 1 || analytics.Calendars[0].Minutes != 60 {
+			t.Fatalf("got %+v, want the hour of planning", analytics.Calendars)
+		}
+		server.expect(t, testRequest{method: "GET", path: "/analytics?timezone=Nowhere"}, http.StatusBadRequest, nil)
+	})
+
+	t.Run("notifications", func(t *testing.T) {
+		notification := Notification{Id: "3b0b8d4e-5c39-4a55-9a0e-0c5f1d7f2a11", UserId: devUser.Id, Title: "Hello"}
+		if err := store.Notifications().Create(ctx, notification); err != nil {
+			t.Fatal(err)
+		}
+
+		var notifications []Notification
+		server.expect(t, testRequest{method: "GET", path: "/notifications?unread=true"}, http.StatusOK, &notifications)
+		if len(notifications) != 1 {
+			t.Fatalf("got %d notifications, want 1", len(notifications))
+		}
+		server.expect(t, testRequest{method: "POST", path: "/notifications/" + notification.Id + "/read"}, http.StatusOK, nil)
+		server.expect(t, testRequest{method: "GET", path: "/notifications?unread=true"}, http.StatusOK, &notifications)
+		if len(notifications) != 0 {
+			t.Fatalf("got %d unread notifications after reading, want 0", len(notifications))
+		}
+	})
+
+	t.Run("settings", func(t *testing.T) {
+		var settings Settings
+		server.expect(t, testRequest{method: "GET", path: "/settings"}, http.StatusOK, &settings)
+		settings.DailyDigest = true
+		settings.Timezone = "America/New_York"
+		server.expect(t, testRequest{method: "PUT", path: "/settings", body: settings}, http.StatusOK, nil)
+		server.expect(t, testRequest{method: "PUT", path: "/settings", body: Settings{Locale: "xx"}}, http.StatusBadRequest, nil)
+
+		stored, err := store.Settings().Get(ctx, devUser.Id)
+		if err != nil || !stored.DailyDigest || stored.Timezone != "America/New_York" {
+			t.Fatalf("got %+v, %v; want the saved settings", stored, err)
+		}
+
+		server.expect(t, testRequest{method: "GET", path: "/unsubscribe/" + stored.UnsubscribeToken + "?list=bogus"}, http.StatusBadRequest, nil)
+		server.expect(t, testRequest{method: "GET", path: "/unsubscribe/" + stored.UnsubscribeToken + "?list=daily"}, http.StatusOK, nil)
+		if stored, _ := store.Settings().Get(ctx, devUser.Id); !stored.DailyDigest {
+			t.Fatal("GET unsubscribed")
+		}
+
+		server.expect(t, testRequest{method: "POST", path: "/unsubscribe/" + stored.UnsubscribeToken + "?list=daily"}, http.StatusOK, nil)
+		if stored, _ := store.Settings().Get(ctx, devUser.Id); stored.DailyDigest || !stored.EmailReminders {
+			t.Fatalf("got %+v, want only the daily digest turned off", stored)
+		}
+
+		// What mail clients send for a one-click unsubscribe
+		server.expect(t, testRequest{
+			method: "POST",
+			path:   "/unsubscribe/" + stored.UnsubscribeToken,
+			header: http.Header{"Content-Type": {"application/x-www-form-urlencoded"}},
+			body:   "List-Unsubscribe=One-Click",
+		}, http.StatusOK, nil)
+		server.expect(t, testRequest{method: "POST", path: "/unsubscribe/unknown"}, http.StatusNotFound, nil)
+
+		stored, _ = store.Settings().Get(ctx, devUser.Id)
+		if stored.DailyDigest || stored.EmailReminders {
+			t.Fatalf("got %+v, want every email turned off", stored)
+		}
+	})
+
+	t.Run("tokens", func(t *testing.T) {
+		var created struct {
+			AccessToken
+			Token string `json:"token"`
+		}
+		server.expect(t, testRequest{method: "POST", path: "/tokens", body: CreateAccessTokenRequest{Name: "script", Scopes: []string{"unknown"}}}, http.StatusBadRequest, nil)
+		server.expect(t, testRequest{method: "POST", path: "/tokens", body: CreateAccessTokenRequest{Name: "script", Scopes: []string{"read"}}}, http.StatusOK, &created)
+
+		var tokens []AccessToken
+		server.expect(t, testRequest{method: "GET", path: "/tokens"}, http.StatusOK, &tokens)
+		if len(tokens) != 1 || tokens[0].Id != created.Id {
+			t.Fatalf("got %+v, want the new token", tokens)
+		}
+
+		server.expect(t, testRequest{method: "GET", path: "/events", token: created.Token}, http.StatusOK, nil)
+		server.expect(t, testRequest{method: "POST", path: "/labels", token: created.Token, body: Label{Name: "Nope"}}, http.StatusForbidden, nil)
+		server.expect(t, testRequest{method: "GET", path: "/tokens", token: created.Token}, http.StatusForbidden, nil)
+
+		server.expect(t, testRequest{method: "DELETE", path: "/tokens/" + created.Id}, http.StatusOK, nil)
+		server.expect(t, testRequest{method: "GET", path: "/events", token: created.Token}, http.StatusUnauthorized, nil)
+	})
+
+	t.Run("admin", func(t *testing.T) {
+		server.expect(t, testRequest{method: "GET", path: "/admin/mail", user: testUser.Id}, http.StatusForbidden, nil)
+		server.expect(t, testRequest{method: "GET", path: "/admin/metrics", user: testUser.Id}, http.StatusForbidden, nil)
+
+		var messages []OutboxMessage
+		server.expect(t, testRequest{method: "GET", path: "/admin/mail?status=pending"}, http.StatusOK, &messages)
+		if len(messages) != 5 {
+			t.Fatalf("got %d pending emails, want 5", len(messages))
+		}
+		server.expect(t, testRequest{method: "POST", path: "/admin/mail/" + messages[0].Id + "/retry"}, http.StatusOK, nil)
+		server.expect(t, testRequest{method: "POST", path: "/admin/mail/unknown/retry"}, http.StatusNotFound, nil)
+		server.expect(t, testRequest{method: "GET", path: "/admin/metrics"}, http.StatusOK, nil)
+	})
+
+	t.Run("cleanup", func(t *testing.T) {
+		server.expect(t, testRequest{method: "DELETE", path: "/events/" + event.Id}, http.StatusOK, nil)
+		server.expect(t, testRequest{method: "GET", path: "/events/" + event.Id}, http.StatusNotFound, nil)
+
+		server.expect(t, testRequest{method: "DELETE", path: "/labels/" + label.Id}, http.StatusOK, nil)
+		server.expect(t, testRequest{method: "DELETE", path: "/calendars/" + calendar.Id + "/members/" + testUser.Id}, http.StatusOK, nil)
+		server.expect(t, testRequest{method: "DELETE", path: "/calendars/" + calendar.Id}, http.StatusOK, nil)
+
+		var calendars []Calendar
+		server.expect(t, testRequest{method: "GET", path: "/calendars", user: testUser.Id}, http.StatusOK, &calendars)
+		if len(calendars) != 1 {
+			t.Fatalf("got %d calendars for the removed member, want 1", len(calendars))
+		}
+
+		server.expect(t, testRequest{
+			method: "POST",
+			path:   "/webhooks/kratos/deletion",
+			header: http.Header{"X-Webhook-Secret": {"secret"}},
+			body:   IdentityWebhook{Identity: testUser.Identity()},
+		}, http.StatusOK, nil)
+		if _, err := store.Users().Get(ctx, testUser.Id); err != ErrNotFound {
+			t.Fatalf("got %v, want the deleted user gone", err)
+		}
+		if calendars, _ := store.Calendars().ListForUser(ctx, testUser.Id); len(calendars) != 0 {
+			t.Fatalf("got %+v, want the deleted user's calendars gone", calendars)
+		}
+	})
+
+	server.checkCoverage(t)
+}
+
+func TestConfirmationPagesUseTheRecipientsLocale(t *testing.T) {
+	server := newRouteRecorder(setupTestServer(t))
+	ctx := context.Background()
+
+	if err := store.Users().Save(ctx, devUser); err != nil {
+		t.Fatal(err)
+	}
+	settings := defaultSettings(devUser.Id)
+	settings.Locale = "es"
+	if err := store.Settings().Save(ctx, settings); err != nil {
+		t.Fatal(err)
+	}
+	settings, err := store.Settings().Get(ctx, devUser.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attendee := Attendee{Email: devUser.Email, Status: "needs-action", Token: uuid.New().String()}
+	if err := store.Attendees().Add(ctx, uuid.New().String(), attendee); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{"GET", "/unsubscribe/" + settings.UnsubscribeToken + "?list=daily", `lang="es"`},
+		{"GET", "/unsubscribe/" + settings.UnsubscribeToken + "?list=daily", "¿Dejar de recibir el resumen diario?"},
+		{"POST", "/unsubscribe/" + settings.UnsubscribeToken + "?list=daily", "Ya no recibirás el resumen diario."},
+		{"GET", "/rsvp/" + attendee.Token + "?response=accepted", "Aceptar invitación"},
+		{"POST", "/rsvp/" + attendee.Token + "?response=accepted", "Se ha registrado tu respuesta (asistirá)."},
+		// Tokens nobody has still get a page, in the default locale
+		{"GET", "/unsubscribe/" + uuid.New().String() + "?list=daily", "Stop getting the daily digest?"},
+	} {
+		w := server.do(t, testRequest{method: test.method, path: test.path})
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), test.want) {
+			t.Errorf("%s %s returned %d %q, want %q", test.method, test.path, w.Code, w.Body.String(), test.want)
+		}
+	}
+}
+
+func TestMemoryStoreTransactionRollsBack(t *testing.T) {
+	memory := NewMemoryStore()
+	ctx := context.Background()
+
+	failure := fmt.Errorf("rolled back")
+	err := memory.Transaction(ctx, func(tx Store) error {
+		if err := tx.Labels().Create(ctx, Label{Id: "label", UserId: devUser.Id, Name: "Focus"}); err != nil {
+			return err
+		}
+		return failure
+	})
+	if err != failure {
+		t.Fatalf("got %v, want %v", err, failure)
+	}
+
+	labels, err := memory.Labels().ListForUser(ctx, devUser.Id)
+	if err != nil || len(labels) != 0 {
+		t.Fatalf("got %+v, %v; want the label rolled back", labels, err)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected the panic to propagate")
+			}
+		}()
+		memory.Transaction(ctx, func(tx Store) error {
+			tx.Labels().Create(ctx, Label{Id: "label", UserId: devUser.Id, Name: "Focus"})
+			panic("failed")
+		})
+	}()
+
+	if labels, _ := memory.Labels().ListForUser(ctx, devUser.Id); len(labels) != 0 {
+		t.Fatalf("got %+v, want the label rolled back after a panic", labels)
+	}
+}
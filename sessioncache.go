@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Caches Kratos session lookups so every request doesn't make a round trip to
+// whoami. Entries are keyed by a hash of the token, so the cache never holds a
+// usable credential, and rejected tokens are cached for a shorter time so a
+// client retrying with a bad cookie doesn't hammer Kratos either.
+type SessionCache struct {
+	TTL         time.Duration
+	NegativeTTL time.Duration
+	MaxSize     int
+
+	mu      sync.Mutex
+	entries map[string]cachedSession
+	// Concurrent lookups of the same token share one request to Kratos
+	group singleflight.Group
+}
+
+type cachedSession struct {
+	session *Session
+	expires time.Time
+}
+
+var sessionCacheMetrics = expvar.NewMap("session_cache")
+
+func init() {
+	sessionCacheMetrics.Set("hit_rate", expvar.Func(func() any {
+		hits := metricValue(sessionCacheMetrics, "hits")
+		misses := metricValue(sessionCacheMetrics, "misses")
+		if hits+misses == 0 {
+			return 0.0
+		}
+		return float64(hits) / float64(hits+misses)
+	}))
+}
+
+var sessionCache *SessionCache
+
+func NewSessionCache(ttl time.Duration, negativeTTL time.Duration, maxSize int) *SessionCache {
+	return &SessionCache{
+		TTL:         ttl,
+		NegativeTTL: negativeTTL,
+		MaxSize:     maxSize,
+		entries:     make(map[string]cachedSession),
+	}
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Returns the session for the token, calling lookup on a miss. lookup returns
+// a nil session for tokens Kratos rejected, which are cached, and an error when
+// Kratos couldn't be asked, which isn't. The lookup is shared with concurrent
+// callers, so it isn't cancelled when ctx is; a caller whose ctx is done just
+// stops waiting for it.
+func (c *SessionCache) Get(ctx context.Context, token string, lookup func(ctx context.Context, token string) (*Session, error)) (*Session, error) {
+	key := hashToken(token)
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if ok && time.Now().Before(entry.expires) {
+		c.mu.Unlock()
+		sessionCacheMetrics.Add("hits", 1)
+		if entry.session == nil {
+			sessionCacheMetrics.Add("negative_hits", 1)
+		}
+		return entry.session, nil
+	}
+	c.mu.Unlock()
+	sessionCacheMetrics.Add("misses", 1)
+
+	results := c.group.DoChan(key, func() (result any, err error) {
+		// DoChan re-panics on its own goroutine, where nothing could recover,
+		// so a panicking lookup fails the request instead of the process
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				result, err = nil, fmt.Errorf("session lookup panicked: %v", recovered)
+			}
+		}()
+
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), identityTimeout)
+		defer cancel()
+
+		session, err := lookup(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		c.store(key, session)
+		return session, nil
+	})
+
+	select {
+	case result := <-results:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.(*Session), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Drops the entry for a token hash, e.g. when the token is revoked.
+func (c *SessionCache) Invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+}
+
+func (c *SessionCache) store(key string, session *Session) {
+	expires := time.Now().Add(c.NegativeTTL)
+	if session != nil {
+		expires = time.Now().Add(c.TTL)
+		// Never serve a session past the point Kratos would have ended it
+		if !session.ExpiresAt.IsZero() && session.ExpiresAt.Before(expires) {
+			expires = session.ExpiresAt
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.MaxSize {
+		c.evict()
+	}
+	c.entries[key] = cachedSession{session: session, expires: expires}
+}
+
+// Drops expired entries, or if none have expired, the one closest to expiring.
+// Must be called with the lock held.
+func (c *SessionCache) evict() {
+	now := time.Now()
+	var soonest string
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+			sessionCacheMetrics.Add("evictions", 1)
+			continue
+		}
+		if soonest == "" || entry.expires.Before(c.entries[soonest].expires) {
+			soonest = key
+		}
+	}
+
+	if len(c.entries) >= c.MaxSize && soonest != "" {
+		delete(c.entries, soonest)
+		sessionCacheMetrics.Add("evictions", 1)
+	}
+}
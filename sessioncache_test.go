@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSessionCacheRecoversFromPanickingLookup(t *testing.T) {
+	cache := NewSessionCache(time.Minute, time.Second, 10)
+
+	_, err := cache.Get(context.Background(), "token", func(ctx context.Context, token string) (*Session, error) {
+		panic("lookup failed")
+	})
+	if err == nil {
+		t.Fatal("expected an error from a panicking lookup")
+	}
+
+	// The panic isn't cached, so the next lookup runs
+	want := &Session{Id: "session"}
+	got, err := cache.Get(context.Background(), "token", func(ctx context.Context, token string) (*Session, error) {
+		return want, nil
+	})
+	if err != nil || got != want {
+		t.Fatalf("got %v, %v; want the looked up session", got, err)
+	}
+}
+
+func TestSessionCacheCachesSessions(t *testing.T) {
+	cache := NewSessionCache(time.Minute, time.Minute, 10)
+
+	calls := 0
+	lookup := func(ctx context.Context, token string) (*Session, error) {
+		calls++
+		if token == "bad" {
+			return nil, nil
+		}
+		return &Session{Id: token}, nil
+	}
+
+	for range 3 {
+		if session, err := cache.Get(context.Background(), "good", lookup); err != nil || session.Id != "good" {
+			t.Fatalf("got %v, %v", session, err)
+		}
+		if session, err := cache.Get(context.Background(), "bad", lookup); err != nil || session != nil {
+			t.Fatalf("got %v, %v; want no session", session, err)
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("lookup called %d times, want 2", calls)
+	}
+
+	cache.Invalidate(hashToken("good"))
+	cache.Get(context.Background(), "good", lookup)
+	if calls != 3 {
+		t.Fatalf("lookup called %d times after invalidating, want 3", calls)
+	}
+}
+
+func TestSessionCacheDoesNotCacheErrors(t *testing.T) {
+	cache := NewSessionCache(time.Minute, time.Minute, 10)
+
+	failure := errors.New("unreachable")
+	_, err := cache.Get(context.Background(), "token", func(ctx context.Context, token string) (*Session, error) {
+		return nil, failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("got %v, want %v", err, failure)
+	}
+
+	session, err := cache.Get(context.Background(), "token", func(ctx context.Context, token string) (*Session, error) {
+		return &Session{Id: "session"}, nil
+	})
+	if err != nil || session == nil {
+		t.Fatalf("got %v, %v; want a session", session, err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+type Settings struct {
+	UserId   string `json:"userId"`
+	Locale   string `json:"locale"`
+	Timezone string `json:"timezone"`
+	// Opt-in agenda emails: every morning, and a preview of the week on Sundays
+	DailyDigest  bool `json:"dailyDigest"`
+	WeeklyDigest bool `json:"weeklyDigest"`
+	// Turned off, email reminders are delivered as notifications instead
+	EmailReminders   bool   `json:"emailReminders"`
+	UnsubscribeToken string `json:"-"`
+}
+
+var defaultLocale = "en"
+var defaultTimezone = "UTC"
+
+// Returns the user's settings, falling back to the defaults if they never saved any.
+func GetSettings(ctx context.Context, userId string) (Settings, error) {
+	settings, err := store.Settings().Get(ctx, userId)
+	if errors.Is(err, ErrNotFound) {
+		return defaultSettings(userId), nil
+	}
+	return settings, err
+}
+
+func defaultSettings(userId string) Settings {
+	return Settings{UserId: userId, Locale: defaultLocale, Timezone: defaultTimezone, EmailReminders: true}
+}
+
+// Returns the time zone to show times in, falling back to UTC if it no longer loads.
+func (settings Settings) Location() *time.Location {
+	location, err := time.LoadLocation(settings.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// GET /settings
+func getSettings(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+
+	settings, err := GetSettings(r.Context(), session.Identity.Id)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// PUT /settings
+func updateSettings(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+
+	settings, err := GetSettings(r.Context(), session.Identity.Id)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+	settings.UserId = session.Identity.Id
+
+	if _, ok := catalogs[settings.Locale]; !ok {
+		http.Error(w, `{"error": "Unsupported locale"}`, http.StatusBadRequest)
+		return
+	}
+	if _, err := time.LoadLocation(settings.Timezone); err != nil {
+		http.Error(w, `{"error": "Invalid timezone"}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := store.Settings().Save(r.Context(), settings); err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Error updating settings"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+type PostgresSettingsStore struct {
+	db Querier
+}
+
+var settingsColumns = "user_id, locale, timezone, daily_digest, weekly_digest, email_reminders, unsubscribe_token"
+
+func scanSettings(row scanner) (Settings, error) {
+	var settings Settings
+	err := row.Scan(
+		&settings.UserId,
+		&settings.Locale,
+		&settings.Timezone,
+		&settings.DailyDigest,
+		&settings.WeeklyDigest,
+		&settings.EmailReminders,
+		&settings.UnsubscribeToken,
+	)
+	return settings, err
+}
+
+func (s *PostgresSettingsStore) Get(ctx context.Context, userId string) (Settings, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	settings, err := scanSettings(s.db.QueryRow(ctx,
+		`
+		SELECT `+settingsColumns+` FROM user_settings
+		WHERE user_id = $1
+		`,
+		userId,
+	))
+	if err == pgx.ErrNoRows {
+		return Settings{}, ErrNotFound
+	}
+	return settings, err
+}
+
+func (s *PostgresSettingsStore) GetByUnsubscribeToken(ctx context.Context, token string) (Settings, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	if _, err := uuid.Parse(token); err != nil {
+		return Settings{}, ErrNotFound
+	}
+
+	settings, err := scanSettings(s.db.QueryRow(ctx,
+		`
+		SELECT `+settingsColumns+` FROM user_settings
+		WHERE unsubscribe_token = $1
+		`,
+		token,
+	))
+	if err == pgx.ErrNoRows {
+		return Settings{}, ErrNotFound
+	}
+	return settings, err
+}
+
+func (s *PostgresSettingsStore) Ensure(ctx context.Context, userId string) (Settings, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	return scanSettings(s.db.QueryRow(ctx,
+		`
+		INSERT INTO user_settings (user_id, locale, timezone, unsubscribe_token)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE SET user_id = EXCLUDED.user_id
+		RETURNING `+settingsColumns,
+		userId,
+		defaultLocale,
+		defaultTimezone,
+		uuid.New().String(),
+	))
+}
+
+func (s *PostgresSettingsStore) Save(ctx context.Context, settings Settings) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	_, err := s.db.Exec(ctx,
+		`
+		INSERT INTO user_settings (user_id, locale, timezone, daily_digest, weekly_digest, email_reminders, unsubscribe_token)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (user_id) DO UPDATE
+		SET locale = EXCLUDED.locale, timezone = EXCLUDED.timezone,
+			daily_digest = EXCLUDED.daily_digest, weekly_digest = EXCLUDED.weekly_digest,
+			email_reminders = EXCLUDED.email_reminders, updated_at = CURRENT_TIMESTAMP
+		`,
+		settings.UserId,
+		settings.Locale,
+		settings.Timezone,
+		settings.DailyDigest,
+		settings.WeeklyDigest,
+		settings.EmailReminders,
+		uuid.New().String(),
+	)
+	return err
+}
+
+func (s *PostgresSettingsStore) Unsubscribe(ctx context.Context, token string, list string) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	var set string
+	switch list {
+	case "daily":
+		set = "daily_digest = FALSE"
+	case "weekly":
+		set = "weekly_digest = FALSE"
+	case "reminders":
+		set = "email_reminders = FALSE"
+	case "":
+		set = "daily_digest = FALSE, weekly_digest = FALSE, email_reminders = FALSE"
+	default:
+		return fmt.Errorf("unknown list %q", list)
+	}
+	if _, err := uuid.Parse(token); err != nil {
+		return ErrNotFound
+	}
+
+	return expectRows(s.db.Exec(ctx,
+		`UPDATE user_settings SET `+set+`, updated_at = CURRENT_TIMESTAMP WHERE unsubscribe_token = $1`,
+		token,
+	))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Serves a fixed list of users from a JSON file, for small deployments without
+// an identity server. Users authenticate with a bearer token, of which the
+// file only holds the SHA-256 hash:
+//
+//	{"users": [{"id": "...", "email": "...", "firstName": "...", "tokenHash": "..."}]}
+type StaticProvider struct {
+	users []StaticUser
+}
+
+type StaticUser struct {
+	User
+	TokenHash string `json:"tokenHash"`
+}
+
+func NewStaticProvider(path string) (*StaticProvider, error) {
+	if path == "" {
+		return nil, fmt.Errorf("static users file must be set")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Users []StaticUser `json:"users"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	seen := make(map[string]bool)
+	for _, user := range file.Users {
+		if user.Id == "" || user.Email == "" {
+			return nil, fmt.Errorf("every user in %s needs an id and email", path)
+		}
+		if seen[user.Id] {
+			return nil, fmt.Errorf("user %s appears twice in %s", user.Id, path)
+		}
+		seen[user.Id] = true
+	}
+	return &StaticProvider{users: file.Users}, nil
+}
+
+func (s *StaticProvider) Session(r *http.Request) (*Session, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil, nil
+	}
+
+	hash := []byte(hashToken(token))
+	for _, user := range s.users {
+		if user.TokenHash != "" && subtle.ConstantTimeCompare(hash, []byte(strings.ToLower(user.TokenHash))) == 1 {
+			return &Session{Id: user.Id, Active: true, Identity: user.User.Identity()}, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *StaticProvider) Users(ctx context.Context) ([]User, error) {
+	users := []User{}
+	for _, user := range s.users {
+		users = append(users, user.User)
+	}
+	return users, nil
+}
+
+func (s *StaticProvider) SearchUsers(ctx context.Context, query string) ([]User, error) {
+	users, _ := s.Users(ctx)
+	return searchUsers(users, query), nil
+}
+
+func (s *StaticProvider) User(ctx context.Context, id string) (*User, error) {
+	for _, user := range s.users {
+		if user.Id == id {
+			return &user.User, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *StaticProvider) UserByEmail(ctx context.Context, email string) (*User, error) {
+	users, _ := s.Users(ctx)
+	return findUserByEmail(users, email), nil
+}
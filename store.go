@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Returned by stores when the row being read, updated or deleted doesn't exist.
+var ErrNotFound = errors.New("not found")
+
+// Returned by stores when a write loses a race with a concurrent one, or would
+// duplicate a value that has to be unique.
+var ErrConflict = errors.New("conflict")
+
+// Satisfied by both *pgxpool.Pool and pgx.Tx, so a store can run inside a
+// transaction. Every method takes a context, so no query escapes the database
+// timeout.
+type Querier interface {
+	Exec(ctx context.Context, query string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, query string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, query string, args ...any) pgx.Row
+}
+
+// Satisfied by both pgx.Row and pgx.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// Every store, all on the same connection or transaction. Handlers and
+// background jobs only reach the database through here.
+type Store interface {
+	Calendars() CalendarStore
+	Events() EventStore
+	Tasks() TaskStore
+	Labels() LabelStore
+	Reminders() ReminderStore
+	Notifications() NotificationStore
+	Settings() SettingsStore
+	Digests() DigestStore
+	Timers() TimerStore
+	Reports() ReportStore
+	Attendees() AttendeeStore
+	Outbox() OutboxStore
+	Tokens() TokenStore
+	Users() UserStore
+	// Runs fn with a Store whose writes are committed together if fn returns
+	// nil, and rolled back if it returns an error or panics. Called on a Store
+	// that is already a transaction, fn joins it.
+	Transaction(ctx context.Context, fn func(tx Store) error) error
+}
+
+type CalendarStore interface {
+	// Calendars the user is a member of, each with its members.
+	ListForUser(ctx context.Context, userId string) ([]Calendar, error)
+	IsMember(ctx context.Context, calendarId string, userId string) (bool, error)
+	// Whether the user is a member of a default calendar.
+	HasDefault(ctx context.Context, userId string) (bool, error)
+	// Creates the calendar with its owner as the only member.
+	Create(ctx context.Context, calendar Calendar, ownerId string) error
+	// Renames and recolors the calendar. Only its owner can change it.
+	Update(ctx context.Context, calendar Calendar, ownerId string) error
+	// Only the owner of a calendar can delete it.
+	Delete(ctx context.Context, id string, ownerId string) error
+	// Only the owner of a calendar can add members to it.
+	AddMember(ctx context.Context, calendarId string, userId string, ownerId string) error
+	// Only the owner of a calendar can remove its members.
+	RemoveMember(ctx context.Context, calendarId string, userId string, ownerId string) error
+}
+
+type EventStore interface {
+	// Events in the calendars the user is a member of, optionally only those
+	// with a label.
+	ListForUser(ctx context.Context, userId string, labelId string) ([]Event, error)
+	// Events in the user's calendars starting in [start, end), earliest first.
+	ListBetween(ctx context.Context, userId string, start time.Time, end time.Time) ([]Event, error)
+	Get(ctx context.Context, id string) (Event, error)
+	// Like Get, but only finds events the user created.
+	GetOwned(ctx context.Context, id string, userId string) (Event, error)
+	CanAccess(ctx context.Context, eventId string, userId string) (bool, error)
+	Create(ctx context.Context, userId string, events ...Event) error
+	// The sequence a new revision of the event's series should have.
+	NextSequence(ctx context.Context, eventId string) (int, error)
+	// Updates a single event. An occurrence of a series stays in it, keeping
+	// the date it had there as its OriginalDate.
+	Update(ctx context.Context, event Event) error
+	// Updates old and every later event in its series, moving them all, and the
+	// dates they had in the series, by however far event moves old. Occurrences
+	// count as later by the date they had in the series.
+	UpdateFollowing(ctx context.Context, old Event, event Event) error
+	Delete(ctx context.Context, id string) error
+	// Deletes every event in the series whose date in the series is from on.
+	DeleteFollowing(ctx context.Context, recurrenceId string, from string) error
+}
+
+type TaskStore interface {
+	// The user's tasks, unfinished and soonest due first, optionally only those
+	// with a label.
+	ListForUser(ctx context.Context, userId string, labelId string) ([]Task, error)
+	// The user's unfinished tasks due before the given time, soonest first.
+	ListDue(ctx context.Context, userId string, before time.Time) ([]Task, error)
+	IsOwner(ctx context.Context, taskId string, userId string) (bool, error)
+	Create(ctx context.Context, task Task) error
+	// Updates the task if it belongs to task.UserId.
+	Update(ctx context.Context, task Task) error
+	Delete(ctx context.Context, taskId string, userId string) error
+}
+
+// Labels are private, so only their owner sees which events and tasks have them.
+type LabelStore interface {
+	// The user's labels, by name.
+	ListForUser(ctx context.Context, userId string) ([]Label, error)
+	IsOwner(ctx context.Context, labelId string, userId string) (bool, error)
+	// Returns ErrConflict if the user already has a label with the name.
+	Create(ctx context.Context, label Label) error
+	// Renames and recolors the label if it belongs to label.UserId. Returns
+	// ErrConflict if the user already has another label with the name.
+	Update(ctx context.Context, label Label) error
+	Delete(ctx context.Context, labelId string, userId string) error
+	// Maps each task or event id to the ids of the user's labels on it.
+	TaskLabels(ctx context.Context, userId string) (map[string][]string, error)
+	EventLabels(ctx context.Context, userId string) (map[string][]string, error)
+	// Adding a label that is already there changes nothing.
+	AddToTask(ctx context.Context, taskId string, labelId string) error
+	RemoveFromTask(ctx context.Context, taskId string, labelId string) error
+	AddToEvent(ctx context.Context, eventId string, labelId string) error
+	RemoveFromEvent(ctx context.Context, eventId string, labelId string) error
+}
+
+type ReminderStore interface {
+	// The reminders the user set on the event or its whole series, longest
+	// notice first.
+	ListForEvent(ctx context.Context, userId string, event Event) ([]Reminder, error)
+	ListForCalendar(ctx context.Context, userId string, calendarId string) ([]Reminder, error)
+	// Reminders before the deadline first, then overdue notices.
+	ListForTask(ctx context.Context, userId string, taskId string) ([]Reminder, error)
+	Create(ctx context.Context, reminders ...Reminder) error
+	Delete(ctx context.Context, id string, userId string) error
+	// Fires a task reminder again the given number of minutes from now.
+	Snooze(ctx context.Context, id string, userId string, minutes int) (Reminder, error)
+	// Clears the snoozes on the reminders of a task.
+	ClearSnoozes(ctx context.Context, taskId string, userId string) error
+	// Reminders whose fire time has passed and that haven't been delivered.
+	DueForEvents(ctx context.Context) ([]dueReminder, error)
+	DueForTasks(ctx context.Context) ([]dueReminder, error)
+	// Records the delivery, returning false if it was already recorded.
+	Claim(ctx context.Context, reminder dueReminder) (bool, error)
+}
+
+type NotificationStore interface {
+	Create(ctx context.Context, notification Notification) error
+	// The user's latest notifications, newest first.
+	ListForUser(ctx context.Context, userId string, unreadOnly bool) ([]Notification, error)
+	// Marking a notification read twice keeps the first time it was read.
+	MarkRead(ctx context.Context, id string, userId string) error
+	// Marks every unread notification about the task read.
+	MarkTaskRead(ctx context.Context, taskId string, userId string) error
+}
+
+type SettingsStore interface {
+	// Returns ErrNotFound for users who never saved any settings.
+	Get(ctx context.Context, userId string) (Settings, error)
+	// The settings the unsubscribe token belongs to.
+	GetByUnsubscribeToken(ctx context.Context, token string) (Settings, error)
+	// Stores the defaults for users who never saved any settings, and returns
+	// what is stored.
+	Ensure(ctx context.Context, userId string) (Settings, error)
+	// Saves everything but the unsubscribe token, which never changes.
+	Save(ctx context.Context, settings Settings) error
+	// Turns off the emails in list ("daily", "weekly" or "reminders", or every
+	// one of them if empty) for whoever the token belongs to.
+	Unsubscribe(ctx context.Context, token string, list string) error
+}
+
+type DigestStore interface {
+	// The settings of everyone subscribed to a digest.
+	Subscribers(ctx context.Context) ([]Settings, error)
+	// Records the digest, returning false if it was already recorded.
+	Claim(ctx context.Context, userId string, kind string, date string) (bool, error)
+}
+
+type TimerStore interface {
+	// Returns ErrNotFound when none of the user's timers is running.
+	Running(ctx context.Context, userId string) (TimeEntry, error)
+	// The user's time entries on the task, latest first.
+	ListForTask(ctx context.Context, taskId string, userId string) ([]TimeEntry, error)
+	// Stops the user's running timer, if any, and starts one on the task.
+	// Returns ErrConflict if another request started one in between.
+	Start(ctx context.Context, taskId string, userId string) (TimeEntry, error)
+	Stop(ctx context.Context, taskId string, userId string) (TimeEntry, error)
+	// Maps each task id to the minutes the user tracked on it.
+	TrackedMinutes(ctx context.Context, userId string) (map[string]int, error)
+}
+
+// Read-only summaries over [from, to).
+type ReportStore interface {
+	// Minutes tracked by calendar and label. Entries that straddle the range
+	// only count the part inside it.
+	TimeReport(ctx context.Context, userId string, from time.Time, to time.Time) (TimeReport, error)
+	// Where the user's time goes, with weekdays counted in location.
+	Analytics(ctx context.Context, userId string, from time.Time, to time.Time, location *time.Location) (Analytics, error)
+}
+
+type AttendeeStore interface {
+	// The attendees of the event or series with the key, by email.
+	List(ctx context.Context, eventKey string) ([]Attendee, error)
+	// Adding someone who is already an attendee keeps their token and response.
+	Add(ctx context.Context, eventKey string, attendees ...Attendee) error
+	// Removes the attendees once no event with the key is left.
+	RemoveOrphaned(ctx context.Context, eventKey string) error
+	// The attendee with the RSVP token.
+	GetByToken(ctx context.Context, token string) (Attendee, error)
+	// Records the response of the attendee with the RSVP token.
+	Respond(ctx context.Context, token string, status string) error
+}
+
+type OutboxStore interface {
+	Enqueue(ctx context.Context, to []string, message []byte) error
+	// Leases up to limit due messages, skipping ones another worker holds.
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]OutboxMessage, error)
+	MarkSent(ctx context.Context, id string) error
+	// Records a failed attempt, retrying after the given delay unless status
+	// is "dead".
+	MarkFailed(ctx context.Context, id string, status string, attempts int, lastError string, retryIn time.Duration) error
+	// The latest messages with the status, without their contents.
+	List(ctx context.Context, status string) ([]OutboxMessage, error)
+	// Queues an unsent message to be tried again right away.
+	Retry(ctx context.Context, id string) error
+}
+
+type TokenStore interface {
+	// The user's tokens, newest first.
+	ListForUser(ctx context.Context, userId string) ([]AccessToken, error)
+	Create(ctx context.Context, token AccessToken, tokenHash string) error
+	// Finds the unexpired token with the hash.
+	Find(ctx context.Context, tokenHash string) (AccessToken, error)
+	// Records that the token with the hash was just used.
+	Touch(ctx context.Context, tokenHash string) error
+	// Deletes the user's token and returns its hash.
+	Revoke(ctx context.Context, id string, userId string) (string, error)
+	HashesForUser(ctx context.Context, userId string) ([]string, error)
+}
+
+// The local copy of the identity provider's users.
+type UserStore interface {
+	// Inserts the users, or refreshes the ones already stored.
+	Save(ctx context.Context, users ...User) error
+	Get(ctx context.Context, id string) (User, error)
+	GetByEmail(ctx context.Context, email string) (User, error)
+	// Every user, by email.
+	List(ctx context.Context) ([]User, error)
+	// Users matching query as getUsers describes, collaborators of the
+	// requester first.
+	Search(ctx context.Context, requesterId string, query string, limit int, offset int) ([]UserSearchResult, error)
+	// Deletes every user whose id isn't in ids.
+	DeleteExcept(ctx context.Context, ids []string) error
+	// Deletes the user and everything that belonged only to them. Calendars
+	// they shared, and the events they created in them, pass to the
+	// longest-standing remaining member.
+	Delete(ctx context.Context, id string) error
+}
+
+var store Store
+
+// Maps a statement that matched no rows to ErrNotFound.
+func expectRows(result pgconn.CommandTag, err error) error {
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Maps a unique constraint violation to ErrConflict.
+func conflictOnDuplicate(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrConflict
+	}
+	return err
+}
+
+func nullString(value sql.NullString) *string {
+	if !value.Valid {
+		return nil
+	}
+	return &value.String
+}
+
+// Timestamps are returned to clients in RFC 3339 form.
+func timestamp(value time.Time) string {
+	return value.Format(time.RFC3339Nano)
+}
+
+func nullTimestamp(value sql.NullTime) *string {
+	if !value.Valid {
+		return nil
+	}
+	formatted := timestamp(value.Time)
+	return &formatted
+}
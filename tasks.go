@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 
@@ -10,76 +12,89 @@ import (
 )
 
 type Task struct {
-	Id          string  `json:"id" database:"id"`
-	UserId      string  `json:"userId" database:"user_id"`
-	CalendarId  string  `json:"calendarId" database:"calendar_id"`
-	Title       string  `json:"title" database:"title`
-	Description *string `json:"description" database:"description"`
-	Duration    int     `json:"duration" database:"duration"`
-	Deadline    string  `json:"deadline" database:"deadline"`
-	Difficulty  int     `json:"difficulty" database:"difficulty"`
-	Priority    int     `json:"priority" database:"priority"`
-	Completed   bool    `json:"completed" database:"completed"`
+	Id          string   `json:"id"`
+	UserId      string   `json:"userId"`
+	CalendarId  string   `json:"calendarId"`
+	Title       string   `json:"title"`
+	Description *string  `json:"description"`
+	Duration    int      `json:"duration"`
+	Deadline    string   `json:"deadline"`
+	Difficulty  int      `json:"difficulty"`
+	Priority    int      `json:"priority"`
+	Completed   bool     `json:"completed"`
+	Labels      []string `json:"labels"`
+	// Minutes tracked with timers, compared against the Duration estimate
+	ActualDuration   int `json:"actualDuration"`
+	DurationVariance int `json:"durationVariance"`
+}
+
+func ownsTask(ctx context.Context, userId string, taskId string) bool {
+	owner, err := store.Tasks().IsOwner(ctx, taskId, userId)
+	if err != nil {
+		log.Println("Error checking task owner:", err)
+	}
+	return err == nil && owner
 }
 
 // GET /tasks
 func getTasks(w http.ResponseWriter, r *http.Request) {
-	session := getSession(r)
-	if session == nil {
-		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
-		return
-	}
+	session := currentSession(r)
 
 	userId := session.Identity.Id
 
-	var tasks []Task
-	Query(&tasks,
-		`
-		SELECT * FROM tasks
-		WHERE user_id = $1
-		ORDER BY completed ASC, deadline ASC
-		`,
-		userId,
-	)
-
-	if len(tasks) == 0 {
-		tasks = []Task{}
+	labelId := r.URL.Query().Get("label")
+	if labelId != "" {
+		if _, err := uuid.Parse(labelId); err != nil {
+			http.Error(w, `{"error": "Invalid label"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	tasks, err := store.Tasks().ListForUser(r.Context(), userId, labelId)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	labels, err := store.Labels().TaskLabels(r.Context(), userId)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+	tracked, err := TrackedMinutes(r.Context(), userId)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+	for idx, task := range tasks {
+		tasks[idx].Labels = labels[task.Id]
+		if tasks[idx].Labels == nil {
+			tasks[idx].Labels = []string{}
+		}
+		tasks[idx].ActualDuration = tracked[task.Id]
+		tasks[idx].DurationVariance = tracked[task.Id] - task.Duration
 	}
 	json.NewEncoder(w).Encode(tasks)
 }
 
 // POST /tasks
 func createTask(w http.ResponseWriter, r *http.Request) {
-	session := getSession(r)
-	if session == nil {
-		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
-		return
-	}
-
-	userId := session.Identity.Id
-	task_id := uuid.New().String()
+	session := currentSession(r)
 
 	var task Task
-	json.NewDecoder(r.Body).Decode(&task)
-
-	_, err := Execute(
-		`
-		INSERT INTO tasks (id, user_id, calendar_id, title, description, duration, deadline, difficulty, priority, completed)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
-		`,
-		task_id,
-		userId,
-		task.CalendarId,
-		task.Title,
-		task.Description,
-		task.Duration,
-		task.Deadline,
-		task.Difficulty,
-		task.Priority,
-		false,
-	)
+	if err := json.NewDecoder(r.Body).Decode(&task); err != nil {
+		http.Error(w, `{"error": "Invalid request"}`, http.StatusBadRequest)
+		return
+	}
+	task.Id = uuid.New().String()
+	task.UserId = session.Identity.Id
+	task.Completed = false
+	task.Labels = []string{}
 
-	if err != nil {
+	if err := store.Tasks().Create(r.Context(), task); err != nil {
 		log.Println(err)
 		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
 		return
@@ -90,37 +105,33 @@ func createTask(w http.ResponseWriter, r *http.Request) {
 
 // PUT /tasks/:id
 func updateTask(w http.ResponseWriter, r *http.Request) {
-	session := getSession(r)
-	if session == nil {
-		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
-		return
-	}
+	session := currentSession(r)
 
 	userId := session.Identity.Id
 	vars := mux.Vars(r)
 	taskId := vars["id"]
 
 	var task Task
-	json.NewDecoder(r.Body).Decode(&task)
-
-	_, err := Execute(
-		`
-		UPDATE tasks
-		SET calendar_id = $2, title = $3, description = $4, duration = $5, deadline = $6, difficulty = $7, priority = $8, completed = $9
-		WHERE id = $1 AND user_id = $10
-		`,
-		taskId,
-		task.CalendarId,
-		task.Title,
-		task.Description,
-		task.Duration,
-		task.Deadline,
-		task.Difficulty,
-		task.Priority,
-		task.Completed,
-		userId,
-	)
-
+	if err := json.NewDecoder(r.Body).Decode(&task); err != nil {
+		http.Error(w, `{"error": "Invalid request"}`, http.StatusBadRequest)
+		return
+	}
+	task.Id = taskId
+	task.UserId = userId
+
+	err := store.Transaction(r.Context(), func(tx Store) error {
+		if err := tx.Tasks().Update(r.Context(), task); err != nil {
+			return err
+		}
+		if task.Completed {
+			return suppressTaskReminders(r.Context(), tx, userId, taskId)
+		}
+		return nil
+	})
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, `{"error": "Task not found"}`, http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		log.Println(err)
 		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
@@ -132,26 +143,19 @@ func updateTask(w http.ResponseWriter, r *http.Request) {
 
 // DELETE /tasks/:id
 func deleteTask(w http.ResponseWriter, r *http.Request) {
-	session := getSession(r)
-	if session == nil {
-		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
-		return
-	}
+	session := currentSession(r)
 
 	userId := session.Identity.Id
 	vars := mux.Vars(r)
 	taskId := vars["id"]
 
-	_, err := Execute(
-		`
-		DELETE FROM tasks
-		WHERE id = $1 AND user_id = $2
-		`,
-		taskId,
-		userId,
-	)
-
+	err := store.Tasks().Delete(r.Context(), taskId, userId)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, `{"error": "Task not found"}`, http.StatusNotFound)
+		return
+	}
 	if err != nil {
+		log.Println(err)
 		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
 		return
 	}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type PostgresTaskStore struct {
+	db Querier
+}
+
+var taskColumns = "id, user_id, calendar_id, title, description, duration, deadline, difficulty, priority, completed"
+
+func scanTask(row scanner) (Task, error) {
+	var task Task
+	var description sql.NullString
+	var duration, difficulty, priority sql.NullInt64
+	var deadline sql.NullTime
+	var completed sql.NullBool
+	err := row.Scan(
+		&task.Id,
+		&task.UserId,
+		&task.CalendarId,
+		&task.Title,
+		&description,
+		&duration,
+		&deadline,
+		&difficulty,
+		&priority,
+		&completed,
+	)
+	if err != nil {
+		return task, err
+	}
+
+	task.Description = nullString(description)
+	task.Duration = int(duration.Int64)
+	if deadline.Valid {
+		task.Deadline = deadline.Time.UTC().Format(dateFormat)
+	}
+	task.Difficulty = int(difficulty.Int64)
+	task.Priority = int(priority.Int64)
+	task.Completed = completed.Bool
+	return task, nil
+}
+
+// Deadlines are optional, and an empty one is stored as NULL.
+func taskDeadline(task Task) any {
+	if task.Deadline == "" {
+		return nil
+	}
+	return task.Deadline
+}
+
+func (s *PostgresTaskStore) queryTasks(ctx context.Context, query string, args ...any) ([]Task, error) {
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := []Task{}
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
+func (s *PostgresTaskStore) ListForUser(ctx context.Context, userId string, labelId string) ([]Task, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	query := `
+		SELECT ` + taskColumns + ` FROM tasks
+		WHERE user_id = $1
+		`
+	args := []any{userId}
+
+	if labelId != "" {
+		query += `AND id IN (SELECT task_id FROM task_labels WHERE label_id = $2)
+		`
+		args = append(args, labelId)
+	}
+	query += `ORDER BY completed ASC, deadline ASC`
+
+	return s.queryTasks(ctx, query, args...)
+}
+
+func (s *PostgresTaskStore) ListDue(ctx context.Context, userId string, before time.Time) ([]Task, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	return s.queryTasks(ctx,
+		`
+		SELECT `+taskColumns+` FROM tasks
+		WHERE user_id = $1 AND NOT completed
+		AND deadline IS NOT NULL AND deadline < $2
+		ORDER BY deadline ASC
+		`,
+		userId,
+		before,
+	)
+}
+
+func (s *PostgresTaskStore) IsOwner(ctx context.Context, taskId string, userId string) (bool, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	var exists bool
+	err := s.db.QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM tasks WHERE id = $1 AND user_id = $2)",
+		taskId,
+		userId,
+	).Scan(&exists)
+	return exists, err
+}
+
+func (s *PostgresTaskStore) Create(ctx context.Context, task Task) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	_, err := s.db.Exec(ctx,
+		`
+		INSERT INTO tasks (id, user_id, calendar_id, title, description, duration, deadline, difficulty, priority, completed)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		`,
+		task.Id,
+		task.UserId,
+		task.CalendarId,
+		task.Title,
+		task.Description,
+		task.Duration,
+		taskDeadline(task),
+		task.Difficulty,
+		task.Priority,
+		task.Completed,
+	)
+	return err
+}
+
+func (s *PostgresTaskStore) Update(ctx context.Context, task Task) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	return expectRows(s.db.Exec(ctx,
+		`
+		UPDATE tasks
+		SET calendar_id = $2, title = $3, description = $4, duration = $5, deadline = $6, difficulty = $7, priority = $8, completed = $9
+		WHERE id = $1 AND user_id = $10
+		`,
+		task.Id,
+		task.CalendarId,
+		task.Title,
+		task.Description,
+		task.Duration,
+		taskDeadline(task),
+		task.Difficulty,
+		task.Priority,
+		task.Completed,
+		task.UserId,
+	))
+}
+
+func (s *PostgresTaskStore) Delete(ctx context.Context, taskId string, userId string) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	return expectRows(s.db.Exec(ctx,
+		`
+		DELETE FROM tasks
+		WHERE id = $1 AND user_id = $2
+		`,
+		taskId,
+		userId,
+	))
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// How long a request may take in total, and how long each kind of operation
+// within it may take. A request that runs out of time, or whose client
+// disconnects, cancels whatever query or call it is waiting on.
+var requestTimeout = 60 * time.Second
+var databaseTimeout = 10 * time.Second
+var identityTimeout = 10 * time.Second
+var llmTimeout = 30 * time.Second
+
+func withRequestTimeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Bounds a single database operation.
+func databaseContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, databaseTimeout)
+}
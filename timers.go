@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type TimeEntry struct {
+	Id        string  `json:"id"`
+	TaskId    string  `json:"taskId"`
+	UserId    string  `json:"userId"`
+	StartedAt string  `json:"startedAt"`
+	StoppedAt *string `json:"stoppedAt"`
+	Minutes   int     `json:"minutes"`
+}
+
+type TimeReportEntry struct {
+	Id      string `json:"id"`
+	Name    string `json:"name"`
+	Minutes int    `json:"minutes"`
+}
+
+type TimeReport struct {
+	From         string            `json:"from"`
+	To           string            `json:"to"`
+	TotalMinutes int               `json:"totalMinutes"`
+	Calendars    []TimeReportEntry `json:"calendars"`
+	Labels       []TimeReportEntry `json:"labels"`
+}
+
+// Returns a map of task id to the number of minutes the user has tracked on it.
+func TrackedMinutes(ctx context.Context, userId string) (map[string]int, error) {
+	return store.Timers().TrackedMinutes(ctx, userId)
+}
+
+// GET /timer
+func getRunningTimer(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+
+	entry, err := store.Timers().Running(r.Context(), session.Identity.Id)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, `{"error": "No running timer"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+}
+
+// GET /tasks/{id}/time-entries
+func getTimeEntries(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+
+	vars := mux.Vars(r)
+	taskId := vars["id"]
+
+	entries, err := store.Timers().ListForTask(r.Context(), taskId, session.Identity.Id)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// POST /tasks/{id}/timer/start
+func startTimer(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+
+	userId := session.Identity.Id
+	vars := mux.Vars(r)
+	taskId := vars["id"]
+
+	if !ownsTask(r.Context(), userId, taskId) {
+		http.Error(w, `{"error": "Task not found"}`, http.StatusNotFound)
+		return
+	}
+
+	entry, err := store.Timers().Start(r.Context(), taskId, userId)
+	if errors.Is(err, ErrConflict) {
+		http.Error(w, `{"error": "A timer is already running"}`, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+}
+
+// POST /tasks/{id}/timer/stop
+func stopTimer(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+
+	vars := mux.Vars(r)
+	taskId := vars["id"]
+
+	entry, err := store.Timers().Stop(r.Context(), taskId, session.Identity.Id)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, `{"error": "No running timer for task"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+}
+
+// Parses the from/to query parameters, defaulting to the past week.
+func parseRange(r *http.Request) (time.Time, time.Time, bool) {
+	to := time.Now().UTC()
+	if value := r.URL.Query().Get("to"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return time.Time{}, time.Time{}, false
+		}
+		to = parsed
+	}
+
+	from := to.AddDate(0, 0, -7)
+	if value := r.URL.Query().Get("from"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return time.Time{}, time.Time{}, false
+		}
+		from = parsed
+	}
+
+	return from, to, from.Before(to)
+}
+
+// GET /reports/time
+func getTimeReport(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+
+	userId := session.Identity.Id
+	from, to, ok := parseRange(r)
+	if !ok {
+		http.Error(w, `{"error": "Invalid date range"}`, http.StatusBadRequest)
+		return
+	}
+
+	report, err := store.Reports().TimeReport(r.Context(), userId, from, to)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
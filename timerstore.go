@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+type PostgresTimerStore struct {
+	db Querier
+}
+
+// Minutes elapsed for an entry, counting a running timer up to now.
+var timeEntryMinutes = `(EXTRACT(EPOCH FROM COALESCE(stopped_at, CURRENT_TIMESTAMP) - started_at) / 60)::int`
+
+var timeEntryColumns = "id, task_id, user_id, started_at, stopped_at, " + timeEntryMinutes
+
+func scanTimeEntry(row scanner) (TimeEntry, error) {
+	var entry TimeEntry
+	var startedAt time.Time
+	var stoppedAt sql.NullTime
+	err := row.Scan(&entry.Id, &entry.TaskId, &entry.UserId, &startedAt, &stoppedAt, &entry.Minutes)
+	entry.StartedAt = timestamp(startedAt)
+	entry.StoppedAt = nullTimestamp(stoppedAt)
+	return entry, err
+}
+
+func (s *PostgresTimerStore) Running(ctx context.Context, userId string) (TimeEntry, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	entry, err := scanTimeEntry(s.db.QueryRow(ctx,
+		`
+		SELECT `+timeEntryColumns+`
+		FROM time_entries
+		WHERE user_id = $1 AND stopped_at IS NULL
+		`,
+		userId,
+	))
+	if err == pgx.ErrNoRows {
+		return TimeEntry{}, ErrNotFound
+	}
+	return entry, err
+}
+
+func (s *PostgresTimerStore) ListForTask(ctx context.Context, taskId string, userId string) ([]TimeEntry, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	rows, err := s.db.Query(ctx,
+		`
+		SELECT `+timeEntryColumns+`
+		FROM time_entries
+		WHERE task_id = $1 AND user_id = $2
+		ORDER BY started_at DESC
+		`,
+		taskId,
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []TimeEntry{}
+	for rows.Next() {
+		entry, err := scanTimeEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// Only one timer runs at a time, so starting a new one stops the current one.
+func (s *PostgresTimerStore) Start(ctx context.Context, taskId string, userId string) (TimeEntry, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	var entry TimeEntry
+	err := withTransaction(ctx, s.db, func(q Querier) error {
+		_, err := q.Exec(ctx,
+			`
+			UPDATE time_entries
+			SET stopped_at = CURRENT_TIMESTAMP
+			WHERE user_id = $1 AND stopped_at IS NULL
+			`,
+			userId,
+		)
+		if err != nil {
+			return err
+		}
+
+		entry, err = scanTimeEntry(q.QueryRow(ctx,
+			`
+			INSERT INTO time_entries (id, task_id, user_id)
+			VALUES ($1, $2, $3)
+			ON CONFLICT DO NOTHING
+			RETURNING `+timeEntryColumns,
+			uuid.New().String(),
+			taskId,
+			userId,
+		))
+		return err
+	})
+	// Another request started a timer between the two statements
+	if err == pgx.ErrNoRows {
+		return TimeEntry{}, ErrConflict
+	}
+	return entry, err
+}
+
+func (s *PostgresTimerStore) Stop(ctx context.Context, taskId string, userId string) (TimeEntry, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	entry, err := scanTimeEntry(s.db.QueryRow(ctx,
+		`
+		UPDATE time_entries
+		SET stopped_at = CURRENT_TIMESTAMP
+		WHERE task_id = $1 AND user_id = $2 AND stopped_at IS NULL
+		RETURNING `+timeEntryColumns,
+		taskId,
+		userId,
+	))
+	if err == pgx.ErrNoRows {
+		return TimeEntry{}, ErrNotFound
+	}
+	return entry, err
+}
+
+func (s *PostgresTimerStore) TrackedMinutes(ctx context.Context, userId string) (map[string]int, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	rows, err := s.db.Query(ctx,
+		`
+		SELECT task_id, SUM(`+timeEntryMinutes+`)::int
+		FROM time_entries
+		WHERE user_id = $1
+		GROUP BY task_id
+		`,
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	minutes := make(map[string]int)
+	for rows.Next() {
+		var taskId string
+		var total int
+		if err := rows.Scan(&taskId, &total); err != nil {
+			return nil, err
+		}
+		minutes[taskId] = total
+	}
+	return minutes, rows.Err()
+}
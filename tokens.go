@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Personal access tokens let scripts and integrations call the API with an
+// Authorization: Bearer header instead of a browser session. Only a hash of
+// each token is stored; the token itself is shown once, when it is created.
+type AccessToken struct {
+	Id         string   `json:"id"`
+	UserId     string   `json:"-"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	ExpiresAt  *string  `json:"expiresAt"`
+	LastUsedAt *string  `json:"lastUsedAt"`
+	CreatedAt  string   `json:"createdAt"`
+}
+
+type CreateAccessTokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// Days until the token expires. Zero means it never does.
+	ExpiresIn int `json:"expiresIn"`
+}
+
+// The resources a token can be limited to, by the route templates that act on
+// them. Routes that aren't listed, like /tokens and /admin, can't be used with
+// a token at all.
+var routeResources = map[string]string{
+	"/users": "users",
+
+	"/events":            "events",
+	"/events/{id}":       "events",
+	"/events/generate":   "events",
+	"/events/{id}/share": "events",
+
+	"/tasks":      "tasks",
+	"/tasks/{id}": "tasks",
+
+	"/timer":                   "timers",
+	"/tasks/{id}/timer/start":  "timers",
+	"/tasks/{id}/timer/stop":   "timers",
+	"/tasks/{id}/time-entries": "timers",
+	"/reports/time":            "reports",
+	"/analytics":               "reports",
+
+	"/calendars":                       "calendars",
+	"/calendars/{id}":                  "calendars",
+	"/calendars/{id}/members":          "calendars",
+	"/calendars/{id}/members/{userId}": "calendars",
+
+	"/labels":                       "labels",
+	"/labels/{id}":                  "labels",
+	"/events/{id}/labels":           "labels",
+	"/events/{id}/labels/{labelId}": "labels",
+	"/tasks/{id}/labels":            "labels",
+	"/tasks/{id}/labels/{labelId}":  "labels",
+
+	"/reminders/{id}":           "reminders",
+	"/reminders/{id}/snooze":    "reminders",
+	"/events/{id}/reminders":    "reminders",
+	"/tasks/{id}/reminders":     "reminders",
+	"/calendars/{id}/reminders": "reminders",
+
+	"/notifications":           "notifications",
+	"/notifications/{id}/read": "notifications",
+
+	"/settings": "settings",
+}
+
+// "read" allows GET requests to every resource and "write" every other method.
+// "<resource>:read" and "<resource>:write" do the same for one resource.
+var accessTokenScopes = func() []string {
+	scopes := []string{"read", "write"}
+	for _, resource := range routeResources {
+		for _, access := range []string{"read", "write"} {
+			if scope := resource + ":" + access; !slices.Contains(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	slices.Sort(scopes)
+	return scopes
+}()
+
+var accessTokenPrefix = "pat_"
+
+func generateAccessToken() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return accessTokenPrefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// Reports whether a token session may make the request, which must already be
+// routed. Cookie sessions have no scopes and may make any request.
+func (session *Session) Allows(r *http.Request) bool {
+	if session.Scopes == nil {
+		return true
+	}
+
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return false
+	}
+	resource, ok := routeResources[template]
+	if !ok {
+		return false
+	}
+
+	access := "write"
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		access = "read"
+	}
+	return slices.Contains(session.Scopes, access) || slices.Contains(session.Scopes, resource+":"+access)
+}
+
+// Looks up the user a bearer token belongs to. Like the identity providers,
+// returns a nil session for tokens that are unknown or expired.
+// Caches token lookups like sessionCache does Kratos sessions, with a shorter TTL
+// since revoking a token only clears the entry on the replica that handled it.
+var tokenSessionCache *SessionCache
+
+// How often a token's last_used_at is written while it is in use.
+var tokenUseInterval = time.Minute
+
+// When each token's use was last written by this replica, by hash
+var tokenUses = struct {
+	sync.Mutex
+	recorded map[string]time.Time
+}{recorded: make(map[string]time.Time)}
+
+// Writes last_used_at for the token, at most once per tokenUseInterval, so
+// requests served from the cache still count without each one hitting the
+// database.
+func recordTokenUse(ctx context.Context, tokenHash string) {
+	now := time.Now()
+
+	tokenUses.Lock()
+	if recorded, ok := tokenUses.recorded[tokenHash]; ok && now.Sub(recorded) < tokenUseInterval {
+		tokenUses.Unlock()
+		return
+	}
+	tokenUses.recorded[tokenHash] = now
+	tokenUses.Unlock()
+
+	if err := store.Tokens().Touch(ctx, tokenHash); err != nil && !errors.Is(err, ErrNotFound) {
+		log.Printf("Error recording access token use: %v", err)
+	}
+}
+
+func forgetTokenUse(tokenHash string) {
+	tokenUses.Lock()
+	defer tokenUses.Unlock()
+
+	delete(tokenUses.recorded, tokenHash)
+}
+
+func fetchTokenSession(ctx context.Context, token string) (*Session, error) {
+	accessToken, err := store.Tokens().Find(ctx, hashToken(token))
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user := GetUser(ctx, accessToken.UserId)
+	if user == nil {
+		return nil, errors.New("unable to look up token owner")
+	}
+
+	session := &Session{
+		Id:       accessToken.Id,
+		Active:   true,
+		Identity: user.Identity(),
+		TokenId:  accessToken.Id,
+		Scopes:   accessToken.Scopes,
+	}
+	if accessToken.ExpiresAt != nil {
+		if expiresAt, err := time.Parse(time.RFC3339, *accessToken.ExpiresAt); err == nil {
+			session.ExpiresAt = expiresAt
+		}
+	}
+	return session, nil
+}
+
+// GET /tokens
+func getAccessTokens(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+
+	tokens, err := store.Tokens().ListForUser(r.Context(), session.Identity.Id)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// POST /tokens
+// The response is the only time the token is shown.
+func createAccessToken(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+
+	var request CreateAccessTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(request.Name) == "" {
+		http.Error(w, `{"error": "Name is required"}`, http.StatusBadRequest)
+		return
+	}
+	if len(request.Scopes) == 0 {
+		http.Error(w, `{"error": "At least one scope is required"}`, http.StatusBadRequest)
+		return
+	}
+	for _, scope := range request.Scopes {
+		if !slices.Contains(accessTokenScopes, scope) {
+			http.Error(w, `{"error": "Unknown scope"}`, http.StatusBadRequest)
+			return
+		}
+	}
+	if request.ExpiresIn < 0 {
+		http.Error(w, `{"error": "Invalid expiry"}`, http.StatusBadRequest)
+		return
+	}
+
+	token, err := generateAccessToken()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	slices.Sort(request.Scopes)
+	accessToken := AccessToken{
+		Id:        uuid.New().String(),
+		UserId:    session.Identity.Id,
+		Name:      strings.TrimSpace(request.Name),
+		Scopes:    slices.Compact(request.Scopes),
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	if request.ExpiresIn > 0 {
+		expiresAt := time.Now().UTC().AddDate(0, 0, request.ExpiresIn).Format(time.RFC3339)
+		accessToken.ExpiresAt = &expiresAt
+	}
+
+	if err := store.Tokens().Create(r.Context(), accessToken, hashToken(token)); err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Error creating token"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		AccessToken
+		Token string `json:"token"`
+	}{accessToken, token})
+}
+
+// DELETE /tokens/{id}
+func revokeAccessToken(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+	vars := mux.Vars(r)
+	tokenId := vars["id"]
+
+	tokenHash, err := store.Tokens().Revoke(r.Context(), tokenId, session.Identity.Id)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, `{"error": "Token not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// Stop accepting the token right away instead of when its cache entry expires
+	tokenSessionCache.Invalidate(tokenHash)
+	forgetTokenUse(tokenHash)
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Creates a token for the dev user with the scopes.
+func createTestToken(t *testing.T, server *routeRecorder, scopes ...string) (string, string) {
+	t.Helper()
+
+	var created struct {
+		AccessToken
+		Token string `json:"token"`
+	}
+	server.expect(t, testRequest{method: "POST", path: "/tokens", body: CreateAccessTokenRequest{Name: "script", Scopes: scopes}}, http.StatusOK, &created)
+	return created.Id, created.Token
+}
+
+func TestEveryApiRouteHasTokenResource(t *testing.T) {
+	router := setupTestServer(t)
+
+	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil || len(ancestors) == 0 {
+			return nil
+		}
+		// Tokens can't manage tokens or use admin routes
+		if strings.HasPrefix(template, "/tokens") || strings.HasPrefix(template, "/admin") {
+			if _, ok := routeResources[template]; ok {
+				t.Errorf("%s can be used with a token", template)
+			}
+			return nil
+		}
+		if _, ok := routeResources[template]; !ok {
+			t.Errorf("%s has no token resource", template)
+		}
+		return nil
+	})
+}
+
+func TestTokenResourceScopes(t *testing.T) {
+	server := newRouteRecorder(setupTestServer(t))
+
+	server.expect(t, testRequest{method: "POST", path: "/tokens", body: CreateAccessTokenRequest{Name: "script", Scopes: []string{"events:delete"}}}, http.StatusBadRequest, nil)
+
+	_, token := createTestToken(t, server, "events:read", "labels:write")
+	server.expect(t, testRequest{method: "GET", path: "/events", token: token}, http.StatusOK, nil)
+	server.expect(t, testRequest{method: "POST", path: "/events", token: token, body: Event{Title: "Nope"}}, http.StatusForbidden, nil)
+	server.expect(t, testRequest{method: "GET", path: "/tasks", token: token}, http.StatusForbidden, nil)
+	server.expect(t, testRequest{method: "GET", path: "/labels", token: token}, http.StatusForbidden, nil)
+	server.expect(t, testRequest{method: "POST", path: "/labels", token: token, body: Label{Name: "Work"}}, http.StatusOK, nil)
+	server.expect(t, testRequest{method: "GET", path: "/tokens", token: token}, http.StatusForbidden, nil)
+
+	_, token = createTestToken(t, server, "read", "write")
+	server.expect(t, testRequest{method: "GET", path: "/tasks", token: token}, http.StatusOK, nil)
+	server.expect(t, testRequest{method: "GET", path: "/admin/mail", token: token}, http.StatusForbidden, nil)
+	server.expect(t, testRequest{method: "POST", path: "/tokens", token: token, body: CreateAccessTokenRequest{Name: "script", Scopes: []string{"read"}}}, http.StatusForbidden, nil)
+}
+
+func TestRevokedTokenFailsOnOtherReplicas(t *testing.T) {
+	server := newRouteRecorder(setupTestServer(t))
+	tokenSessionCache = NewSessionCache(50*time.Millisecond, time.Second, 100)
+
+	id, token := createTestToken(t, server, "read")
+	server.expect(t, testRequest{method: "GET", path: "/events", token: token}, http.StatusOK, nil)
+
+	// Revoke it the way another replica would, leaving this replica's cache as is
+	if _, err := store.Tokens().Revoke(context.Background(), id, devUser.Id); err != nil {
+		t.Fatal(err)
+	}
+	server.expect(t, testRequest{method: "GET", path: "/events", token: token}, http.StatusOK, nil)
+
+	time.Sleep(100 * time.Millisecond)
+	server.expect(t, testRequest{method: "GET", path: "/events", token: token}, http.StatusUnauthorized, nil)
+}
+
+func TestTokenUseIsRecordedFromTheCache(t *testing.T) {
+	server := newRouteRecorder(setupTestServer(t))
+	memory := store.(*MemoryStore)
+	now := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	memory.now = func() time.Time { return now }
+
+	lastUsedAt := func() string {
+		t.Helper()
+		var tokens []AccessToken
+		server.expect(t, testRequest{method: "GET", path: "/tokens"}, http.StatusOK, &tokens)
+		if len(tokens) != 1 || tokens[0].LastUsedAt == nil {
+			t.Fatalf("got %+v, want one used token", tokens)
+		}
+		return *tokens[0].LastUsedAt
+	}
+
+	_, token := createTestToken(t, server, "read")
+	server.expect(t, testRequest{method: "GET", path: "/events", token: token}, http.StatusOK, nil)
+	first := lastUsedAt()
+
+	// Served from the cache and within the interval, so nothing is written
+	now = now.Add(time.Minute)
+	server.expect(t, testRequest{method: "GET", path: "/events", token: token}, http.StatusOK, nil)
+	if got := lastUsedAt(); got != first {
+		t.Fatalf("last used at %s, want %s", got, first)
+	}
+
+	tokenUseInterval = 0
+	server.expect(t, testRequest{method: "GET", path: "/events", token: token}, http.StatusOK, nil)
+	if got := lastUsedAt(); got != timestamp(now) {
+		t.Fatalf("last used at %s, want %s", got, timestamp(now))
+	}
+}
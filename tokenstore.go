@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+type PostgresTokenStore struct {
+	db Querier
+}
+
+var accessTokenColumns = "id, user_id, name, scopes, expires_at, last_used_at, created_at"
+
+func scanAccessToken(row scanner) (AccessToken, error) {
+	var token AccessToken
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+	var createdAt time.Time
+	err := row.Scan(&token.Id, &token.UserId, &token.Name, &scopes, &expiresAt, &lastUsedAt, &createdAt)
+	token.Scopes = strings.Split(scopes, ",")
+	token.ExpiresAt = nullTimestamp(expiresAt)
+	token.LastUsedAt = nullTimestamp(lastUsedAt)
+	token.CreatedAt = timestamp(createdAt)
+	return token, err
+}
+
+func (s *PostgresTokenStore) ListForUser(ctx context.Context, userId string) ([]AccessToken, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	rows, err := s.db.Query(ctx,
+		`
+		SELECT `+accessTokenColumns+` FROM personal_access_tokens
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		`,
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []AccessToken{}
+	for rows.Next() {
+		token, err := scanAccessToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *PostgresTokenStore) Create(ctx context.Context, token AccessToken, tokenHash string) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	var expiresAt sql.NullTime
+	if token.ExpiresAt != nil {
+		parsed, err := time.Parse(time.RFC3339, *token.ExpiresAt)
+		if err != nil {
+			return err
+		}
+		expiresAt = sql.NullTime{Time: parsed, Valid: true}
+	}
+
+	_, err := s.db.Exec(ctx,
+		`
+		INSERT INTO personal_access_tokens (id, user_id, name, token_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		`,
+		token.Id,
+		token.UserId,
+		token.Name,
+		tokenHash,
+		strings.Join(token.Scopes, ","),
+		expiresAt,
+	)
+	return err
+}
+
+func (s *PostgresTokenStore) Find(ctx context.Context, tokenHash string) (AccessToken, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	token, err := scanAccessToken(s.db.QueryRow(ctx,
+		`
+		SELECT `+accessTokenColumns+` FROM personal_access_tokens
+		WHERE token_hash = $1 AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+		`,
+		tokenHash,
+	))
+	if err == pgx.ErrNoRows {
+		return AccessToken{}, ErrNotFound
+	}
+	return token, err
+}
+
+func (s *PostgresTokenStore) Touch(ctx context.Context, tokenHash string) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	return expectRows(s.db.Exec(ctx,
+		"UPDATE personal_access_tokens SET last_used_at = CURRENT_TIMESTAMP WHERE token_hash = $1",
+		tokenHash,
+	))
+}
+
+func (s *PostgresTokenStore) Revoke(ctx context.Context, id string, userId string) (string, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return "", ErrNotFound
+	}
+
+	var tokenHash string
+	err := s.db.QueryRow(ctx,
+		`
+		DELETE FROM personal_access_tokens
+		WHERE id = $1 AND user_id = $2
+		RETURNING token_hash
+		`,
+		id,
+		userId,
+	).Scan(&tokenHash)
+	if err == pgx.ErrNoRows {
+		return "", ErrNotFound
+	}
+	return tokenHash, err
+}
+
+func (s *PostgresTokenStore) HashesForUser(ctx context.Context, userId string) ([]string, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	rows, err := s.db.Query(ctx, "SELECT token_hash FROM personal_access_tokens WHERE user_id = $1", userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hashes := []string{}
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
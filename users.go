@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A row of the local users table, which mirrors the identity provider so user
+// search doesn't need a round trip to it.
+type UserSearchResult struct {
+	Id           string
+	Email        string
+	FirstName    string
+	LastName     string
+	Username     string
+	Avatar       string
+	Collaborator bool
+}
+
+var userSearchPageSize = 20
+
+// The shortest query that finds users who don't share a calendar with the
+// requester, so nobody can page through everyone a letter at a time.
+var minUserSearchLength = 3
+
+// Copies every user from the identity provider into the users table, and drops
+// the ones that no longer exist there.
+func SyncUsers(ctx context.Context) error {
+	// The OIDC provider already reads its users from the table
+	if _, ok := identityProvider.(*OIDCProvider); ok {
+		return nil
+	}
+
+	// Users that are missing from the listing are deleted, so a partial listing
+	// must never get this far
+	users, err := identityProvider.Users(ctx)
+	if err != nil {
+		return err
+	}
+	// An empty listing is far more likely a misconfigured provider than every
+	// account being gone, and deleting on it would wipe the table
+	if len(users) == 0 {
+		log.Println("Identity provider listed no users, keeping the local copy")
+		return nil
+	}
+
+	return store.Transaction(ctx, func(tx Store) error {
+		if err := tx.Users().Save(ctx, users...); err != nil {
+			return err
+		}
+
+		ids := []string{}
+		for _, user := range users {
+			ids = append(ids, user.Id)
+		}
+		return tx.Users().DeleteExcept(ctx, ids)
+	})
+}
+
+// Runs until the process exits, syncing users every interval.
+func RunUserSync(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := SyncUsers(context.Background()); err != nil {
+			log.Println("Error syncing users:", err)
+		}
+		<-ticker.C
+	}
+}
+
+// Escapes LIKE wildcards so they match literally.
+func likePrefix(query string) string {
+	query = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query)
+	return query + "%"
+}
+
+// GET /users?q=&page=
+// Searches users by prefix of their email, username or name. People who share a
+// calendar with the requester are found by any of these. Everyone else is only
+// found by username or name once the query is minUserSearchLength long, or by
+// their exact email, and their email is hidden unless it was searched for.
+func getUsers(w http.ResponseWriter, r *http.Request) {
+	session := currentSession(r)
+	query := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+
+	page := 1
+	if value := r.URL.Query().Get("page"); value != "" {
+		var err error
+		page, err = strconv.Atoi(value)
+		if err != nil || page < 1 {
+			http.Error(w, `{"error": "Invalid page"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	results, err := store.Users().Search(r.Context(), session.Identity.Id, query, userSearchPageSize, (page-1)*userSearchPageSize)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	users := []User{}
+	for _, result := range results {
+		user := User{
+			Id:        result.Id,
+			FirstName: result.FirstName,
+			LastName:  result.LastName,
+			Username:  result.Username,
+			Avatar:    result.Avatar,
+		}
+		if result.Collaborator || strings.EqualFold(result.Email, query) {
+			user.Email = result.Email
+		}
+		users = append(users, user)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
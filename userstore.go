@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type PostgresUserStore struct {
+	db Querier
+}
+
+var userColumns = "id, email, first_name, last_name, username, avatar"
+
+func scanUser(row scanner) (User, error) {
+	var user User
+	err := row.Scan(&user.Id, &user.Email, &user.FirstName, &user.LastName, &user.Username, &user.Avatar)
+	return user, err
+}
+
+func (s *PostgresUserStore) Save(ctx context.Context, users ...User) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	return withTransaction(ctx, s.db, func(q Querier) error {
+		for _, user := range users {
+			_, err := q.Exec(ctx,
+				`
+				INSERT INTO users (id, email, first_name, last_name, username, avatar)
+				VALUES ($1, $2, $3, $4, $5, $6)
+				ON CONFLICT (id) DO UPDATE
+				SET email = EXCLUDED.email, first_name = EXCLUDED.first_name, last_name = EXCLUDED.last_name,
+					username = EXCLUDED.username, avatar = EXCLUDED.avatar, updated_at = CURRENT_TIMESTAMP
+				`,
+				user.Id,
+				user.Email,
+				user.FirstName,
+				user.LastName,
+				user.Username,
+				user.Avatar,
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *PostgresUserStore) get(ctx context.Context, query string, arg string) (User, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	user, err := scanUser(s.db.QueryRow(ctx, query, arg))
+	if err == pgx.ErrNoRows {
+		return User{}, ErrNotFound
+	}
+	return user, err
+}
+
+func (s *PostgresUserStore) Get(ctx context.Context, id string) (User, error) {
+	return s.get(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1", id)
+}
+
+func (s *PostgresUserStore) GetByEmail(ctx context.Context, email string) (User, error) {
+	return s.get(ctx, "SELECT "+userColumns+" FROM users WHERE LOWER(email) = LOWER($1)", email)
+}
+
+func (s *PostgresUserStore) List(ctx context.Context) ([]User, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	rows, err := s.db.Query(ctx, "SELECT "+userColumns+" FROM users ORDER BY email ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (s *PostgresUserStore) Search(ctx context.Context, requesterId string, query string, limit int, offset int) ([]UserSearchResult, error) {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	query = strings.ToLower(strings.TrimSpace(query))
+	rows, err := s.db.Query(ctx,
+		`
+		SELECT * FROM (
+			SELECT id, email, first_name, last_name, username, avatar,
+				id = $1 OR id IN (
+					SELECT others.user_id FROM calendar_members AS mine
+					JOIN calendar_members AS others ON others.calendar_id = mine.calendar_id
+					WHERE mine.user_id = $1
+				) AS collaborator
+			FROM users
+		) AS users
+		WHERE (
+			(LOWER(username) LIKE $2 OR LOWER(first_name) LIKE $2 OR LOWER(last_name) LIKE $2
+				OR LOWER(first_name || ' ' || last_name) LIKE $2)
+			AND (collaborator OR LENGTH($3) >= $6)
+		)
+		OR (collaborator AND LOWER(email) LIKE $2)
+		OR LOWER(email) = $3
+		ORDER BY collaborator DESC, LOWER(first_name) ASC, LOWER(last_name) ASC, email ASC
+		LIMIT $4 OFFSET $5
+		`,
+		requesterId,
+		likePrefix(query),
+		query,
+		limit,
+		offset,
+		minUserSearchLength,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []UserSearchResult{}
+	for rows.Next() {
+		var result UserSearchResult
+		err := rows.Scan(
+			&result.Id,
+			&result.Email,
+			&result.FirstName,
+			&result.LastName,
+			&result.Username,
+			&result.Avatar,
+			&result.Collaborator,
+		)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+func (s *PostgresUserStore) DeleteExcept(ctx context.Context, ids []string) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	_, err := s.db.Exec(ctx, "DELETE FROM users WHERE NOT (id = ANY($1))", ids)
+	return err
+}
+
+func (s *PostgresUserStore) Delete(ctx context.Context, id string) error {
+	ctx, cancel := databaseContext(ctx)
+	defer cancel()
+
+	return withTransaction(ctx, s.db, func(q Querier) error {
+		_, err := q.Exec(ctx,
+			`
+			DELETE FROM calendars
+			WHERE id IN (SELECT calendar_id FROM calendar_members WHERE user_id = $1)
+			AND NOT EXISTS (
+				SELECT 1 FROM calendar_members
+				WHERE calendar_members.calendar_id = calendars.id AND calendar_members.user_id != $1
+			)
+			`,
+			id,
+		)
+		if err != nil {
+			return err
+		}
+
+		_, err = q.Exec(ctx,
+			`
+			UPDATE calendars
+			SET user_id = (
+				SELECT user_id FROM calendar_members
+				WHERE calendar_members.calendar_id = calendars.id AND calendar_members.user_id != $1
+				ORDER BY created_at, user_id
+				LIMIT 1
+			)
+			WHERE user_id = $1
+			`,
+			id,
+		)
+		if err != nil {
+			return err
+		}
+
+		_, err = q.Exec(ctx,
+			`
+			UPDATE events
+			SET user_id = calendars.user_id
+			FROM calendars
+			WHERE calendars.id = events.calendar_id AND events.user_id = $1
+			`,
+			id,
+		)
+		if err != nil {
+			return err
+		}
+
+		// Tasks take their labels, time entries and reminders with them
+		statements := []string{
+			"DELETE FROM calendar_members WHERE user_id = $1",
+			"DELETE FROM tasks WHERE user_id = $1",
+			"DELETE FROM labels WHERE user_id = $1",
+			"DELETE FROM reminders WHERE user_id = $1",
+			"DELETE FROM notifications WHERE user_id = $1",
+			"DELETE FROM time_entries WHERE user_id = $1",
+			"DELETE FROM digest_deliveries WHERE user_id = $1",
+			"DELETE FROM user_settings WHERE user_id = $1",
+			"DELETE FROM personal_access_tokens WHERE user_id = $1",
+			"DELETE FROM users WHERE id = $1",
+		}
+		for _, statement := range statements {
+			if _, err := q.Exec(ctx, statement, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
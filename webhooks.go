@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// Kratos calls these after registration, and the identity cleanup job after an
+// identity is deleted, with a body of {"identity": {...}}. Both authenticate
+// with the shared secret in the X-Webhook-Secret header.
+var webhookSecret string
+
+var defaultCalendarName = "Personal"
+var defaultCalendarColor = "#93c4fd"
+
+type IdentityWebhook struct {
+	Identity Identity `json:"identity"`
+}
+
+// Checks the shared secret and decodes the body, writing the error response if
+// either fails.
+func readIdentityWebhook(w http.ResponseWriter, r *http.Request) (*IdentityWebhook, bool) {
+	if webhookSecret == "" {
+		http.Error(w, `{"error": "Webhooks are not configured"}`, http.StatusServiceUnavailable)
+		return nil, false
+	}
+	secret := r.Header.Get("X-Webhook-Secret")
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(webhookSecret)) != 1 {
+		unauthorized(w)
+		return nil, false
+	}
+
+	var webhook IdentityWebhook
+	if err := json.NewDecoder(r.Body).Decode(&webhook); err != nil || webhook.Identity.Id == "" {
+		http.Error(w, `{"error": "Invalid request"}`, http.StatusBadRequest)
+		return nil, false
+	}
+	return &webhook, true
+}
+
+// POST /webhooks/kratos/registration
+// Gives a new user a default calendar and settings. Kratos retries failed
+// webhooks, so registering the same identity twice changes nothing.
+func registrationWebhook(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := readIdentityWebhook(w, r)
+	if !ok {
+		return
+	}
+	identity := webhook.Identity
+	user := identity.User()
+
+	err := store.Transaction(r.Context(), func(tx Store) error {
+		if err := tx.Users().Save(r.Context(), user); err != nil {
+			return err
+		}
+		if _, err := tx.Settings().Ensure(r.Context(), identity.Id); err != nil {
+			return err
+		}
+
+		hasDefault, err := tx.Calendars().HasDefault(r.Context(), identity.Id)
+		if err != nil || hasDefault {
+			return err
+		}
+
+		calendar := Calendar{
+			Id:        uuid.New().String(),
+			Name:      defaultCalendarName,
+			Color:     defaultCalendarColor,
+			IsDefault: true,
+		}
+		return tx.Calendars().Create(r.Context(), calendar, identity.Id)
+	})
+	if err != nil {
+		log.Printf("Error provisioning user %s: %v", identity.Id, err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// POST /webhooks/kratos/deletion
+// Removes everything that belonged only to the deleted user. Calendars they
+// shared, and the events they created in them, pass to the longest-standing
+// remaining member; calendars nobody else is in are deleted along with their
+// events.
+func deletionWebhook(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := readIdentityWebhook(w, r)
+	if !ok {
+		return
+	}
+	userId := webhook.Identity.Id
+
+	tokenHashes, err := store.Tokens().HashesForUser(r.Context(), userId)
+	if err != nil {
+		log.Printf("Error cleaning up user %s: %v", userId, err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	err = store.Users().Delete(r.Context(), userId)
+	if err != nil {
+		log.Printf("Error cleaning up user %s: %v", userId, err)
+		http.Error(w, `{"error": "Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	for _, tokenHash := range tokenHashes {
+		tokenSessionCache.Invalidate(tokenHash)
+		forgetTokenUse(tokenHash)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}